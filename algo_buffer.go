@@ -1,12 +1,17 @@
 package siam
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
@@ -26,19 +31,20 @@ import (
 // given explicitly by creating an client.AlgorandClient via client.CreateAlgorandClientWrapper.
 //
 // An example of how to instantiate an AlgorandBuffer:
-//   // These are the three important config values
-//   url := "191.162.6.16:1337"
-//   token := "efc54yxeda5o9apret6nermlar2ehn6tsikrh5oea5atnirs56klorki"
-//   privKey := "z2BGxfLJ...1IWsvNKRFw8bLQUnK2nRa+YmLNvQCA=="
 //
-//   client, err := client.CreateAlgorandClientWrapper(url, token)
-//   buffer, err := NewAlgorandBuffer(client, privKey)
+//	// These are the three important config values
+//	url := "191.162.6.16:1337"
+//	token := "efc54yxeda5o9apret6nermlar2ehn6tsikrh5oea5atnirs56klorki"
+//	privKey := "z2BGxfLJ...1IWsvNKRFw8bLQUnK2nRa+YmLNvQCA=="
+//
+//	client, err := client.CreateAlgorandClientWrapper(url, token)
+//	buffer, err := NewAlgorandBuffer(client, privKey)
 type AlgorandBuffer struct {
 	// AppId is the ID of Algorand application this buffer publishes to.
 	AppId uint64
 
-	// AccountCrypt is the owner of the buffer's Algorand application.
-	AccountCrypt crypto.Account
+	// Signer signs the transactions of the owner of the buffer's Algorand application.
+	Signer client.Signer
 
 	// Client is the wrapping interface for communicating with the node
 	Client client.AlgorandClient
@@ -54,14 +60,149 @@ type AlgorandBuffer struct {
 	// timeoutLength is the default duration for Client requests like
 	// Health() or Status() to timeout.
 	timeoutLength time.Duration
+
+	// journal records intended writes before they are sent to the node, so they
+	// can be reconciled after a crash. nil if no journal is configured.
+	journal *Journal
+
+	// eviction selects keys to delete when the application is full. nil if
+	// writes should fail instead.
+	eviction EvictionPolicy
+
+	// onChainExpiry stores the expiry of keys written with PutWithTTL inside
+	// their values.
+	onChainExpiry bool
+
+	// metadata maintains the reserved metadata keys with every write.
+	metadata bool
+
+	// checksum maintains the ChecksumKey with every write.
+	checksum bool
+
+	// codecs transform values before they are stored, see WithValueCodec.
+	codecs []ValueCodec
+
+	// writeHooks are called after every transaction, see WithWriteHook.
+	writeHooks []func(WriteResult)
+
+	// writer is set if the signer isn't the creator of the application, but on its
+	// writer allowlist, see WithApplication. All keys of the writer must start with
+	// writerPrefix.
+	writer       bool
+	writerPrefix string
+
+	// startup determines how the applications of the account are treated when the
+	// buffer is created.
+	startup StartupPolicy
+
+	// keyMeta holds the local eviction metadata of every key written by this
+	// buffer. Guarded by metaMu.
+	keyMeta map[string]*KeyInfo
+
+	// namespaces holds the prefixes of all namespaces created on this buffer, and
+	// quotas maps them to their slot quota. Guarded by metaMu.
+	namespaces map[string]bool
+	quotas     map[string]int
+	metaMu     sync.Mutex
 }
 
-// PrintNewAccount will randomly generate a new account, and print the base64-encoded
-// private key, as well as the corresponding public Algorand address.
-func PrintNewAccount() {
+// BufferOption configures optional behavior of an AlgorandBuffer. Options are passed
+// to NewAlgorandBuffer or NewAlgorandBufferFromEnv, and applied before the remote
+// state is validated.
+type BufferOption func(*AlgorandBuffer) error
+
+// WithJournal attaches a write-ahead journal stored at the given path. Every write
+// is recorded before it is sent, and pending writes of a previous run are replayed
+// or reconciled against the chain when the buffer is created.
+func WithJournal(path string) BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		j, err := OpenJournal(path)
+		if err != nil {
+			return err
+		}
+		ab.journal = j
+		return nil
+	}
+}
+
+// StartupPolicy determines how NewAlgorandBuffer treats the applications of the target
+// account.
+type StartupPolicy int
+
+const (
+	// StartupManage deletes applications that don't fulfil the schema of the contract,
+	// and creates an application if the account has none. This is the default.
+	StartupManage StartupPolicy = iota
+
+	// StartupExisting requires the account to own exactly one valid application. No
+	// application is created or deleted. If the account owns none, *NoApplication is
+	// returned, if it owns several, *TooManyApplications.
+	StartupExisting
+)
+
+// WithStartupPolicy sets the StartupPolicy of the buffer.
+func WithStartupPolicy(p StartupPolicy) BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		ab.startup = p
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of requests like Health() or reading the state. The
+// default is client.AlgorandDefaultTimeout.
+func WithTimeout(d time.Duration) BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		if d <= 0 {
+			return fmt.Errorf("timeout must be positive, got %s", d)
+		}
+		ab.timeoutLength = d
+		return nil
+	}
+}
+
+// WriteResult describes a single write transaction of an AlgorandBuffer.
+type WriteResult struct {
+	Time  time.Time
+	AppId uint64
+
+	// Put and Deleted are the keys written and deleted by the transaction, including
+	// reserved keys.
+	Put     []string
+	Deleted []string
+
+	// Err is set if the transaction failed.
+	Err error
+}
+
+// WithWriteHook registers a function that is called after every write transaction,
+// successful or not. Writes that fail before a transaction is sent, e.g. because the
+// keys don't fit or a value can't be encoded, are reported too. The hook runs
+// synchronously, so it shouldn't block.
+func WithWriteHook(hook func(WriteResult)) BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		ab.writeHooks = append(ab.writeHooks, hook)
+		return nil
+	}
+}
+
+// PrintNewAccount will randomly generate a new account, and print the corresponding
+// public Algorand address and the private key in the given formats. Without formats,
+// the base64-encoded private key is printed.
+//
+//	siam.PrintNewAccount(siam.FormatMnemonic, siam.FormatKeystore(password))
+func PrintNewAccount(formats ...KeyFormat) {
 	acc := crypto.GenerateAccount()
-	pk := base64.StdEncoding.EncodeToString(acc.PrivateKey)
-	fmt.Printf("Public Address: %s\nPrivate Key: %s\n", acc.Address.String(), pk)
+	if len(formats) == 0 {
+		formats = []KeyFormat{FormatBase64}
+	}
+	fmt.Printf("Public Address: %s\n", acc.Address.String())
+	for _, f := range formats {
+		encoded, err := f.Encode(acc.PrivateKey)
+		if err != nil {
+			encoded = "error: " + err.Error()
+		}
+		fmt.Printf("%s: %s\n", f.Name, encoded)
+	}
 }
 
 // NewAlgorandBufferFromEnv creates an AlgorandBuffer from environment variables.
@@ -70,34 +211,48 @@ func PrintNewAccount() {
 // in client.GetAlgorandEnvironmentVars. You can pass your own logger to be used. If you
 // pass nil, no logging will occur.
 //
+// The private key is read from the file of client.EnvKeyFile or the keystore of
+// client.EnvKeystore if set, and from client.EnvPrivateKey otherwise.
+//
 // This method uses the client.CreateAlgorandClientWrapper implementation. If you want to
 // use your own implementation of client.AlgorandClient, use NewAlgorandBuffer instead.
-func NewAlgorandBufferFromEnv() (*AlgorandBuffer, error) {
+func NewAlgorandBufferFromEnv(opts ...BufferOption) (*AlgorandBuffer, error) {
 	if !client.HasEnvironmentVars() {
 		return nil, errors.New("configuration variables are not set. See README")
 	}
-	url, token, base64key, headers := client.GetAlgorandEnvironmentVars()
+	url, token, _, headers := client.GetAlgorandEnvironmentVars()
+	key, err := envKeySource()
+	if err != nil {
+		return nil, err
+	}
 	if len(headers) != 0 {
 		a, err := client.NewClientWithHeaders(url, token, headers)
 		if err != nil {
 			return nil, err
 		}
-		return NewAlgorandBuffer(a, base64key)
+		return NewAlgorandBufferWithKey(a, key, opts...)
 	}
 	a, err := client.CreateAlgorandClientWrapper(url, token)
 	if err != nil {
 		return nil, err
 	}
-	return NewAlgorandBuffer(a, base64key)
+	return NewAlgorandBufferWithKey(a, key, opts...)
 }
 
 // NewAlgorandBuffer creates a new instance of AlgorandBuffer. The buffer requires an
 // client.AlgorandClient to perform persistence and setup operations on the Algorand blockchain.
 // base64key is the base64-encoded private key of the 'target account'. The target account
-// creates and maintains the applications state on the blockchain.
-func NewAlgorandBuffer(c client.AlgorandClient, b64key string) (*AlgorandBuffer, error) {
-	// Decode Base64 private key
-	pk, err := base64.StdEncoding.DecodeString(b64key)
+// creates and maintains the applications state on the blockchain. Optional behavior
+// can be configured by passing BufferOptions.
+func NewAlgorandBuffer(c client.AlgorandClient, b64key string, opts ...BufferOption) (*AlgorandBuffer, error) {
+	return NewAlgorandBufferWithKey(c, Base64Key(b64key), opts...)
+}
+
+// NewAlgorandBufferWithKey creates a new instance of AlgorandBuffer like NewAlgorandBuffer,
+// reading the private key of the target account from the given KeySource, e.g. a
+// MnemonicKey, KeyFile or Keystore.
+func NewAlgorandBufferWithKey(c client.AlgorandClient, key KeySource, opts ...BufferOption) (*AlgorandBuffer, error) {
+	pk, err := key.PrivateKey()
 	if err != nil {
 		return nil, err
 	}
@@ -106,13 +261,16 @@ func NewAlgorandBuffer(c client.AlgorandClient, b64key string) (*AlgorandBuffer,
 	if err != nil {
 		return nil, err
 	}
+	return NewAlgorandBufferWithSigner(c, client.NewAccountSigner(account), opts...)
+}
 
-	buffer := &AlgorandBuffer{
-		Client:          c,
-		AccountCrypt:    account,
-		deleteArguments: make(chan string, 64),
-		storeArguments:  make(chan models.TealKeyValue, 64),
-		timeoutLength:   client.AlgorandDefaultTimeout,
+// NewAlgorandBufferWithSigner creates a new instance of AlgorandBuffer like
+// NewAlgorandBuffer, for the account of the given client.Signer. Use it to keep the
+// private key out of the process, e.g. with a client.KmdSigner or client.RemoteSigner.
+func NewAlgorandBufferWithSigner(c client.AlgorandClient, signer client.Signer, opts ...BufferOption) (*AlgorandBuffer, error) {
+	buffer, err := newBuffer(c, signer, opts...)
+	if err != nil {
+		return buffer, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), client.AlgorandDefaultTimeout)
@@ -121,9 +279,42 @@ func NewAlgorandBuffer(c client.AlgorandClient, b64key string) (*AlgorandBuffer,
 	if err != nil {
 		return buffer, err
 	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), client.AlgorandDefaultTimeout)
+	err = buffer.replayJournal(ctx)
+	cancel()
 	return buffer, err
 }
 
+// newBuffer creates an AlgorandBuffer and applies the given options, without touching
+// the remote state.
+func newBuffer(c client.AlgorandClient, signer client.Signer, opts ...BufferOption) (*AlgorandBuffer, error) {
+	buffer := &AlgorandBuffer{
+		Client:          c,
+		Signer:          signer,
+		deleteArguments: make(chan string, 64),
+		storeArguments:  make(chan models.TealKeyValue, 64),
+		timeoutLength:   client.AlgorandDefaultTimeout,
+		keyMeta:         make(map[string]*KeyInfo),
+		namespaces:      make(map[string]bool),
+		quotas:          make(map[string]int),
+	}
+	for _, opt := range opts {
+		if err := opt(buffer); err != nil {
+			return buffer, err
+		}
+	}
+	return buffer, nil
+}
+
+// Close releases resources held by the buffer, like the journal file.
+func (ab *AlgorandBuffer) Close() error {
+	if ab.journal != nil {
+		return ab.journal.Close()
+	}
+	return nil
+}
+
 // ensureRemoteValid ensures the node is healthy and the target account is in a valid
 // state. To achieve this, it will verify, create or delete applications and store the
 // updated results in the AlgorandBuffer. Call this when initializing the AlgorandBuffer
@@ -136,6 +327,15 @@ func (ab *AlgorandBuffer) ensureRemoteValid(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if err = ab.checkAuthAddr(ctx); err != nil {
+		return err
+	}
+	if ab.AppId != 0 {
+		return ab.useApplication(ctx)
+	}
+	if ab.startup == StartupExisting {
+		return ab.useExisting(ctx)
+	}
 
 	// Deletion Routine
 	err = ab.manageDeletion()
@@ -150,16 +350,56 @@ func (ab *AlgorandBuffer) ensureRemoteValid(ctx context.Context) error {
 	}
 
 	// Set AppID correctly
-	ctx, cancel := context.WithTimeout(context.Background(), ab.timeoutLength)
-	info, err := ab.Client.AccountInformation(ab.AccountCrypt.Address.String(), ctx)
+	infoCtx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+	info, err := ab.Client.AccountInformation(ab.Signer.Address().String(), infoCtx)
 	cancel()
 	if err != nil {
 		return err
 	}
 	ab.AppId = info.CreatedApps[0].Id
+	return ab.checkContract(ctx, info.CreatedApps[0])
+}
+
+// checkContract returns *ErrContractUpgrade if the approval program of the application
+// isn't the compiled client.ApproveTeal.
+func (ab *AlgorandBuffer) checkContract(ctx context.Context, app models.Application) error {
+	ctx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+	compiled, err := ab.Client.TealCompile([]byte(client.ApproveTeal), ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("can't compile the contract: %s", err)
+	}
+	program, err := base64.StdEncoding.DecodeString(compiled.Result)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(program, app.Params.ApprovalProgram) {
+		return &ErrContractUpgrade{AppId: app.Id}
+	}
 	return nil
 }
 
+// useExisting sets the AppId to the only application of the account, without creating
+// or deleting applications.
+func (ab *AlgorandBuffer) useExisting(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+	info, err := ab.Client.AccountInformation(ab.Signer.Address().String(), ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(info.CreatedApps) == 0:
+		return &NoApplication{Address: ab.Signer.Address()}
+	case len(info.CreatedApps) > 1:
+		return &TooManyApplications{Address: ab.Signer.Address(), Apps: info.CreatedApps}
+	case !client.FulfillsSchema(info.CreatedApps[0]):
+		return fmt.Errorf("application %d doesn't fulfil the schema of the contract", info.CreatedApps[0].Id)
+	}
+	ab.AppId = info.CreatedApps[0].Id
+	return ab.checkContract(ctx, info.CreatedApps[0])
+}
+
 // VerifyToken checks whether the URL and provided API token resolve to a correct
 // Algorand node instance.
 func (ab *AlgorandBuffer) VerifyToken() error {
@@ -194,20 +434,79 @@ func (ab *AlgorandBuffer) GetBuffer(ctx context.Context) (map[string]string, err
 }
 
 // GetBufferRaw returns the stored global state of this buffer's associated Algorand application.
+// Reserved keys (see ReservedPrefix) are not included. If the state doesn't match its
+// checksum, ErrTornRead is returned.
 func (ab *AlgorandBuffer) GetBufferRaw(ctx context.Context) (map[string][]byte, error) {
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyChecksum(state); err != nil {
+		return nil, err
+	}
+	return ab.decodeState(state)
+}
+
+// readBuffer returns the decoded state like GetBuffer, but doesn't verify the checksum.
+// It is used to repair states that were left torn by a failed write.
+func (ab *AlgorandBuffer) readBuffer(ctx context.Context) (map[string]string, error) {
+	b, err := ab.readBufferRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(b))
+	for k, v := range b {
+		m[k] = string(v)
+	}
+	return m, nil
+}
+
+// readBufferRaw returns the decoded state like GetBufferRaw, but doesn't verify the
+// checksum.
+func (ab *AlgorandBuffer) readBufferRaw(ctx context.Context) (map[string][]byte, error) {
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ab.decodeState(state)
+}
+
+// decodeState turns the global state as stored on-chain into the state visible to
+// users of the buffer. Reserved keys are removed, and values are decoded.
+func (ab *AlgorandBuffer) decodeState(state map[string][]byte) (map[string][]byte, error) {
+	return decodeValues(state, ab.codecs, ab.onChainExpiry)
+}
+
+// encodeValues turns the given pairs into the values that are stored on-chain. Values
+// are encoded by the configured codecs and, if expires is set and on-chain expiry is
+// enabled, prefixed with their expiry.
+func (ab *AlgorandBuffer) encodeValues(data map[string][]byte, expires time.Time) (map[string][]byte, error) {
+	m := make(map[string][]byte, len(data))
+	for k, v := range data {
+		var err error
+		for _, c := range ab.codecs {
+			if v, err = c.Encode(k, v); err != nil {
+				return nil, err
+			}
+		}
+		if ab.onChainExpiry && !expires.IsZero() {
+			v = EncodeExpiry(v, expires)
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// getGlobalState returns the global state of the buffer's application, with values
+// exactly as they are stored on-chain.
+func (ab *AlgorandBuffer) getGlobalState(ctx context.Context) (map[string][]byte, error) {
 	ctx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
 	app, err := ab.Client.GetApplicationByID(ab.AppId, ctx)
 	cancel()
 	if err != nil {
 		return nil, err
 	}
-	m := make(map[string][]byte)
-	for _, kv := range app.Params.GlobalState {
-		decodedKey, _ := base64.StdEncoding.DecodeString(kv.Key)
-		decodedVal, _ := base64.StdEncoding.DecodeString(kv.Value.Bytes)
-		m[string(decodedKey)] = decodedVal
-	}
-	return m, nil
+	return parseGlobalState(app), nil
 }
 
 // PutElements stores given key-value pairs. Existing keys will be overridden,
@@ -222,56 +521,296 @@ func (ab *AlgorandBuffer) PutElements(ctx context.Context, data map[string]strin
 }
 
 // PutElementsRaw stores given key-value pairs, with []byte values. See PutElements for a
-// convenience function using string values
+// convenience function using string values. If the new keys don't fit into the free
+// slots of the application, an *ErrCapacityExceeded is returned and nothing is written,
+// unless an EvictionPolicy is configured.
 func (ab *AlgorandBuffer) PutElementsRaw(ctx context.Context, data map[string][]byte) error {
-	for k, v := range data {
-		if len(k)+len(v) > 128 {
-			return errors.New("kv pair cannot exceed 128 bytes")
+	return ab.putElements(ctx, data, time.Time{})
+}
+
+// putElements encodes and stores the given pairs. See encodeValues for the meaning
+// of expires.
+func (ab *AlgorandBuffer) putElements(ctx context.Context, data map[string][]byte, expires time.Time) error {
+	for k := range data {
+		if isReserved(k) {
+			return ab.writeFailed(nil, data, &ErrReservedKey{Key: k})
+		}
+		if !strings.HasPrefix(k, ab.writerPrefix) {
+			return ab.writeFailed(nil, data, &ErrWriterPrefix{Key: k, Prefix: ab.writerPrefix})
 		}
 	}
-	// if the number of kv pairs exceed client.MaxKVArgs, we need to split them up
-	// into partitions. One txn for each partition
-	partitions := partitionMapByte(data, client.MaxKVArgs)
-	for _, p := range partitions {
-		kvArray := make([]models.TealKeyValue, 0, client.MaxKVArgs)
-		for k, v := range p {
-			tkv := models.TealKeyValue{Key: k, Value: models.TealValue{Bytes: string(v)}}
-			kvArray = append(kvArray, tkv)
+	encoded, err := ab.encodeValues(data, expires)
+	if err != nil {
+		return ab.writeFailed(nil, data, err)
+	}
+	for k, v := range encoded {
+		if len(k)+len(v) > MaxPairSize {
+			return ab.writeFailed(nil, data, &ErrPairTooLarge{Key: k, Size: len(k) + len(v)})
 		}
-		err := ab.Client.StoreGlobals(ab.AccountCrypt, ab.AppId, kvArray)
+	}
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return ab.writeFailed(nil, data, err)
+	}
+	txns, err := ab.putTxns(state, encoded)
+	if err != nil {
+		return ab.writeFailed(nil, data, err)
+	}
+	return ab.commit(ctx, txns)
+}
+
+// putTxns returns the transactions to store the given encoded pairs on top of the
+// given state. If the new keys don't fit, victims are evicted if an EvictionPolicy is
+// configured, otherwise an *ErrCapacityExceeded is returned.
+func (ab *AlgorandBuffer) putTxns(state map[string][]byte, data map[string][]byte) ([]txnOp, error) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	err := checkCapacity(ab.withReserved(state), keys, nil)
+	if capErr, ok := err.(*ErrCapacityExceeded); ok && ab.eviction != nil {
+		victims, err := ab.selectVictims(state, data, len(capErr.Keys))
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return evictionTxns(state, data, victims), nil
 	}
-	return nil
+	if err != nil {
+		return nil, err
+	}
+	return partitionTxns(data), nil
+}
+
+// partitionTxns splits the given pairs into transactions. If the number of kv pairs
+// exceed client.MaxKVArgs, we need to split them up into partitions. One txn for each
+// partition.
+func partitionTxns(data map[string][]byte) []txnOp {
+	partitions := partitionMapByte(data, client.MaxKVArgs)
+	txns := make([]txnOp, len(partitions))
+	for i, p := range partitions {
+		txns[i] = txnOp{put: p}
+	}
+	return txns
 }
 
+// DeleteElements removes the given keys from the buffer. Keys that don't exist
+// are ignored.
 func (ab *AlgorandBuffer) DeleteElements(ctx context.Context, keys ...string) error {
 	for _, k := range keys {
-		if len(k) > 128 {
-			return errors.New("key can't exceed 128 bytes")
+		if isReserved(k) {
+			return ab.writeFailed(keys, nil, &ErrReservedKey{Key: k})
+		}
+		if !strings.HasPrefix(k, ab.writerPrefix) {
+			return ab.writeFailed(keys, nil, &ErrWriterPrefix{Key: k, Prefix: ab.writerPrefix})
+		}
+		if len(k) > MaxPairSize {
+			return ab.writeFailed(keys, nil, errors.New("key can't exceed 128 bytes"))
 		}
 	}
+	return ab.commit(ctx, deleteTxns(keys))
+}
+
+// deleteTxns splits the given keys into transactions of up to client.MaxArgs keys.
+func deleteTxns(keys []string) []txnOp {
+	txns := make([]txnOp, 0)
 	delArray := make([]string, 0)
 	for _, k := range keys {
 		if len(delArray) == client.MaxArgs {
-			err := ab.Client.DeleteGlobals(ab.AccountCrypt, ab.AppId, delArray...)
-			if err != nil {
-				return err
-			}
+			txns = append(txns, txnOp{del: delArray})
 			delArray = make([]string, 0)
 		}
 		delArray = append(delArray, k)
 	}
 	if len(delArray) > 0 {
-		err := ab.Client.DeleteGlobals(ab.AccountCrypt, ab.AppId, delArray...)
-		if err != nil {
+		txns = append(txns, txnOp{del: delArray})
+	}
+	return txns
+}
+
+// txnOp is a single transaction of a write, which deletes and stores keys.
+type txnOp struct {
+	del []string
+	put map[string][]byte
+}
+
+// args returns the number of application arguments the transaction needs.
+func (op txnOp) args() int {
+	n := len(op.del) + len(op.put)*2
+	// updates need an additional argument for the number of deleted keys
+	if len(op.del) > 0 && len(op.put) > 0 {
+		n++
+	}
+	return n
+}
+
+// appArgs returns the note and the application arguments of the transaction, as
+// sent by the client.
+func (op txnOp) appArgs() (string, [][]byte) {
+	args := make([][]byte, 0, op.args())
+	note := "update"
+	switch {
+	case len(op.del) == 0:
+		note = "put"
+	case len(op.put) == 0:
+		note = "delete"
+	default:
+		args = append(args, itob(uint64(len(op.del))))
+	}
+	for _, k := range op.del {
+		args = append(args, []byte(k))
+	}
+	for k, v := range op.put {
+		args = append(args, []byte(k), v)
+	}
+	return note, args
+}
+
+// commit sends the given transactions in order. If metadata is enabled, the reserved
+// metadata keys are written with the last transaction. If the signer signs only
+// partially, writes of several transactions return an *ErrPartialWrite.
+func (ab *AlgorandBuffer) commit(ctx context.Context, txns []txnOp) error {
+	nonEmpty := nonEmptyTxns(txns)
+	if len(nonEmpty) == 0 {
+		return nil
+	}
+	trailer, err := ab.trailer(ctx, nonEmpty)
+	if err != nil {
+		del := make([]string, 0)
+		put := make(map[string][]byte)
+		for _, op := range nonEmpty {
+			del = append(del, op.del...)
+			for k, v := range op.put {
+				put[k] = v
+			}
+		}
+		return ab.writeFailed(del, put, err)
+	}
+	ops := appendTrailer(nonEmpty, trailer)
+	for _, op := range ops {
+		if err = ab.writeTxn(op.del, op.put); err != nil {
+			var partial *client.ErrPartiallySigned
+			if len(ops) > 1 && errors.As(err, &partial) {
+				err = &ErrPartialWrite{Transactions: len(ops)}
+				// the transaction can't be submitted, so the intent is abandoned
+				_ = ab.journal.finishSigned(partial.Transactions, JournalFailed, err)
+			}
 			return err
 		}
 	}
 	return nil
 }
 
+// nonEmptyTxns returns the transactions that delete or store keys.
+func nonEmptyTxns(txns []txnOp) []txnOp {
+	nonEmpty := make([]txnOp, 0, len(txns))
+	for _, op := range txns {
+		if len(op.del)+len(op.put) > 0 {
+			nonEmpty = append(nonEmpty, op)
+		}
+	}
+	return nonEmpty
+}
+
+// plannedTxns returns the transactions commit sends for the given transactions,
+// including the trailer. As its values aren't known before the write, they are
+// filled with placeholders of the size of the largest reserved value, the checksum.
+func (ab *AlgorandBuffer) plannedTxns(txns []txnOp) []txnOp {
+	nonEmpty := nonEmptyTxns(txns)
+	if len(nonEmpty) == 0 {
+		return nil
+	}
+	trailer := make(map[string][]byte)
+	for _, k := range ab.reservedKeys() {
+		trailer[k] = make([]byte, sha256.Size)
+	}
+	return appendTrailer(nonEmpty, trailer)
+}
+
+// appendTrailer adds the given pairs to the last transaction. If they don't fit, an
+// additional transaction is appended.
+func appendTrailer(txns []txnOp, trailer map[string][]byte) []txnOp {
+	if len(trailer) == 0 {
+		return txns
+	}
+	last := txns[len(txns)-1]
+	merged := txnOp{del: last.del, put: make(map[string][]byte, len(last.put)+len(trailer))}
+	for k, v := range last.put {
+		merged.put[k] = v
+	}
+	for k, v := range trailer {
+		merged.put[k] = v
+	}
+	if merged.args() <= client.MaxArgs {
+		txns[len(txns)-1] = merged
+		return txns
+	}
+	return append(txns, txnOp{put: trailer})
+}
+
+// writeTxn deletes the given keys and stores the given pairs within a single
+// transaction. The operation is recorded in the journal, and the eviction metadata
+// of the written keys is updated. Entries of transactions that are known to not be
+// applied are finished as failed; if the outcome is unknown, they stay pending.
+// Partially signed transactions are recorded as unsigned, see SubmitSigned.
+func (ab *AlgorandBuffer) writeTxn(del []string, put map[string][]byte) error {
+	op := journalUpdate
+	if len(del) == 0 {
+		op = journalPut
+	} else if len(put) == 0 {
+		op = journalDelete
+	}
+	seq, err := ab.journal.begin(op, ab.AppId, put, del)
+	if err != nil {
+		return ab.writeFailed(del, put, err)
+	}
+	err = ab.sendTxn(ab.journal.signer(ab.Signer, seq), del, put)
+	ab.runWriteHooks(del, put, err)
+	var partial *client.ErrPartiallySigned
+	if errors.As(err, &partial) {
+		_ = ab.journal.signed(seq, JournalUnsigned, partial.Transactions)
+	} else if client.IsRejected(err) {
+		// the transaction was not applied, so the entry must not be replayed
+		_ = ab.journal.finish(seq, JournalFailed, err)
+	}
+	if err != nil {
+		return err
+	}
+	ab.touchKeys(del, put)
+	return ab.journal.finish(seq, JournalConfirmed, nil)
+}
+
+// runWriteHooks reports a transaction to the hooks of WithWriteHook.
+func (ab *AlgorandBuffer) runWriteHooks(del []string, put map[string][]byte, err error) {
+	if len(ab.writeHooks) == 0 {
+		return
+	}
+	res := WriteResult{Time: time.Now(), AppId: ab.AppId, Put: getKeysByte(put), Deleted: append([]string{}, del...), Err: err}
+	sort.Strings(res.Put)
+	for _, hook := range ab.writeHooks {
+		hook(res)
+	}
+}
+
+// writeFailed reports a write that failed before its transaction was sent to the
+// hooks of WithWriteHook, and returns err.
+func (ab *AlgorandBuffer) writeFailed(del []string, put map[string][]byte, err error) error {
+	ab.runWriteHooks(del, put, err)
+	return err
+}
+
+// sendTxn deletes the given keys and stores the given pairs within a single
+// transaction signed by s, using the cheapest client call.
+func (ab *AlgorandBuffer) sendTxn(s client.Signer, del []string, put map[string][]byte) error {
+	switch {
+	case len(del) == 0:
+		return ab.Client.StoreGlobals(s, ab.AppId, toTealKeyValues(put))
+	case len(put) == 0:
+		return ab.Client.DeleteGlobals(s, ab.AppId, del...)
+	default:
+		return ab.Client.UpdateGlobals(s, ab.AppId, del, toTealKeyValues(put))
+	}
+}
+
 // ContainsWithin returns true if the AlgorandBuffer contains the given data within time.
 // The polling interval determines how often the endpoint is pinged for new data.
 func (ab *AlgorandBuffer) ContainsWithin(m map[string]string, t time.Duration, pollingInterval time.Duration) bool {
@@ -312,18 +851,31 @@ func (ab *AlgorandBuffer) Contains(ctx context.Context, m map[string]string) (bo
 }
 
 // AchieveDesiredState turns the application state into a given `desired` state with the smallest
-// number of Put/Delete calls.
+// number of Put/Delete calls. If the desired state doesn't fit into the application, an
+// *ErrCapacityExceeded is returned before anything is written. Values that are stored in an
+// outdated form, e.g. encrypted with an old key, are rewritten.
 func (ab *AlgorandBuffer) AchieveDesiredState(ctx context.Context, desired map[string]string) error {
-	data, err := ab.GetBuffer(ctx)
+	state, err := ab.getGlobalState(ctx)
 	if err != nil {
-		return err
+		return ab.writeFailed(nil, toBytes(desired), err)
+	}
+	data := make(map[string]string, len(state))
+	decoded, err := ab.decodeState(state)
+	if err != nil {
+		return ab.writeFailed(nil, toBytes(desired), err)
+	}
+	for k, v := range decoded {
+		data[k] = string(v)
 	}
-	put, del := computeOverlap(desired, data)
+	put, del := ab.computeChanges(desired, data, state)
 
 	// if no changes need to be made, application state is optimal
 	if len(put)+len(del) == 0 {
 		return nil
 	}
+	if err = checkCapacity(ab.withReserved(state), getKeys(put), getKeys(del)); err != nil {
+		return ab.writeFailed(getKeys(del), toBytes(put), err)
+	}
 
 	err = ab.DeleteElements(ctx, getKeys(del)...)
 	if err != nil {
@@ -336,11 +888,24 @@ func (ab *AlgorandBuffer) AchieveDesiredState(ctx context.Context, desired map[s
 	return nil
 }
 
+// computeChanges returns the pairs to put and the keys to delete to turn data into
+// desired, like computeOverlap. Additionally, desired keys whose stored values in
+// state are outdated are put again.
+func (ab *AlgorandBuffer) computeChanges(desired, data map[string]string, state map[string][]byte) (put, del map[string]string) {
+	put, del = computeOverlap(desired, data)
+	for k := range staleKeys(state, ab.codecs, ab.onChainExpiry) {
+		if v, ok := desired[k]; ok {
+			put[k] = v
+		}
+	}
+	return put, del
+}
+
 // manageCreation creates an Algorand application for the target account.
 // For this to work, the account needs to be valid (i.e. have no registered
 // app and enough funding).
 func (ab *AlgorandBuffer) manageCreation() error {
-	info, err := ab.Client.AccountInformation(ab.AccountCrypt.Address.String(), context.Background())
+	info, err := ab.Client.AccountInformation(ab.Signer.Address().String(), context.Background())
 	if err != nil {
 		return err
 	}
@@ -352,7 +917,7 @@ func (ab *AlgorandBuffer) manageCreation() error {
 		return errors.New("must delete invalid applications before creating new one")
 	}
 
-	appId, err := ab.Client.CreateApplication(ab.AccountCrypt, client.ApproveTeal, client.ClearTeal)
+	appId, err := ab.Client.CreateApplication(ab.Signer, client.ApproveTeal, client.ClearTeal)
 	if err != nil {
 		return err
 	}
@@ -366,7 +931,7 @@ func (ab *AlgorandBuffer) manageCreation() error {
 // the account has several valid applications, then the one with the smallest
 // CreatedAtRound-parameter will be kept. All others will be deleted.
 func (ab *AlgorandBuffer) manageDeletion() error {
-	info, err := ab.Client.AccountInformation(ab.AccountCrypt.Address.String(), context.Background())
+	info, err := ab.Client.AccountInformation(ab.Signer.Address().String(), context.Background())
 	if err != nil {
 		return err
 	}
@@ -374,6 +939,12 @@ func (ab *AlgorandBuffer) manageDeletion() error {
 	if len(info.CreatedApps) == 0 {
 		return nil
 	}
+	// Never delete the shards of a ShardedBuffer
+	for _, app := range info.CreatedApps {
+		if client.FulfillsSchema(app) && isDirectory(parseGlobalState(app)) {
+			return &ErrShardedAccount{Address: ab.Signer.Address(), DirectoryId: app.Id}
+		}
+	}
 	// Find out if there exists an app that's already "valid" (i.e. right schema)
 	validApp := -1
 	earliestValidApp := uint64(math.MaxUint64)
@@ -390,7 +961,7 @@ func (ab *AlgorandBuffer) manageDeletion() error {
 			if i == validApp {
 				continue
 			}
-			err := ab.Client.DeleteApplication(ab.AccountCrypt, info.CreatedApps[i].Id)
+			err := ab.Client.DeleteApplication(ab.Signer, info.CreatedApps[i].Id)
 			if err != nil {
 
 				return err
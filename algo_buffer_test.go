@@ -30,7 +30,7 @@ func TestAlgorandBuffer_NoHealth(t *testing.T) {
 		t.Errorf("failing health check doesn't return error %s", err)
 	}
 	// buffer should still have created account
-	assert.NotEqual(t, models.Account{}, buffer.AccountCrypt)
+	assert.NotEqual(t, models.Account{}, buffer.Signer)
 }
 
 // If the Token Verification is not working, return error upon buffer creation
@@ -42,7 +42,7 @@ func TestAlgorandBuffer_IncorrectToken(t *testing.T) {
 		t.Errorf("failing token verification doesn't return error %s", err)
 	}
 	// buffer should still have created account
-	assert.NotEqual(t, models.Account{}, buffer.AccountCrypt)
+	assert.NotEqual(t, models.Account{}, buffer.Signer)
 }
 
 // If the target account is valid, correctly funded and has a valid application,
@@ -55,7 +55,7 @@ func TestAlgorandBuffer_CorrectBufferWhenValid(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	info, err := buffer.Client.AccountInformation(buffer.AccountCrypt.Address.String(), context.Background())
+	info, err := buffer.Client.AccountInformation(buffer.Signer.Address().String(), context.Background())
 	if err != nil {
 		t.Fatalf("eror getting account info %s", err)
 	}
@@ -126,6 +126,25 @@ func TestAlgorandBuffer_Creation(t *testing.T) {
 	assert.True(t, client.ValidAccount(c.Account))
 }
 
+// Applications of an older contract are refused, whichever way they're found
+func TestAlgorandBuffer_ContractUpgrade(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.CompileResponse.Result = "djQ="
+	key := client.GeneratePrivateKey64()
+	buffer, err := NewAlgorandBuffer(c, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v4"), c.App.Params.ApprovalProgram)
+
+	c.CompileResponse.Result = "djU="
+	_, err = NewAlgorandBuffer(c, key)
+	assert.Equal(t, &ErrContractUpgrade{AppId: buffer.AppId}, err)
+	assert.Contains(t, err.Error(), "contract upgrade required")
+	_, err = NewAlgorandBuffer(c, key, WithStartupPolicy(StartupExisting))
+	assert.Equal(t, &ErrContractUpgrade{AppId: buffer.AppId}, err)
+	_, err = NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithApplication(buffer.AppId))
+	assert.Equal(t, &ErrContractUpgrade{AppId: buffer.AppId}, err)
+}
+
 func TestAlgorandBuffer_GetBuffer(t *testing.T) {
 	c := client.CreateAlgorandClientMock("", "")
 	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
@@ -178,7 +197,8 @@ func TestAlgorandBuffer_TooMany(t *testing.T) {
 	assert.Nil(t, err)
 
 	err = buffer.PutElements(context.Background(), map[string]string{"x": "y"})
-	assert.Nil(t, err)
+	assert.IsType(t, &ErrCapacityExceeded{}, err)
+	assert.Equal(t, []string{"x"}, err.(*ErrCapacityExceeded).Keys)
 
 	// confirm buffer size
 	d, err := buffer.GetBuffer(context.Background())
@@ -187,6 +207,36 @@ func TestAlgorandBuffer_TooMany(t *testing.T) {
 	assert.False(t, exists, "buffer should not have 'x' element")
 }
 
+// Writes that fail before a transaction is sent must reach the write hooks too
+func TestAlgorandBuffer_WriteHookReportsFailures(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	results := make([]WriteResult, 0)
+	hook := func(res WriteResult) { results = append(results, res) }
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithWriteHook(hook))
+	data := make(map[string]string, client.GlobalBytes)
+	for i := 0; i < client.GlobalBytes; i++ {
+		data[strconv.Itoa(i)] = ""
+	}
+	assert.Nil(t, buffer.PutElements(context.Background(), data))
+
+	results = results[:0]
+	err := buffer.PutElements(context.Background(), map[string]string{"x": "y"})
+	assert.IsType(t, &ErrCapacityExceeded{}, err)
+	err = buffer.DeleteElements(context.Background(), MetaRoundKey)
+	assert.IsType(t, &ErrReservedKey{}, err)
+	data["x"] = "y"
+	err = buffer.AchieveDesiredState(context.Background(), data)
+	assert.IsType(t, &ErrCapacityExceeded{}, err)
+
+	assert.Len(t, results, 3)
+	assert.Equal(t, []string{"x"}, results[0].Put)
+	assert.IsType(t, &ErrCapacityExceeded{}, results[0].Err)
+	assert.Equal(t, []string{MetaRoundKey}, results[1].Deleted)
+	assert.IsType(t, &ErrReservedKey{}, results[1].Err)
+	assert.Equal(t, []string{"x"}, results[2].Put)
+	assert.IsType(t, &ErrCapacityExceeded{}, results[2].Err)
+}
+
 func TestAlgorandBuffer_Contains(t *testing.T) {
 	c := client.CreateAlgorandClientMock("", "")
 	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
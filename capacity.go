@@ -0,0 +1,76 @@
+package siam
+
+import (
+	"context"
+	"sort"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// MaxPairSize is the maximum number of bytes a key and its value can occupy together.
+const MaxPairSize = 128
+
+// CapacityInfo describes how much of the application's global state is in use.
+// Slots and bytes of reserved keys are not included in the user capacity.
+type CapacityInfo struct {
+	UsedSlots     int
+	FreeSlots     int
+	UsedBytes     int
+	FreeBytes     int
+	ReservedSlots int
+}
+
+// Capacity reports the used and free key slots and bytes of the buffer's application.
+func (ab *AlgorandBuffer) Capacity(ctx context.Context) (CapacityInfo, error) {
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return CapacityInfo{}, err
+	}
+	var info CapacityInfo
+	for k, v := range ab.withReserved(state) {
+		if isReserved(k) {
+			info.ReservedSlots++
+			continue
+		}
+		info.UsedSlots++
+		info.UsedBytes += len(k) + len(v)
+	}
+	info.FreeSlots = client.GlobalBytes - info.ReservedSlots - info.UsedSlots
+	info.FreeBytes = (client.GlobalBytes-info.ReservedSlots)*MaxPairSize - info.UsedBytes
+	return info, nil
+}
+
+// checkCapacity returns an *ErrCapacityExceeded if the given state can't hold the
+// given keys after the keys in del have been removed.
+func checkCapacity(state map[string][]byte, put []string, del []string) error {
+	free := client.GlobalBytes - len(state)
+	for _, k := range del {
+		if _, ok := state[k]; ok {
+			free++
+		}
+	}
+	overflow := overflowingKeys(state, put, free)
+	if len(overflow) > 0 {
+		return &ErrCapacityExceeded{Keys: overflow, Free: free}
+	}
+	return nil
+}
+
+// overflowingKeys returns the keys of put that don't exist in state and don't fit
+// into the given number of free slots. Keys are assigned to slots in sorted order.
+func overflowingKeys(state map[string][]byte, put []string, free int) []string {
+	keys := append([]string(nil), put...)
+	sort.Strings(keys)
+	var overflow []string
+	for _, k := range keys {
+		if _, exists := state[k]; exists {
+			continue
+		}
+		if free > 0 {
+			free--
+			continue
+		}
+		overflow = append(overflow, k)
+	}
+	return overflow
+}
@@ -0,0 +1,43 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlgorandBuffer_Capacity(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"}))
+
+	info, err := buffer.Capacity(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, info.UsedSlots)
+	assert.Equal(t, client.GlobalBytes-1, info.FreeSlots)
+	assert.Equal(t, 12, info.UsedBytes)
+	assert.Equal(t, client.GlobalBytes*MaxPairSize-12, info.FreeBytes)
+}
+
+// A desired state that doesn't fit must be rejected before anything is written
+func TestAlgorandBuffer_AchieveDesiredStateTooMany(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"x": "y"}))
+
+	desired := make(map[string]string, client.GlobalBytes+2)
+	for i := 0; i < client.GlobalBytes+2; i++ {
+		desired["k"+strconv.Itoa(100+i)] = ""
+	}
+	err := buffer.AchieveDesiredState(context.Background(), desired)
+	assert.IsType(t, &ErrCapacityExceeded{}, err)
+	assert.Len(t, err.(*ErrCapacityExceeded).Keys, 2)
+
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Equal(t, map[string]string{"x": "y"}, d)
+}
@@ -0,0 +1,59 @@
+package siam
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// ChecksumKey is the reserved key holding the checksum of the application state. See
+// WithChecksum.
+const ChecksumKey = ReservedPrefix + "sum"
+
+// WithChecksum makes the buffer maintain a checksum of all user keys in the reserved
+// ChecksumKey. The checksum is updated with the last transaction of every write, so
+// readers can detect that they observed a write consisting of several transactions
+// only partially. GetBuffer and the Reader return ErrTornRead in this case. The
+// checksum occupies one slot of the application.
+//
+// All writers of an application must use this option, otherwise readers see a stale
+// checksum and fail. Writers restricted to a prefix can't update the checksum, so
+// writes return ErrWriterChecksum while the application has such writers.
+func WithChecksum() BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		ab.checksum = true
+		return nil
+	}
+}
+
+// StateChecksum computes the checksum of the given global state, with values as they
+// are stored on-chain. It is the XOR of sha256(len(key) || key || value) over all
+// non-reserved pairs, so it doesn't depend on the order of keys.
+func StateChecksum(state map[string][]byte) []byte {
+	sum := make([]byte, sha256.Size)
+	for k, v := range state {
+		if isReserved(k) {
+			continue
+		}
+		h := sha256.New()
+		h.Write([]byte{byte(len(k))})
+		h.Write([]byte(k))
+		h.Write(v)
+		for i, b := range h.Sum(nil) {
+			sum[i] ^= b
+		}
+	}
+	return sum
+}
+
+// verifyChecksum returns ErrTornRead if the state contains a checksum that doesn't
+// match. States without a checksum are not verified.
+func verifyChecksum(state map[string][]byte) error {
+	sum, ok := state[ChecksumKey]
+	if !ok {
+		return nil
+	}
+	if !bytes.Equal(sum, StateChecksum(state)) {
+		return ErrTornRead
+	}
+	return nil
+}
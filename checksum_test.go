@@ -0,0 +1,61 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlgorandBuffer_Checksum(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithChecksum())
+
+	data := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		data["k"+strconv.Itoa(i)] = strconv.Itoa(i)
+	}
+	assert.Nil(t, buffer.PutElements(context.Background(), data))
+	assert.Nil(t, buffer.DeleteElements(context.Background(), "k3"))
+	delete(data, "k3")
+
+	got, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+
+	state, _ := buffer.getGlobalState(context.Background())
+	assert.Equal(t, StateChecksum(state), state[ChecksumKey])
+
+	info, _ := buffer.Capacity(context.Background())
+	assert.Equal(t, 1, info.ReservedSlots)
+}
+
+// A write of several transactions that is only partially visible must be detected
+func TestAlgorandBuffer_TornRead(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithChecksum())
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"}))
+
+	// the first transaction of a new write went through, the last one didn't
+	assert.Nil(t, c.StoreGlobals(buffer.Signer, buffer.AppId, []models.TealKeyValue{
+		{Key: "1001", Value: models.TealValue{Bytes: "OG"}},
+	}))
+	_, err := buffer.GetBuffer(context.Background())
+	assert.Equal(t, ErrTornRead, err)
+	_, err = NewReader(c, buffer.AppId).GetBuffer(context.Background())
+	assert.Equal(t, ErrTornRead, err)
+
+	// the next write repairs the checksum
+	r, _ := NewReconciler(buffer, "")
+	assert.Nil(t, r.SetDesiredState(map[string]string{"1000": "Astralis", "1002": "G2"}))
+	ev, _ := r.Reconcile(context.Background())
+	assert.Nil(t, ev.Err)
+	got, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1000": "Astralis", "1002": "G2"}, got)
+}
@@ -37,23 +37,32 @@ type AlgorandClient interface {
 	// ExecuteTransaction executes a given transaction, waits for the response,
 	// and returns potential errors. Also returns an info response of the successful
 	// or unsuccessful transaction.
-	ExecuteTransaction(crypto.Account, types.Transaction, context.Context) (models.PendingTransactionInfoResponse, error)
+	ExecuteTransaction(Signer, types.Transaction, context.Context) (models.PendingTransactionInfoResponse, error)
 
 	// DeleteApplication deletes an application with given ID from a given account.
 	// If the account has no apps, or none of its apps have the correct ID, then an
 	// error is returned.
-	DeleteApplication(crypto.Account, uint64) error
+	DeleteApplication(Signer, uint64) error
 
 	// CreateApplication creates a new application with given teal code. It will wait
 	// for a confirmation from the node, and is blocking. Returns AppId.
-	CreateApplication(acc crypto.Account, approval string, clear string) (uint64, error)
+	CreateApplication(s Signer, approval string, clear string) (uint64, error)
 
 	// StoreGlobals stores a given array of TEAL key-value pairs
-	StoreGlobals(crypto.Account, uint64, []models.TealKeyValue) error
+	StoreGlobals(Signer, uint64, []models.TealKeyValue) error
 
 	// DeleteGlobals deletes a set of kv pairs from storage. Pass keys as []string
 	// parameter.
-	DeleteGlobals(crypto.Account, uint64, ...string) error
+	DeleteGlobals(Signer, uint64, ...string) error
+
+	// UpdateGlobals deletes a set of keys and stores a given array of TEAL key-value
+	// pairs within a single transaction. Either both or none of the changes are applied.
+	UpdateGlobals(Signer, uint64, []string, []models.TealKeyValue) error
+
+	// SweepGlobals deletes the given expired keys with a "sweep" call, which any sender
+	// may make while the creator of the application enabled sweeping. Missing keys are
+	// skipped; if any other key hasn't expired, none is deleted.
+	SweepGlobals(Signer, uint64, ...string) error
 }
 
 // GeneratePrivateKey64 returns a random, base64-encoded private key.
@@ -102,7 +111,7 @@ func FulfillsSchema(app models.Application) bool {
 
 // GenerateApplicationCallTx generates a mostly empty application call transaction, with the
 // given OC type.
-func GenerateApplicationCallTx(id uint64, a crypto.Account, p types.SuggestedParams, oc types.OnCompletion) (types.Transaction, error) {
+func GenerateApplicationCallTx(id uint64, s Signer, p types.SuggestedParams, oc types.OnCompletion) (types.Transaction, error) {
 	return future.MakeApplicationCallTx(
 		id,
 		nil,
@@ -115,7 +124,7 @@ func GenerateApplicationCallTx(id uint64, a crypto.Account, p types.SuggestedPar
 		types.StateSchema{},
 		types.StateSchema{},
 		p,
-		a.Address,
+		s.Address(),
 		nil,
 		types.Digest{},
 		[32]byte{},
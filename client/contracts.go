@@ -1,6 +1,10 @@
 package client
 
-import _ "embed"
+import (
+	_ "embed"
+
+	"github.com/algorand/go-algorand-sdk/types"
+)
 
 //go:embed approval.teal
 var ApproveTeal string
@@ -8,6 +12,37 @@ var ApproveTeal string
 //go:embed clear.teal
 var ClearTeal string
 
+// VerifySignedTeal is a TEAL subroutine for consumer contracts, which verifies values
+// written by a buffer with signing enabled. It requires TEAL v7.
+//
+//go:embed verify_signed.teal
+var VerifySignedTeal string
+
+// VerifyMerkleTeal is a TEAL subroutine for consumer contracts, which verifies Merkle
+// proofs of datasets committed by a MerkleBuffer. It requires TEAL v5.
+//
+//go:embed verify_merkle.teal
+var VerifyMerkleTeal string
+
+// ContractVersion is the version of the approval.teal contract. It is incremented
+// whenever the contract changes.
+const ContractVersion uint64 = 5
+
+// WriterPrefix is the prefix of the keys of the writer allowlist. The key of a writer
+// is the prefix followed by the 32 bytes of its address. Its value is the prefix that
+// all keys of the writer must start with, or empty. Only the creator of the application
+// can change these keys.
+const WriterPrefix = "_siam_w:"
+
+// SweepKey enables sweeping: while it exists, anyone may delete expired keys with a
+// "sweep" call. Only the creator of the application can change it.
+const SweepKey = "_siam_sweep"
+
+// WriterKey returns the global key that authorizes addr to write to the application.
+func WriterKey(addr types.Address) string {
+	return WriterPrefix + string(addr[:])
+}
+
 // Schema of AlgorandBuffer.
 
 const LocalInts = 0
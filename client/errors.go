@@ -0,0 +1,38 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRejected is returned by the writes of an AlgorandClient if the transaction is
+// known to not be applied, e.g. because it couldn't be signed, or the node or the
+// contract rejected it. Other errors of writes, like timeouts or transport errors,
+// leave the outcome unknown: the transaction may still be confirmed.
+type ErrRejected struct {
+	Err error
+}
+
+func (e *ErrRejected) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ErrRejected) Unwrap() error {
+	return e.Err
+}
+
+// IsRejected returns true if err is, or wraps, an *ErrRejected.
+func IsRejected(err error) bool {
+	var rejected *ErrRejected
+	return errors.As(err, &rejected)
+}
+
+// httpStatus returns the HTTP status code of an error returned by an algod request,
+// or 0 if the request failed without a response.
+func httpStatus(err error) int {
+	var code int
+	if _, e := fmt.Sscanf(err.Error(), "HTTP %d:", &code); e != nil {
+		return 0
+	}
+	return code
+}
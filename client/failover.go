@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/types"
+)
+
+// FailoverClient implements AlgorandClient on top of several nodes. Calls go to the
+// current node. If a read fails because of the node, the other nodes are tried in
+// order, and the first one that succeeds becomes the current node. API errors like a
+// missing application are returned right away.
+//
+// Writes are never repeated on another node, because the failed node may have
+// broadcast the transaction anyway. Instead, a write that fails because of the node,
+// i.e. without a response or with a server error, makes the next node current, so
+// that the caller's retry goes elsewhere. Rejected transactions don't change the node.
+type FailoverClient struct {
+	Nodes []AlgorandClient
+
+	mu      sync.Mutex
+	current int
+}
+
+// NewFailoverClient creates a FailoverClient for the given nodes, in order of
+// preference.
+func NewFailoverClient(nodes ...AlgorandClient) (*FailoverClient, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("failover client needs at least one node")
+	}
+	return &FailoverClient{Nodes: nodes}, nil
+}
+
+// Current returns the index of the node that receives the next call.
+func (f *FailoverClient) Current() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current
+}
+
+// read calls fn with every node, starting at the current one, until a call succeeds
+// or fails with an error that isn't caused by the node, like an API error or the end
+// of ctx. That error, or the error of the last node if all fail, is returned.
+func read[T any](ctx context.Context, f *FailoverClient, fn func(AlgorandClient) (T, error)) (T, error) {
+	start := f.Current()
+	var (
+		res T
+		err error
+	)
+	for i := 0; i < len(f.Nodes); i++ {
+		idx := (start + i) % len(f.Nodes)
+		res, err = fn(f.Nodes[idx])
+		if err == nil || ctx.Err() != nil || !nodeFailed(err) {
+			if err == nil {
+				f.mu.Lock()
+				f.current = idx
+				f.mu.Unlock()
+			}
+			return res, err
+		}
+	}
+	return res, err
+}
+
+// write calls fn with the current node. If the node failed, the next node becomes
+// current.
+func (f *FailoverClient) write(fn func(AlgorandClient) error) error {
+	start := f.Current()
+	err := fn(f.Nodes[start])
+	if nodeFailed(err) {
+		f.mu.Lock()
+		if f.current == start {
+			f.current = (start + 1) % len(f.Nodes)
+		}
+		f.mu.Unlock()
+	}
+	return err
+}
+
+// nodeFailed returns true if err was caused by the node rather than the request: the
+// request failed without a response, the node refused the token, or it answered with
+// a server error. Rejected transactions would fail on every node.
+func nodeFailed(err error) bool {
+	if err == nil || IsRejected(err) {
+		return false
+	}
+	status := httpStatus(err)
+	return status == 0 || status == 401 || status == 403 || status >= 500
+}
+
+func (f *FailoverClient) SuggestedParams(ctx context.Context) (types.SuggestedParams, error) {
+	return read(ctx, f, func(c AlgorandClient) (types.SuggestedParams, error) { return c.SuggestedParams(ctx) })
+}
+
+func (f *FailoverClient) HealthCheck(ctx context.Context) error {
+	_, err := read(ctx, f, func(c AlgorandClient) (struct{}, error) { return struct{}{}, healthy(c.HealthCheck(ctx)) })
+	return err
+}
+
+func (f *FailoverClient) Status(ctx context.Context) (models.NodeStatus, error) {
+	return read(ctx, f, func(c AlgorandClient) (models.NodeStatus, error) { return c.Status(ctx) })
+}
+
+func (f *FailoverClient) StatusAfterBlock(round uint64, ctx context.Context) (models.NodeStatus, error) {
+	return read(ctx, f, func(c AlgorandClient) (models.NodeStatus, error) { return c.StatusAfterBlock(round, ctx) })
+}
+
+func (f *FailoverClient) AccountInformation(address string, ctx context.Context) (models.Account, error) {
+	return read(ctx, f, func(c AlgorandClient) (models.Account, error) { return c.AccountInformation(address, ctx) })
+}
+
+func (f *FailoverClient) GetApplicationByID(id uint64, ctx context.Context) (models.Application, error) {
+	return read(ctx, f, func(c AlgorandClient) (models.Application, error) { return c.GetApplicationByID(id, ctx) })
+}
+
+func (f *FailoverClient) PendingTransactionInformation(txid string, ctx context.Context) (models.PendingTransactionInfoResponse, types.SignedTxn, error) {
+	type result struct {
+		info models.PendingTransactionInfoResponse
+		txn  types.SignedTxn
+	}
+	res, err := read(ctx, f, func(c AlgorandClient) (result, error) {
+		info, txn, err := c.PendingTransactionInformation(txid, ctx)
+		return result{info, txn}, err
+	})
+	return res.info, res.txn, err
+}
+
+func (f *FailoverClient) TealCompile(b []byte, ctx context.Context) (models.CompileResponse, error) {
+	return read(ctx, f, func(c AlgorandClient) (models.CompileResponse, error) { return c.TealCompile(b, ctx) })
+}
+
+func (f *FailoverClient) SendRawTransaction(txn []byte, ctx context.Context) (txid string, err error) {
+	err = f.write(func(c AlgorandClient) error {
+		txid, err = c.SendRawTransaction(txn, ctx)
+		return err
+	})
+	return txid, err
+}
+
+func (f *FailoverClient) ExecuteTransaction(s Signer, txn types.Transaction, ctx context.Context) (info models.PendingTransactionInfoResponse, err error) {
+	err = f.write(func(c AlgorandClient) error {
+		info, err = c.ExecuteTransaction(s, txn, ctx)
+		return err
+	})
+	return info, err
+}
+
+func (f *FailoverClient) DeleteApplication(s Signer, appId uint64) error {
+	return f.write(func(c AlgorandClient) error { return c.DeleteApplication(s, appId) })
+}
+
+func (f *FailoverClient) CreateApplication(s Signer, approval string, clear string) (appId uint64, err error) {
+	err = f.write(func(c AlgorandClient) error {
+		appId, err = c.CreateApplication(s, approval, clear)
+		return err
+	})
+	return appId, err
+}
+
+func (f *FailoverClient) StoreGlobals(s Signer, appId uint64, kv []models.TealKeyValue) error {
+	return f.write(func(c AlgorandClient) error { return c.StoreGlobals(s, appId, kv) })
+}
+
+func (f *FailoverClient) DeleteGlobals(s Signer, appId uint64, keys ...string) error {
+	return f.write(func(c AlgorandClient) error { return c.DeleteGlobals(s, appId, keys...) })
+}
+
+func (f *FailoverClient) SweepGlobals(s Signer, appId uint64, keys ...string) error {
+	return f.write(func(c AlgorandClient) error { return c.SweepGlobals(s, appId, keys...) })
+}
+
+func (f *FailoverClient) UpdateGlobals(s Signer, appId uint64, keys []string, kv []models.TealKeyValue) error {
+	return f.write(func(c AlgorandClient) error { return c.UpdateGlobals(s, appId, keys, kv) })
+}
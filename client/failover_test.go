@@ -0,0 +1,116 @@
+//go:build unit
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailoverClient(t *testing.T) {
+	a := CreateAlgorandClientMock("", "")
+	b := CreateAlgorandClientMock("", "")
+	a.NodeStatus.LastRound = 1
+	b.NodeStatus.LastRound = 2
+	f, err := NewFailoverClient(a, b)
+	assert.Nil(t, err)
+
+	status, err := f.Status(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, status.LastRound)
+
+	// reads fail over to the next node, which stays current
+	a.SetError(true, (*AlgorandMock).Status)
+	status, err = f.Status(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, status.LastRound)
+	assert.Equal(t, 1, f.Current())
+
+	b.SetError(true, (*AlgorandMock).Status)
+	_, err = f.Status(context.Background())
+	assert.NotNil(t, err)
+
+	// a failed write isn't repeated, but the next call goes to the other node
+	acc := NewAccountSigner(crypto.GenerateAccount())
+	assert.NotNil(t, f.DeleteApplication(acc, 1))
+	assert.Equal(t, 0, f.Current())
+
+	// rejected transactions would fail on every node, so the node stays current
+	err = f.StoreGlobals(acc, 1, nil)
+	assert.True(t, IsRejected(err))
+	assert.Equal(t, 0, f.Current())
+	a.SetError(true, (*AlgorandMock).SendRawTransaction)
+	_, err = f.SendRawTransaction(nil, context.Background())
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, f.Current())
+
+	_, err = NewFailoverClient()
+	assert.NotNil(t, err)
+}
+
+// unmarshalHealth returns the error of the SDK's health check of a healthy node
+type unmarshalHealth struct {
+	*AlgorandMock
+}
+
+func (unmarshalHealth) HealthCheck(context.Context) error {
+	return &json.InvalidUnmarshalError{}
+}
+
+// notFound answers reads with an API error
+type notFound struct {
+	*AlgorandMock
+}
+
+func (notFound) GetApplicationByID(uint64, context.Context) (models.Application, error) {
+	return models.Application{}, errors.New("HTTP 404: application does not exist")
+}
+
+func TestFailoverClient_Reads(t *testing.T) {
+	down := CreateAlgorandClientMock("", "")
+	down.SetError(true, (*AlgorandMock).HealthCheck)
+	f, _ := NewFailoverClient(unmarshalHealth{CreateAlgorandClientMock("", "")}, down)
+	assert.Nil(t, f.HealthCheck(context.Background()))
+	assert.Equal(t, 0, f.Current())
+
+	// API errors would be the same on every node
+	f, _ = NewFailoverClient(notFound{CreateAlgorandClientMock("", "")}, CreateAlgorandClientMock("", ""))
+	_, err := f.GetApplicationByID(1, context.Background())
+	assert.EqualError(t, err, "HTTP 404: application does not exist")
+	assert.Equal(t, 0, f.Current())
+
+	// so is the end of the context
+	down.SetError(true, (*AlgorandMock).Status)
+	f, _ = NewFailoverClient(down, CreateAlgorandClientMock("", ""))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = f.Status(ctx)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, f.Current())
+}
+
+func TestNodeFailed(t *testing.T) {
+	assert.False(t, nodeFailed(nil))
+	assert.True(t, nodeFailed(errors.New("connection refused")))
+	assert.True(t, nodeFailed(errors.New("HTTP 503: unavailable")))
+	assert.False(t, nodeFailed(errors.New("HTTP 400: logic eval error")))
+	assert.False(t, nodeFailed(errors.New("HTTP 404: not found")))
+	assert.True(t, nodeFailed(errors.New("HTTP 401: invalid API token")))
+	assert.False(t, nodeFailed(&ErrRejected{Err: errors.New("signing failed")}))
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers := ParseHeaders("X-API-Key:a:b&invalid& X-Other : c")
+	assert.Len(t, headers, 2)
+	assert.Equal(t, "X-API-Key", headers[0].Key)
+	assert.Equal(t, "a:b", headers[0].Value)
+	assert.Equal(t, "X-Other", headers[1].Key)
+	assert.Equal(t, "c", headers[1].Value)
+	assert.Empty(t, ParseHeaders(""))
+}
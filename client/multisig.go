@@ -0,0 +1,193 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/types"
+)
+
+// MultisigSigner signs for a multisig account by collecting the signatures of several
+// Signers, one per key of the account. Signers are asked in order until the threshold
+// is reached; a signer that fails is skipped.
+//
+// If the available signers don't reach the threshold, *ErrPartiallySigned is returned
+// with the partially signed transactions. They can be completed offline with
+// CosignTransaction, and submitted with AlgorandBuffer.SubmitSigned.
+type MultisigSigner struct {
+	Account crypto.MultisigAccount
+	Signers []Signer
+
+	address types.Address
+}
+
+// NewMultisigSigner creates a Signer for the given multisig account. Every signer must
+// sign with one of the keys of the account.
+func NewMultisigSigner(ma crypto.MultisigAccount, signers ...Signer) (*MultisigSigner, error) {
+	addr, err := ma.Address()
+	if err != nil {
+		return nil, err
+	}
+	s := &MultisigSigner{Account: ma, Signers: signers, address: addr}
+	for _, signer := range signers {
+		if s.index(signer.Address()) < 0 {
+			return nil, fmt.Errorf("%s is not a key of the multisig account %s", signer.Address(), addr)
+		}
+	}
+	return s, nil
+}
+
+// index returns the position of the key of the given address in the account, or -1.
+func (s *MultisigSigner) index(addr types.Address) int {
+	for i, pk := range s.Account.Pks {
+		if bytes.Equal(pk, addr[:]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *MultisigSigner) Address() types.Address {
+	return s.address
+}
+
+func (s *MultisigSigner) SignTransactions(ctx context.Context, txns []types.Transaction) ([][]byte, error) {
+	msigs := make([]types.MultisigSig, len(txns))
+	for i := range msigs {
+		msigs[i] = types.MultisigSig{Version: s.Account.Version, Threshold: s.Account.Threshold,
+			Subsigs: make([]types.MultisigSubsig, len(s.Account.Pks))}
+		for j, pk := range s.Account.Pks {
+			msigs[i].Subsigs[j].Key = pk
+		}
+	}
+
+	var failures []string
+	count := 0
+	for _, signer := range s.Signers {
+		if count >= int(s.Account.Threshold) {
+			break
+		}
+		sigs, err := signatures(ctx, signer, txns)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", signer.Address(), err))
+			continue
+		}
+		idx := s.index(signer.Address())
+		for i := range txns {
+			msigs[i].Subsigs[idx].Sig = sigs[i]
+		}
+		count++
+	}
+
+	signed := make([][]byte, len(txns))
+	for i, txn := range txns {
+		stx := types.SignedTxn{Txn: txn, Msig: msigs[i]}
+		if txn.Sender != s.address {
+			stx.AuthAddr = s.address
+		}
+		signed[i] = msgpack.Encode(stx)
+	}
+	if count < int(s.Account.Threshold) {
+		return nil, &ErrPartiallySigned{Transactions: signed, Signatures: count,
+			Threshold: int(s.Account.Threshold), Failures: failures}
+	}
+	return signed, nil
+}
+
+// signatures returns the raw signatures of the signer for the transactions, after
+// checking that they are valid signatures of the signer's key.
+func signatures(ctx context.Context, s Signer, txns []types.Transaction) ([]types.Signature, error) {
+	signed, err := s.SignTransactions(ctx, txns)
+	if err != nil {
+		return nil, err
+	}
+	if len(signed) != len(txns) {
+		return nil, fmt.Errorf("signer returned %d transactions, expected %d", len(signed), len(txns))
+	}
+	sigs := make([]types.Signature, len(txns))
+	for i, txn := range txns {
+		var stx types.SignedTxn
+		if err = msgpack.Decode(signed[i], &stx); err != nil {
+			return nil, fmt.Errorf("invalid signed transaction: %s", err)
+		}
+		if crypto.TransactionIDString(stx.Txn) != crypto.TransactionIDString(txn) {
+			return nil, errors.New("signed transaction differs from the transaction to sign")
+		}
+		if !verifySignature(s.Address(), txn, stx.Sig) {
+			return nil, errors.New("invalid signature on signed transaction")
+		}
+		sigs[i] = stx.Sig
+	}
+	return sigs, nil
+}
+
+// ErrPartiallySigned is returned by a MultisigSigner that couldn't collect enough
+// signatures. Nothing was sent to the network.
+type ErrPartiallySigned struct {
+	// Transactions are the msgpack encoded, partially signed transactions. Write them
+	// to a file for the other key holders, e.g. to complete them with
+	// CosignTransaction or "goal clerk multisig sign".
+	Transactions [][]byte
+	Signatures   int
+	Threshold    int
+	// Failures are the errors of signers that couldn't sign.
+	Failures []string
+}
+
+func (e *ErrPartiallySigned) Error() string {
+	msg := fmt.Sprintf("multisig transaction has %d of %d signatures, it must be signed offline and submitted", e.Signatures, e.Threshold)
+	if len(e.Failures) > 0 {
+		msg += " (" + strings.Join(e.Failures, "; ") + ")"
+	}
+	return msg
+}
+
+// CosignTransaction adds the signature of the signer to a partially signed multisig
+// transaction, and returns the result. The signer must sign with one of the keys of
+// the multisig account.
+func CosignTransaction(ctx context.Context, s Signer, partial []byte) ([]byte, error) {
+	var stx types.SignedTxn
+	if err := msgpack.Decode(partial, &stx); err != nil {
+		return nil, fmt.Errorf("invalid signed transaction: %s", err)
+	}
+	idx := -1
+	addr := s.Address()
+	for i, sub := range stx.Msig.Subsigs {
+		if bytes.Equal(sub.Key, addr[:]) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("%s is not a key of the multisig transaction", addr)
+	}
+	sigs, err := signatures(ctx, s, []types.Transaction{stx.Txn})
+	if err != nil {
+		return nil, err
+	}
+	stx.Msig.Subsigs[idx].Sig = sigs[0]
+	return msgpack.Encode(stx), nil
+}
+
+// MultisigComplete returns true if the signed transaction carries enough valid
+// signatures for its multisig account.
+func MultisigComplete(signed []byte) bool {
+	var stx types.SignedTxn
+	if err := msgpack.Decode(signed, &stx); err != nil || stx.Msig.Blank() {
+		return false
+	}
+	ma, err := crypto.MultisigAccountFromSig(stx.Msig)
+	if err != nil {
+		return false
+	}
+	addr, err := ma.Address()
+	if err != nil {
+		return false
+	}
+	msg := append([]byte("TX"), msgpack.Encode(stx.Txn)...)
+	return crypto.VerifyMultisig(addr, msg, stx.Msig)
+}
@@ -0,0 +1,79 @@
+//go:build unit
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func multisigAccount(t *testing.T, threshold uint8, accs ...crypto.Account) crypto.MultisigAccount {
+	addrs := make([]types.Address, len(accs))
+	for i, acc := range accs {
+		addrs[i] = acc.Address
+	}
+	ma, err := crypto.MultisigAccountWithParams(1, threshold, addrs)
+	assert.Nil(t, err)
+	return ma
+}
+
+func TestMultisigSigner(t *testing.T) {
+	a, b, c := crypto.GenerateAccount(), crypto.GenerateAccount(), crypto.GenerateAccount()
+	ma := multisigAccount(t, 2, a, b, c)
+	addr, _ := ma.Address()
+
+	// c signs through a signing service
+	srv := httptest.NewServer(NewSignerHandler(NewAccountSigner(c), SignerPolicy{AppIds: []uint64{1}, Senders: []types.Address{addr}}, "secret"))
+	defer srv.Close()
+	remote, _ := NewRemoteSigner(srv.URL, "secret", c.Address.String())
+
+	s, err := NewMultisigSigner(ma, NewAccountSigner(a), remote)
+	assert.Nil(t, err)
+	assert.Equal(t, addr, s.Address())
+	txn := testTxn(t, s)
+	signed, err := s.SignTransactions(context.Background(), []types.Transaction{txn})
+	assert.Nil(t, err)
+	assert.True(t, MultisigComplete(signed[0]))
+
+	// unavailable signers are skipped
+	remote.Token = "wrong"
+	s, _ = NewMultisigSigner(ma, remote, NewAccountSigner(a), NewAccountSigner(b))
+	signed, err = s.SignTransactions(context.Background(), []types.Transaction{txn})
+	assert.Nil(t, err)
+	assert.True(t, MultisigComplete(signed[0]))
+
+	_, err = NewMultisigSigner(ma, NewAccountSigner(crypto.GenerateAccount()))
+	assert.NotNil(t, err)
+}
+
+func TestMultisigSigner_Offline(t *testing.T) {
+	a, b := crypto.GenerateAccount(), crypto.GenerateAccount()
+	ma := multisigAccount(t, 2, a, b)
+	s, _ := NewMultisigSigner(ma, NewAccountSigner(a))
+	txn := testTxn(t, s)
+
+	_, err := s.SignTransactions(context.Background(), []types.Transaction{txn})
+	var partial *ErrPartiallySigned
+	assert.True(t, errors.As(err, &partial))
+	assert.Equal(t, 1, partial.Signatures)
+	assert.Equal(t, 2, partial.Threshold)
+	assert.False(t, MultisigComplete(partial.Transactions[0]))
+
+	// the other key holder completes the transaction offline
+	_, err = CosignTransaction(context.Background(), NewAccountSigner(crypto.GenerateAccount()), partial.Transactions[0])
+	assert.NotNil(t, err)
+	signed, err := CosignTransaction(context.Background(), NewAccountSigner(b), partial.Transactions[0])
+	assert.Nil(t, err)
+	assert.True(t, MultisigComplete(signed))
+
+	// the result is compatible with the SDK
+	_, merged, err := crypto.MergeMultisigTransactions(partial.Transactions[0], signed)
+	assert.Nil(t, err)
+	assert.True(t, MultisigComplete(merged))
+}
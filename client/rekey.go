@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/types"
+)
+
+// RekeyedSigner signs for an account that was rekeyed to the key of another signer.
+// The transactions keep the account as sender, and name the other signer as
+// authorizer.
+type RekeyedSigner struct {
+	Account types.Address
+	Auth    Signer
+}
+
+// NewRekeyedSigner creates a Signer for the account, which is rekeyed to the address of
+// auth.
+func NewRekeyedSigner(account types.Address, auth Signer) *RekeyedSigner {
+	return &RekeyedSigner{Account: account, Auth: auth}
+}
+
+func (s *RekeyedSigner) Address() types.Address {
+	return s.Account
+}
+
+// AuthAddress is the address the account is rekeyed to.
+func (s *RekeyedSigner) AuthAddress() types.Address {
+	return s.Auth.Address()
+}
+
+func (s *RekeyedSigner) SignTransactions(ctx context.Context, txns []types.Transaction) ([][]byte, error) {
+	signed, err := s.Auth.SignTransactions(ctx, txns)
+	if err != nil {
+		return nil, err
+	}
+	auth := s.Auth.Address()
+	for i, b := range signed {
+		var stx types.SignedTxn
+		if err = msgpack.Decode(b, &stx); err != nil {
+			return nil, fmt.Errorf("invalid signed transaction: %s", err)
+		}
+		if stx.Txn.Sender == auth || stx.AuthAddr == auth {
+			continue
+		}
+		// some signers, like older kmd versions, don't name the authorizer
+		if !stx.AuthAddr.IsZero() {
+			return nil, fmt.Errorf("transaction was authorized by %s, expected %s", stx.AuthAddr, auth)
+		}
+		stx.AuthAddr = auth
+		signed[i] = msgpack.Encode(stx)
+	}
+	return signed, nil
+}
+
+// AuthAddress returns the address whose key signs for the signer: the address the
+// account is rekeyed to for a RekeyedSigner, and the signer's address otherwise.
+func AuthAddress(s Signer) types.Address {
+	if r, ok := s.(interface{ AuthAddress() types.Address }); ok {
+		return r.AuthAddress()
+	}
+	return s.Address()
+}
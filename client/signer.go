@@ -0,0 +1,417 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/client/kmd"
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/types"
+)
+
+// Signer signs the transactions of an account. Implementations don't need to hold
+// the private key in memory: it can live in a kmd wallet (KmdSigner), or in another
+// process (RemoteSigner).
+type Signer interface {
+	// Address is the account whose transactions are signed.
+	Address() types.Address
+
+	// SignTransactions signs a single transaction or a group, and returns the
+	// msgpack encoded signed transactions in the same order. The group ID of a group
+	// must be assigned before signing.
+	SignTransactions(ctx context.Context, txns []types.Transaction) ([][]byte, error)
+}
+
+// AccountSigner signs with an in-memory private key.
+type AccountSigner struct {
+	Account crypto.Account
+}
+
+// NewAccountSigner creates a Signer for the given account.
+func NewAccountSigner(acc crypto.Account) *AccountSigner {
+	return &AccountSigner{Account: acc}
+}
+
+func (s *AccountSigner) Address() types.Address {
+	return s.Account.Address
+}
+
+func (s *AccountSigner) SignTransactions(_ context.Context, txns []types.Transaction) ([][]byte, error) {
+	signed := make([][]byte, len(txns))
+	for i, txn := range txns {
+		_, stx, err := crypto.SignTransaction(s.Account.PrivateKey, txn)
+		if err != nil {
+			return nil, err
+		}
+		signed[i] = stx
+	}
+	return signed, nil
+}
+
+// KmdSigner signs with a key of a kmd wallet. A wallet handle is acquired for
+// every call, so the signer keeps working after handles expire. The key of the address
+// is used even if the sender differs, so that the signer can co-sign for a
+// MultisigSigner.
+type KmdSigner struct {
+	Client   kmd.Client
+	WalletID string
+	Password string
+
+	address types.Address
+}
+
+// NewKmdSigner creates a Signer for the account with the given address, in the kmd
+// wallet with the given name. An error is returned if the wallet doesn't exist, the
+// password is wrong, or the wallet doesn't hold the key of the address.
+func NewKmdSigner(url, token, wallet, password, address string) (*KmdSigner, error) {
+	addr, err := types.DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	c, err := kmd.MakeClient(strings.TrimSuffix(url, "/"), token)
+	if err != nil {
+		return nil, err
+	}
+	wallets, err := c.ListWallets()
+	if err != nil {
+		return nil, fmt.Errorf("error listing kmd wallets: %s", err)
+	}
+	s := &KmdSigner{Client: c, Password: password, address: addr}
+	for _, w := range wallets.Wallets {
+		if w.Name == wallet {
+			s.WalletID = w.ID
+		}
+	}
+	if s.WalletID == "" {
+		return nil, fmt.Errorf("kmd wallet %q doesn't exist", wallet)
+	}
+
+	var keys kmd.ListKeysResponse
+	err = s.withHandle(func(handle string) (err error) {
+		keys, err = c.ListKeys(handle)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys.Addresses {
+		if k == address {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("kmd wallet %q has no key for %s", wallet, address)
+}
+
+// withHandle calls fn with a new wallet handle, and releases it afterwards.
+func (s *KmdSigner) withHandle(fn func(handle string) error) error {
+	init, err := s.Client.InitWalletHandle(s.WalletID, s.Password)
+	if err != nil {
+		return fmt.Errorf("error unlocking kmd wallet: %s", err)
+	}
+	defer s.Client.ReleaseWalletHandle(init.WalletHandleToken)
+	return fn(init.WalletHandleToken)
+}
+
+func (s *KmdSigner) Address() types.Address {
+	return s.address
+}
+
+func (s *KmdSigner) SignTransactions(_ context.Context, txns []types.Transaction) ([][]byte, error) {
+	signed := make([][]byte, len(txns))
+	err := s.withHandle(func(handle string) error {
+		for i, txn := range txns {
+			resp, err := s.Client.SignTransactionWithSpecificPublicKey(handle, s.Password, txn, s.address[:])
+			if err != nil {
+				return err
+			}
+			if err = checkSigned(txn, resp.SignedTransaction); err != nil {
+				return err
+			}
+			signed[i] = resp.SignedTransaction
+		}
+		return nil
+	})
+	return signed, err
+}
+
+// SignRequest is the body of a request to a remote signing service.
+type SignRequest struct {
+	// Address is the account that must sign.
+	Address string `json:"address"`
+	// Transactions are msgpack encoded, unsigned transactions.
+	Transactions [][]byte `json:"transactions"`
+}
+
+// SignResponse is the body of the response of a remote signing service.
+type SignResponse struct {
+	// SignedTransactions are msgpack encoded, in the order of the request.
+	SignedTransactions [][]byte `json:"signed_transactions"`
+	// Error is set if the service refused to sign.
+	Error string `json:"error,omitempty"`
+}
+
+// RemoteSigner signs by posting a SignRequest to a signing service, e.g. one that
+// runs NewSignerHandler in another process. The signed transactions are checked
+// before they are returned, so the service can't substitute transactions.
+type RemoteSigner struct {
+	URL   string
+	Token string
+
+	// Client is used for requests. Defaults to a client with a timeout of
+	// AlgorandDefaultTimeout.
+	Client *http.Client
+
+	address types.Address
+}
+
+// NewRemoteSigner creates a Signer for the account with the given address. Requests
+// carry the token as bearer token, if it isn't empty.
+func NewRemoteSigner(url, token, address string) (*RemoteSigner, error) {
+	addr, err := types.DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteSigner{URL: url, Token: token, address: addr}, nil
+}
+
+func (s *RemoteSigner) Address() types.Address {
+	return s.address
+}
+
+func (s *RemoteSigner) SignTransactions(ctx context.Context, txns []types.Transaction) ([][]byte, error) {
+	body := SignRequest{Address: s.address.String(), Transactions: make([][]byte, len(txns))}
+	for i, txn := range txns {
+		body.Transactions[i] = msgpack.Encode(txn)
+	}
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	c := s.Client
+	if c == nil {
+		c = &http.Client{Timeout: AlgorandDefaultTimeout}
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res SignResponse
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&res); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid response from signing service: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if res.Error == "" {
+			res.Error = resp.Status
+		}
+		return nil, fmt.Errorf("signing service refused to sign: %s", res.Error)
+	}
+	if len(res.SignedTransactions) != len(txns) {
+		return nil, fmt.Errorf("signing service returned %d transactions, expected %d", len(res.SignedTransactions), len(txns))
+	}
+	for i, txn := range txns {
+		if err = checkSigned(txn, res.SignedTransactions[i]); err != nil {
+			return nil, err
+		}
+	}
+	return res.SignedTransactions, nil
+}
+
+// checkSigned returns an error if the signed transaction isn't the given transaction,
+// or if its single signature is invalid. Signatures of other kinds are left to the
+// node.
+func checkSigned(txn types.Transaction, signed []byte) error {
+	var stx types.SignedTxn
+	if err := msgpack.Decode(signed, &stx); err != nil {
+		return fmt.Errorf("invalid signed transaction: %s", err)
+	}
+	if crypto.TransactionIDString(stx.Txn) != crypto.TransactionIDString(txn) {
+		return errors.New("signed transaction differs from the transaction to sign")
+	}
+	if stx.Sig == (types.Signature{}) {
+		return nil
+	}
+	signer := stx.Txn.Sender
+	if !stx.AuthAddr.IsZero() {
+		signer = stx.AuthAddr
+	}
+	if !verifySignature(signer, stx.Txn, stx.Sig) {
+		return errors.New("invalid signature on signed transaction")
+	}
+	return nil
+}
+
+// VerifySigner has the signer sign the given probe transaction, which isn't sent, and
+// returns an error unless the result is signed by the key of the signer's address,
+// or carries enough multisig signatures for it. It detects signers that can't sign
+// for their address, e.g. a kmd wallet without the key.
+func VerifySigner(ctx context.Context, s Signer, probe types.Transaction) error {
+	signed, err := s.SignTransactions(ctx, []types.Transaction{probe})
+	if err != nil {
+		return err
+	}
+	if len(signed) != 1 {
+		return fmt.Errorf("signer returned %d transactions for 1", len(signed))
+	}
+	var stx types.SignedTxn
+	if err = msgpack.Decode(signed[0], &stx); err != nil {
+		return fmt.Errorf("invalid signed transaction: %s", err)
+	}
+	if crypto.TransactionIDString(stx.Txn) != crypto.TransactionIDString(probe) {
+		return errors.New("signed transaction differs from the transaction to sign")
+	}
+	msg := append([]byte("TX"), msgpack.Encode(stx.Txn)...)
+	if stx.Msig.Blank() && verifySignature(s.Address(), stx.Txn, stx.Sig) ||
+		!stx.Msig.Blank() && crypto.VerifyMultisig(s.Address(), msg, stx.Msig) {
+		return nil
+	}
+	return fmt.Errorf("signer doesn't sign with the key of %s", s.Address())
+}
+
+// verifySignature returns true if sig is a signature of the transaction by the key of
+// the given address.
+func verifySignature(signer types.Address, txn types.Transaction, sig types.Signature) bool {
+	msg := append([]byte("TX"), msgpack.Encode(txn)...)
+	return ed25519.Verify(signer[:], msg, sig[:])
+}
+
+// DefaultMaxSignerFee is the highest fee in microAlgos a handler of NewSignerHandler
+// signs by default. It covers the flat DefaultFee with room for congestion.
+const DefaultMaxSignerFee = DefaultFee * MaxKVArgs
+
+// SignerPolicy restricts the transactions a handler of NewSignerHandler signs. Only
+// application calls of allowed senders to allowed applications are signed, and never
+// transactions that rekey or close an account.
+type SignerPolicy struct {
+	// AppIds are the applications that may be called. Include 0 to allow creating
+	// applications, e.g. for siam.StartupManage.
+	AppIds []uint64
+
+	// Senders are accounts whose transactions are signed besides the signer's own,
+	// e.g. a multisig account the key belongs to.
+	Senders []types.Address
+
+	// MaxFee is the highest fee in microAlgos that is signed. If zero,
+	// DefaultMaxSignerFee is used.
+	MaxFee uint64
+
+	// OnCompletions are the OnCompletion types that are signed besides NoOp, e.g.
+	// types.DeleteApplicationOC for siam.StartupManage, which deletes applications.
+	OnCompletions []types.OnCompletion
+}
+
+// check returns an error if the policy doesn't allow the given signer to sign the
+// transaction.
+func (p SignerPolicy) check(signer types.Address, txn types.Transaction) error {
+	if txn.Type != types.ApplicationCallTx {
+		return fmt.Errorf("only application calls are signed, got %q", txn.Type)
+	}
+	if !txn.RekeyTo.IsZero() || !txn.CloseRemainderTo.IsZero() {
+		return errors.New("transactions that rekey or close an account are not signed")
+	}
+	maxFee := p.MaxFee
+	if maxFee == 0 {
+		maxFee = DefaultMaxSignerFee
+	}
+	if uint64(txn.Fee) > maxFee {
+		return fmt.Errorf("fee %d exceeds the maximum of %d", txn.Fee, maxFee)
+	}
+	oc := txn.OnCompletion == types.NoOpOC
+	for _, allowed := range p.OnCompletions {
+		oc = oc || txn.OnCompletion == allowed
+	}
+	if !oc {
+		return fmt.Errorf("application calls with OnCompletion %d are not signed", txn.OnCompletion)
+	}
+	sender := txn.Sender == signer
+	for _, a := range p.Senders {
+		sender = sender || txn.Sender == a
+	}
+	if !sender {
+		return fmt.Errorf("transactions of %s are not signed", txn.Sender)
+	}
+	for _, id := range p.AppIds {
+		if uint64(txn.ApplicationID) == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("calls to application %d are not signed", txn.ApplicationID)
+}
+
+// NewSignerHandler serves SignRequests with the given Signer, for use with
+// RemoteSigner. Only requests for the signer's address are served, and only
+// transactions that the policy allows are signed. Requests must carry one of the
+// given tokens as bearer token; without tokens, every request is rejected.
+func NewSignerHandler(s Signer, policy SignerPolicy, tokens ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := func(status int, res SignResponse) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(res)
+		}
+		if r.Method != http.MethodPost {
+			reply(http.StatusMethodNotAllowed, SignResponse{Error: "use POST"})
+			return
+		}
+		if !bearerAuthorized(r, tokens) {
+			reply(http.StatusUnauthorized, SignResponse{Error: "missing or invalid token"})
+			return
+		}
+		var req SignRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			reply(http.StatusBadRequest, SignResponse{Error: "invalid request: " + err.Error()})
+			return
+		}
+		if req.Address != s.Address().String() {
+			reply(http.StatusForbidden, SignResponse{Error: "no key for address " + req.Address})
+			return
+		}
+		txns := make([]types.Transaction, len(req.Transactions))
+		for i, b := range req.Transactions {
+			if err := msgpack.Decode(b, &txns[i]); err != nil {
+				reply(http.StatusBadRequest, SignResponse{Error: fmt.Sprintf("invalid transaction %d: %s", i, err)})
+				return
+			}
+			if err := policy.check(s.Address(), txns[i]); err != nil {
+				reply(http.StatusForbidden, SignResponse{Error: fmt.Sprintf("transaction %d: %s", i, err)})
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		signed, err := s.SignTransactions(ctx, txns)
+		if err != nil {
+			reply(http.StatusInternalServerError, SignResponse{Error: err.Error()})
+			return
+		}
+		reply(http.StatusOK, SignResponse{SignedTransactions: signed})
+	})
+}
+
+// bearerAuthorized returns true if the request carries one of the given non-empty
+// tokens as bearer token.
+func bearerAuthorized(r *http.Request, tokens []string) bool {
+	got := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	for _, t := range tokens {
+		if t != "" && subtle.ConstantTimeCompare(got, []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
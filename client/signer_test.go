@@ -0,0 +1,225 @@
+//go:build unit
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/client/kmd"
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/encoding/json"
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func testTxn(t *testing.T, s Signer) types.Transaction {
+	params := types.SuggestedParams{Fee: 1000, FlatFee: true, FirstRoundValid: 1, LastRoundValid: 1000,
+		GenesisID: "testnet-v1.0", GenesisHash: make([]byte, 32)}
+	txn, err := GenerateApplicationCallTx(1, s, params, types.NoOpOC)
+	assert.Nil(t, err)
+	return txn
+}
+
+func TestAccountSigner(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	s := NewAccountSigner(acc)
+	assert.Equal(t, acc.Address, s.Address())
+
+	txns := []types.Transaction{testTxn(t, s), testTxn(t, s)}
+	txns[1].Note = []byte("second")
+	signed, err := s.SignTransactions(context.Background(), txns)
+	assert.Nil(t, err)
+	assert.Len(t, signed, 2)
+	for i := range txns {
+		assert.Nil(t, checkSigned(txns[i], signed[i]))
+	}
+	assert.EqualError(t, checkSigned(txns[0], signed[1]), "signed transaction differs from the transaction to sign")
+
+	// a corrupted signature is rejected
+	var stx types.SignedTxn
+	assert.Nil(t, msgpack.Decode(signed[0], &stx))
+	stx.Sig[0] ^= 1
+	assert.EqualError(t, checkSigned(txns[0], msgpack.Encode(stx)), "invalid signature on signed transaction")
+}
+
+// kmdStandIn serves the kmd endpoints used by KmdSigner, for a single wallet
+// "oracle" with password "pw" holding the key of acc.
+func kmdStandIn(t *testing.T, acc crypto.Account) *httptest.Server {
+	reply := func(w http.ResponseWriter, v interface{}) {
+		w.Write(json.Encode(v))
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "kmd-token", r.Header.Get("X-KMD-API-Token"))
+		switch r.URL.Path {
+		case "/v1/wallets":
+			reply(w, kmd.ListWalletsResponse{Wallets: []kmd.APIV1Wallet{{ID: "w1", Name: "oracle"}}})
+		case "/v1/wallet/init":
+			var req kmd.InitWalletHandleRequest
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+			if req.WalletID != "w1" || req.WalletPassword != "pw" {
+				reply(w, kmd.InitWalletHandleResponse{APIV1ResponseEnvelope: kmd.APIV1ResponseEnvelope{Error: true, Message: "wrong password"}})
+				return
+			}
+			reply(w, kmd.InitWalletHandleResponse{WalletHandleToken: "handle"})
+		case "/v1/wallet/release":
+			reply(w, kmd.ReleaseWalletHandleResponse{})
+		case "/v1/key/list":
+			reply(w, kmd.ListKeysResponse{Addresses: []string{acc.Address.String()}})
+		case "/v1/transaction/sign":
+			var req kmd.SignTransactionRequest
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+			var txn types.Transaction
+			assert.Nil(t, msgpack.Decode(req.Transaction, &txn))
+			_, stx, err := crypto.SignTransaction(acc.PrivateKey, txn)
+			assert.Nil(t, err)
+			reply(w, kmd.SignTransactionResponse{SignedTransaction: stx})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestKmdSigner(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	srv := kmdStandIn(t, acc)
+	defer srv.Close()
+
+	s, err := NewKmdSigner(srv.URL, "kmd-token", "oracle", "pw", acc.Address.String())
+	assert.Nil(t, err)
+	assert.Equal(t, acc.Address, s.Address())
+	txn := testTxn(t, s)
+	signed, err := s.SignTransactions(context.Background(), []types.Transaction{txn})
+	assert.Nil(t, err)
+	assert.Nil(t, checkSigned(txn, signed[0]))
+
+	_, err = NewKmdSigner(srv.URL, "kmd-token", "other", "pw", acc.Address.String())
+	assert.EqualError(t, err, `kmd wallet "other" doesn't exist`)
+	_, err = NewKmdSigner(srv.URL, "kmd-token", "oracle", "wrong", acc.Address.String())
+	assert.EqualError(t, err, "error unlocking kmd wallet: wrong password")
+	other := crypto.GenerateAccount().Address.String()
+	_, err = NewKmdSigner(srv.URL, "kmd-token", "oracle", "pw", other)
+	assert.EqualError(t, err, `kmd wallet "oracle" has no key for `+other)
+}
+
+func TestRemoteSigner(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	srv := httptest.NewServer(NewSignerHandler(NewAccountSigner(acc), SignerPolicy{AppIds: []uint64{1}}, "secret"))
+	defer srv.Close()
+
+	s, err := NewRemoteSigner(srv.URL, "secret", acc.Address.String())
+	assert.Nil(t, err)
+	txn := testTxn(t, s)
+	signed, err := s.SignTransactions(context.Background(), []types.Transaction{txn})
+	assert.Nil(t, err)
+	assert.Nil(t, checkSigned(txn, signed[0]))
+
+	s.Token = "wrong"
+	_, err = s.SignTransactions(context.Background(), []types.Transaction{txn})
+	assert.EqualError(t, err, "signing service refused to sign: missing or invalid token")
+
+	// the service only signs for its own address
+	other, _ := NewRemoteSigner(srv.URL, "secret", crypto.GenerateAccount().Address.String())
+	_, err = other.SignTransactions(context.Background(), []types.Transaction{testTxn(t, other)})
+	assert.Contains(t, err.Error(), "no key for address")
+}
+
+func TestSignerHandler_Policy(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	srv := httptest.NewServer(NewSignerHandler(NewAccountSigner(acc), SignerPolicy{AppIds: []uint64{1}}, "secret"))
+	defer srv.Close()
+	s, _ := NewRemoteSigner(srv.URL, "secret", acc.Address.String())
+
+	for name, modify := range map[string]func(*types.Transaction){
+		"payment": func(txn *types.Transaction) {
+			*txn = types.Transaction{Type: types.PaymentTx, Header: txn.Header}
+		},
+		"rekey":  func(txn *types.Transaction) { txn.RekeyTo = crypto.GenerateAccount().Address },
+		"sender": func(txn *types.Transaction) { txn.Sender = crypto.GenerateAccount().Address },
+		"app":    func(txn *types.Transaction) { txn.ApplicationID = 2 },
+		"fee":    func(txn *types.Transaction) { txn.Fee = DefaultMaxSignerFee + 1 },
+		"delete": func(txn *types.Transaction) { txn.OnCompletion = types.DeleteApplicationOC },
+		"update": func(txn *types.Transaction) { txn.OnCompletion = types.UpdateApplicationOC },
+		"create": func(txn *types.Transaction) { txn.ApplicationID = 0 },
+	} {
+		txn := testTxn(t, s)
+		modify(&txn)
+		_, err := s.SignTransactions(context.Background(), []types.Transaction{testTxn(t, s), txn})
+		if assert.NotNil(t, err, name) {
+			assert.Contains(t, err.Error(), "transaction 1: ", name)
+		}
+	}
+
+	// deletes and higher fees must be allowed explicitly
+	lenient := httptest.NewServer(NewSignerHandler(NewAccountSigner(acc), SignerPolicy{AppIds: []uint64{1},
+		MaxFee: 2 * DefaultMaxSignerFee, OnCompletions: []types.OnCompletion{types.DeleteApplicationOC}}, "secret"))
+	defer lenient.Close()
+	s, _ = NewRemoteSigner(lenient.URL, "secret", acc.Address.String())
+	txn := testTxn(t, s)
+	txn.Fee = DefaultMaxSignerFee + 1
+	txn.OnCompletion = types.DeleteApplicationOC
+	_, err := s.SignTransactions(context.Background(), []types.Transaction{txn})
+	assert.Nil(t, err)
+
+	// without tokens, every request is rejected
+	open := httptest.NewServer(NewSignerHandler(NewAccountSigner(acc), SignerPolicy{AppIds: []uint64{1}}))
+	defer open.Close()
+	s, _ = NewRemoteSigner(open.URL, "", acc.Address.String())
+	_, err = s.SignTransactions(context.Background(), []types.Transaction{testTxn(t, s)})
+	assert.EqualError(t, err, "signing service refused to sign: missing or invalid token")
+}
+
+func TestRemoteSigner_Substitution(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	signer := NewAccountSigner(acc)
+	// a compromised service signs another transaction than requested
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		txn := testTxn(t, signer)
+		txn.Note = []byte("substituted")
+		signed, _ := signer.SignTransactions(r.Context(), []types.Transaction{txn})
+		w.Write(json.Encode(SignResponse{SignedTransactions: signed}))
+	}))
+	defer srv.Close()
+
+	s, _ := NewRemoteSigner(srv.URL, "", acc.Address.String())
+	_, err := s.SignTransactions(context.Background(), []types.Transaction{testTxn(t, s)})
+	assert.EqualError(t, err, "signed transaction differs from the transaction to sign")
+}
+
+func TestRekeyedSigner(t *testing.T) {
+	acc, auth := crypto.GenerateAccount(), crypto.GenerateAccount()
+	s := NewRekeyedSigner(acc.Address, NewAccountSigner(auth))
+	assert.Equal(t, acc.Address, s.Address())
+	assert.Equal(t, auth.Address, AuthAddress(s))
+	assert.Equal(t, acc.Address, AuthAddress(NewAccountSigner(acc)))
+
+	txn := testTxn(t, s)
+	assert.Equal(t, acc.Address, txn.Sender)
+	signed, err := s.SignTransactions(context.Background(), []types.Transaction{txn})
+	assert.Nil(t, err)
+	assert.Nil(t, checkSigned(txn, signed[0]))
+	var stx types.SignedTxn
+	assert.Nil(t, msgpack.Decode(signed[0], &stx))
+	assert.Equal(t, auth.Address, stx.AuthAddr)
+}
+
+func TestVerifySigner(t *testing.T) {
+	a, b := crypto.GenerateAccount(), crypto.GenerateAccount()
+	s := NewAccountSigner(a)
+	assert.Nil(t, VerifySigner(context.Background(), s, testTxn(t, s)))
+
+	wrong := NewRekeyedSigner(b.Address, NewAccountSigner(a))
+	err := VerifySigner(context.Background(), wrong, testTxn(t, wrong))
+	assert.EqualError(t, err, "signer doesn't sign with the key of "+b.Address.String())
+
+	ma := multisigAccount(t, 2, a, b)
+	ms, _ := NewMultisigSigner(ma, NewAccountSigner(a), NewAccountSigner(b))
+	assert.Nil(t, VerifySigner(context.Background(), ms, testTxn(t, ms)))
+	ms, _ = NewMultisigSigner(ma, NewAccountSigner(a))
+	var partial *ErrPartiallySigned
+	assert.True(t, errors.As(VerifySigner(context.Background(), ms, testTxn(t, ms)), &partial))
+}
@@ -3,18 +3,24 @@ package client
 import (
 	"context"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
-	"github.com/algorand/go-algorand-sdk/crypto"
 	"reflect"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
 	"github.com/algorand/go-algorand-sdk/types"
 
 	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
 )
 
 // AlgorandMock implements the AlgorandClient interface. All functions are simply
-// returning the corresponding public field.
+// returning the corresponding public field. Reads and writes of the account and its
+// applications are synchronized, so a mock can be shared by a writing buffer and
+// watching readers. Changing the public fields directly is not.
 type AlgorandMock struct {
 	AlwaysReturnError bool // When true, returns errors for every request
 	Account           models.Account
@@ -26,6 +32,13 @@ type AlgorandMock struct {
 	SignedTXN         types.SignedTxn
 	CompileResponse   models.CompileResponse
 	ErrorFunctions    map[string]bool
+
+	// MultipleApps allows the account to own several applications. CreateApplication
+	// then adds an application with a new ID instead of replacing all applications,
+	// and applications are addressed by their ID.
+	MultipleApps bool
+
+	mu sync.Mutex
 }
 
 // wrapExecutionCondition wraps the execution of an AlgorandMock function and
@@ -102,15 +115,38 @@ func (a *AlgorandMock) ClearFunctionErrors() {
 }
 
 func (a *AlgorandMock) AccountInformation(string, context.Context) (models.Account, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	ret, err := a.wrapExecutionCondition(a.Account, models.Account{}, (*AlgorandMock).AccountInformation)
 	return ret.(models.Account), err
 }
 
-func (a *AlgorandMock) GetApplicationByID(uint64, context.Context) (models.Application, error) {
-	ret, err := a.wrapExecutionCondition(a.App, models.Application{}, (*AlgorandMock).GetApplicationByID)
+func (a *AlgorandMock) GetApplicationByID(id uint64, _ context.Context) (models.Application, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	app := a.App
+	if a.MultipleApps {
+		i := a.appIndex(id)
+		if i < 0 {
+			return models.Application{}, errors.New("application does not exist")
+		}
+		app = a.Account.CreatedApps[i]
+	}
+	ret, err := a.wrapExecutionCondition(app, models.Application{}, (*AlgorandMock).GetApplicationByID)
 	return ret.(models.Application), err
 }
 
+// appIndex returns the index of the application with given ID in the created apps of
+// the account, or -1.
+func (a *AlgorandMock) appIndex(id uint64) int {
+	for i, app := range a.Account.CreatedApps {
+		if app.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
 func (a *AlgorandMock) SuggestedParams(context.Context) (types.SuggestedParams, error) {
 	ret, err := a.wrapExecutionCondition(a.Params, types.SuggestedParams{}, (*AlgorandMock).SuggestedParams)
 	return ret.(types.SuggestedParams), err
@@ -152,12 +188,45 @@ func (a *AlgorandMock) TealCompile([]byte, context.Context) (models.CompileRespo
 	return ret.(models.CompileResponse), err
 }
 
-func (a *AlgorandMock) ExecuteTransaction(crypto.Account, types.Transaction, context.Context) (models.PendingTransactionInfoResponse, error) {
-	panic("AlgorandStub doesn't stub this method")
-	return models.PendingTransactionInfoResponse{}, nil
+// ExecuteTransaction only simulates rekeying transactions: the transaction is signed,
+// the authorizer is checked against the auth address of the account, and the auth
+// address is updated. Other transactions panic.
+func (a *AlgorandMock) ExecuteTransaction(s Signer, txn types.Transaction, ctx context.Context) (models.PendingTransactionInfoResponse, error) {
+	if txn.RekeyTo.IsZero() {
+		panic("AlgorandStub only stubs rekeying transactions")
+	}
+	_, err := a.wrapExecutionCondition(nil, nil, (*AlgorandMock).ExecuteTransaction)
+	if err != nil {
+		return models.PendingTransactionInfoResponse{}, err
+	}
+	signed, err := s.SignTransactions(ctx, []types.Transaction{txn})
+	if err != nil {
+		return models.PendingTransactionInfoResponse{}, err
+	}
+	var stx types.SignedTxn
+	if err = msgpack.Decode(signed[0], &stx); err != nil {
+		return models.PendingTransactionInfoResponse{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	auth := stx.Txn.Sender
+	if !stx.AuthAddr.IsZero() {
+		auth = stx.AuthAddr
+	}
+	if a.Account.AuthAddr != "" && auth.String() != a.Account.AuthAddr ||
+		a.Account.AuthAddr == "" && auth != stx.Txn.Sender {
+		return models.PendingTransactionInfoResponse{}, errors.New("transaction signed by the wrong key")
+	}
+	a.Account.AuthAddr = ""
+	if txn.RekeyTo != txn.Sender {
+		a.Account.AuthAddr = txn.RekeyTo.String()
+	}
+	return models.PendingTransactionInfoResponse{ConfirmedRound: a.NodeStatus.LastRound}, nil
 }
 
-func (a *AlgorandMock) DeleteApplication(acc crypto.Account, appId uint64) error {
+func (a *AlgorandMock) DeleteApplication(s Signer, appId uint64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	_, err := a.wrapExecutionCondition(nil, nil, (*AlgorandMock).DeleteApplication)
 	if err != nil {
 		return err
@@ -177,30 +246,69 @@ func (a *AlgorandMock) DeleteApplication(acc crypto.Account, appId uint64) error
 	return errors.New("no app with given id found")
 }
 
-func (a *AlgorandMock) CreateApplication(account crypto.Account, approve string, clear string) (uint64, error) {
+func (a *AlgorandMock) CreateApplication(s Signer, approve string, clear string) (uint64, error) {
 	l, g := GenerateSchemasModel()
 	params := models.ApplicationParams{GlobalStateSchema: g, LocalStateSchema: l}
+	params.Creator = s.Address().String()
+	params.ApprovalProgram = CompileProgram(a, []byte(approve))
 	app := models.Application{Id: 4512, Params: params}
 	ret, err := a.wrapExecutionCondition(app, models.Application{}, (*AlgorandMock).CreateApplication)
 	if err != nil {
 		return 0, err
 	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.App = ret.(models.Application)
+	if a.MultipleApps {
+		for _, existing := range a.Account.CreatedApps {
+			if existing.Id >= a.App.Id {
+				a.App.Id = existing.Id + 1
+			}
+		}
+		a.Account.CreatedApps = append(a.Account.CreatedApps, a.App)
+		return a.App.Id, nil
+	}
 	a.Account.CreatedApps = []models.Application{a.App}
 	return a.App.Id, nil
 }
 
-func (a *AlgorandMock) DeleteGlobals(acc crypto.Account, appId uint64, keys ...string) error {
-	if a.App.Id != appId {
-		return errors.New("incorrect appId provided")
+func (a *AlgorandMock) DeleteGlobals(s Signer, appId uint64, keys ...string) error {
+	return a.UpdateGlobals(s, appId, keys, nil)
+}
+
+func (a *AlgorandMock) StoreGlobals(s Signer, appId uint64, kv []models.TealKeyValue) error {
+	return a.UpdateGlobals(s, appId, nil, kv)
+}
+
+// UpdateGlobals deletes and stores the given keys like the contract. Errors set for
+// UpdateGlobals with SetError simulate writes with unknown outcome, and apply to
+// StoreGlobals and DeleteGlobals as well. Writes the contract would reject return an
+// *ErrRejected.
+func (a *AlgorandMock) UpdateGlobals(s Signer, appId uint64, keys []string, kv []models.TealKeyValue) error {
+	if _, err := a.wrapExecutionCondition(nil, nil, (*AlgorandMock).UpdateGlobals); err != nil {
+		return err
 	}
-	state := a.App.Params.GlobalState
-	for i, _ := range keys {
-		keys[i] = base64.StdEncoding.EncodeToString([]byte(keys[i]))
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	idx := 0
+	if a.MultipleApps {
+		if idx = a.appIndex(appId); idx < 0 {
+			return &ErrRejected{Err: errors.New("incorrect appId provided")}
+		}
+		a.App = a.Account.CreatedApps[idx]
+	} else if a.App.Id != appId {
+		return &ErrRejected{Err: errors.New("incorrect appId provided")}
+	}
+	state := append([]models.TealKeyValue(nil), a.App.Params.GlobalState...)
+	if err := checkWriter(a.App, s.Address(), keys, kv); err != nil {
+		return &ErrRejected{Err: err}
 	}
-	for _, k := range keys {
-		for j, kv := range state {
-			if k == kv.Key {
+
+	// Encode with base64 like reference implementation of Algorand sdk
+	for _, key := range keys {
+		k := base64.StdEncoding.EncodeToString([]byte(key))
+		for j, elem := range state {
+			if k == elem.Key {
 				result := make([]models.TealKeyValue, 0)
 				result = append(result, state[:j]...)
 				state = append(result, state[j+1:]...)
@@ -208,37 +316,111 @@ func (a *AlgorandMock) DeleteGlobals(acc crypto.Account, appId uint64, keys ...s
 			}
 		}
 	}
-	a.App.Params.GlobalState = state
-	a.Account.CreatedApps[0] = a.App
-	return nil
-}
 
-func (a *AlgorandMock) StoreGlobals(acc crypto.Account, appId uint64, kv []models.TealKeyValue) error {
-	if a.App.Id != appId {
-		return errors.New("incorrect appId provided")
-	}
-	// Encode with base64 like reference implementation of Algorand sdk
-	for i, _ := range kv {
-		kv[i].Key = base64.StdEncoding.EncodeToString([]byte(kv[i].Key))
-		kv[i].Value.Bytes = base64.StdEncoding.EncodeToString([]byte(kv[i].Value.Bytes))
-	}
-
-	// Attempt update
-	state := a.App.Params.GlobalState
-	for j, arg := range kv {
+	// Attempt update. Like app_global_put in the contract, the whole call fails
+	// if new keys exceed the schema, and no key is written.
+	for _, arg := range kv {
+		arg.Key = base64.StdEncoding.EncodeToString([]byte(arg.Key))
+		arg.Value.Bytes = base64.StdEncoding.EncodeToString([]byte(arg.Value.Bytes))
 		noneFound := true
 		for i, elem := range state {
 			if elem.Key == arg.Key {
-				state[i].Value.Bytes = kv[j].Value.Bytes
+				state[i].Value.Bytes = arg.Value.Bytes
 				noneFound = false
 			}
 		}
-		// if no key exists, create new (as long as space is there)
-		if noneFound && len(state) < GlobalBytes {
+		if noneFound {
+			if len(state) >= GlobalBytes {
+				return &ErrRejected{Err: errors.New("global state schema exceeded")}
+			}
 			state = append(state, arg)
 		}
 	}
 	a.App.Params.GlobalState = state
-	a.Account.CreatedApps[0] = a.App
+	a.Account.CreatedApps[idx] = a.App
+	return nil
+}
+
+// SweepGlobals deletes the given keys like a "sweep" call of the contract: sweeping must
+// be enabled, the state must not have a checksum, and every existing key must carry an
+// expiry in the past. Rejected sweeps return an *ErrRejected.
+func (a *AlgorandMock) SweepGlobals(s Signer, appId uint64, keys ...string) error {
+	if _, err := a.wrapExecutionCondition(nil, nil, (*AlgorandMock).SweepGlobals); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	idx := 0
+	if a.MultipleApps {
+		if idx = a.appIndex(appId); idx < 0 {
+			return &ErrRejected{Err: errors.New("incorrect appId provided")}
+		}
+		a.App = a.Account.CreatedApps[idx]
+	} else if a.App.Id != appId {
+		return &ErrRejected{Err: errors.New("incorrect appId provided")}
+	}
+
+	values := make(map[string][]byte, len(a.App.Params.GlobalState))
+	for _, elem := range a.App.Params.GlobalState {
+		k, _ := base64.StdEncoding.DecodeString(elem.Key)
+		v, _ := base64.StdEncoding.DecodeString(elem.Value.Bytes)
+		values[string(k)] = v
+	}
+	if _, ok := values[SweepKey]; !ok {
+		return &ErrRejected{Err: errors.New("sweeping is disabled")}
+	}
+	if _, ok := values["_siam_sum"]; ok {
+		return &ErrRejected{Err: errors.New("sweeping is refused while the state has a checksum")}
+	}
+	del := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		v, ok := values[key]
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(key, WriterPrefix) || len(v) < 12 || string(v[:4]) != "\xfettl" ||
+			int64(binary.BigEndian.Uint64(v[4:12])) > time.Now().Unix() {
+			return &ErrRejected{Err: errors.New("key " + key + " can't be swept")}
+		}
+		del[base64.StdEncoding.EncodeToString([]byte(key))] = true
+	}
+
+	state := make([]models.TealKeyValue, 0, len(a.App.Params.GlobalState))
+	for _, elem := range a.App.Params.GlobalState {
+		if !del[elem.Key] {
+			state = append(state, elem)
+		}
+	}
+	a.App.Params.GlobalState = state
+	a.Account.CreatedApps[idx] = a.App
+	return nil
+}
+
+// checkWriter rejects writes of senders that aren't allowed to write the given keys,
+// like the approval.teal contract. Applications without creator accept all writes.
+func checkWriter(app models.Application, sender types.Address, keys []string, kv []models.TealKeyValue) error {
+	if app.Params.Creator == "" || app.Params.Creator == sender.String() {
+		return nil
+	}
+	k := base64.StdEncoding.EncodeToString([]byte(WriterKey(sender)))
+	prefix, found := "", false
+	for _, elem := range app.Params.GlobalState {
+		if elem.Key == k {
+			b, _ := base64.StdEncoding.DecodeString(elem.Value.Bytes)
+			prefix, found = string(b), true
+		}
+	}
+	if !found {
+		return errors.New("sender is not a writer of the application")
+	}
+	all := append([]string{}, keys...)
+	for _, arg := range kv {
+		all = append(all, arg.Key)
+	}
+	for _, key := range all {
+		if strings.HasPrefix(key, WriterPrefix) || key == SweepKey || !strings.HasPrefix(key, prefix) {
+			return errors.New("writer may not change key " + key)
+		}
+	}
 	return nil
 }
@@ -69,7 +69,8 @@ func TestAlgorandMock_ErrorFunctions(t *testing.T) {
 // the limit defined by the application schema
 func TestAlgorandMock_StoreGlobalSemantics(t *testing.T) {
 	client := CreateAlgorandClientMock("", "")
-	appId, err := client.CreateApplication(crypto.GenerateAccount(), "", "")
+	s := NewAccountSigner(crypto.GenerateAccount())
+	appId, err := client.CreateApplication(s, "", "")
 	assert.Nil(t, err)
 
 	// Schema model defines application storage size
@@ -82,7 +83,7 @@ func TestAlgorandMock_StoreGlobalSemantics(t *testing.T) {
 	}
 
 	// We store MAX number the buffer can handle
-	err = client.StoreGlobals(crypto.Account{}, appId, kv)
+	err = client.StoreGlobals(s, appId, kv)
 
 	// New values, same keys
 	kv = make([]models.TealKeyValue, global.NumByteSlice)
@@ -90,7 +91,7 @@ func TestAlgorandMock_StoreGlobalSemantics(t *testing.T) {
 		kv[i].Key = strconv.Itoa(i)
 		kv[i].Value.Bytes = "dummy2"
 	}
-	err = client.StoreGlobals(crypto.Account{}, appId, kv)
+	err = client.StoreGlobals(s, appId, kv)
 	state, _ := client.GetApplicationByID(appId, context.Background())
 	assert.Len(t, state.Params.GlobalState, int(global.NumByteSlice))
 	for _, x := range state.Params.GlobalState {
@@ -102,7 +103,8 @@ func TestAlgorandMock_StoreGlobalSemantics(t *testing.T) {
 		kv[i].Key = "new" + strconv.Itoa(i)
 		kv[i].Value.Bytes = "dummy"
 	}
-	err = client.StoreGlobals(crypto.Account{}, appId, kv)
+	err = client.StoreGlobals(s, appId, kv)
+	assert.NotNil(t, err)
 	state, _ = client.GetApplicationByID(appId, context.Background())
 	assert.Len(t, state.Params.GlobalState, int(global.NumByteSlice))
 	// Values and keys should NOT change, because buffer is already maxed out
@@ -110,3 +112,51 @@ func TestAlgorandMock_StoreGlobalSemantics(t *testing.T) {
 		assertEqualBase64(t, x.Value.Bytes, "dummy2")
 	}
 }
+
+// UpdateGlobals must delete and store within one call, or change nothing at all
+func TestAlgorandMock_UpdateGlobals(t *testing.T) {
+	client := CreateAlgorandClientMock("", "")
+	s := NewAccountSigner(crypto.GenerateAccount())
+	appId, err := client.CreateApplication(s, "", "")
+	assert.Nil(t, err)
+
+	kv := make([]models.TealKeyValue, GlobalBytes)
+	for i := range kv {
+		kv[i].Key = strconv.Itoa(i)
+		kv[i].Value.Bytes = "dummy"
+	}
+	assert.Nil(t, client.StoreGlobals(s, appId, kv))
+
+	// replace a key in a full state
+	newKV := []models.TealKeyValue{{Key: "new", Value: models.TealValue{Bytes: "value"}}}
+	assert.Nil(t, client.UpdateGlobals(s, appId, []string{"0"}, newKV))
+	state, _ := client.GetApplicationByID(appId, context.Background())
+	assert.Len(t, state.Params.GlobalState, GlobalBytes)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("new")), state.Params.GlobalState[GlobalBytes-1].Key)
+
+	// a failing update doesn't delete keys either
+	newKV = append(newKV, models.TealKeyValue{Key: "new2"}, models.TealKeyValue{Key: "new3"})
+	assert.NotNil(t, client.UpdateGlobals(s, appId, []string{"1"}, newKV))
+	state, _ = client.GetApplicationByID(appId, context.Background())
+	assert.Len(t, state.Params.GlobalState, GlobalBytes)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("1")), state.Params.GlobalState[0].Key)
+}
+
+// Like the contract, only the creator and writers may write, and writers only within
+// their prefix
+func TestAlgorandMock_Writers(t *testing.T) {
+	client := CreateAlgorandClientMock("", "")
+	creator, writer := crypto.GenerateAccount(), crypto.GenerateAccount()
+	appId, err := client.CreateApplication(NewAccountSigner(creator), "", "")
+	assert.Nil(t, err)
+
+	kv := []models.TealKeyValue{{Key: "feed:a", Value: models.TealValue{Bytes: "1"}}}
+	assert.NotNil(t, client.StoreGlobals(NewAccountSigner(writer), appId, kv))
+	writerKV := []models.TealKeyValue{{Key: WriterKey(writer.Address), Value: models.TealValue{Bytes: "feed:"}}}
+	assert.Nil(t, client.StoreGlobals(NewAccountSigner(creator), appId, writerKV))
+
+	assert.Nil(t, client.StoreGlobals(NewAccountSigner(writer), appId, kv))
+	assert.NotNil(t, client.DeleteGlobals(NewAccountSigner(writer), appId, "other"))
+	assert.NotNil(t, client.DeleteGlobals(NewAccountSigner(writer), appId, WriterKey(writer.Address)))
+	assert.Nil(t, client.DeleteGlobals(NewAccountSigner(writer), appId, "feed:a"))
+}
@@ -21,6 +21,20 @@ const EnvAlgodToken = "SIAM_ALGOD_TOKEN"
 // application that stores our buffer data.
 const EnvPrivateKey = "SIAM_PRIVATE_KEY"
 
+// EnvKeyFile is the environment variable name of the path of a file holding the private
+// key of the target account, base64 encoded or as mnemonic. It takes precedence over
+// EnvPrivateKey, and keeps the key itself out of the environment.
+const EnvKeyFile = "SIAM_KEY_FILE"
+
+// EnvKeystore is the environment variable name of the path of a password-encrypted
+// keystore holding the private key. The password is read from the file named by
+// EnvKeystorePasswordFile.
+const EnvKeystore = "SIAM_KEYSTORE"
+
+// EnvKeystorePasswordFile is the environment variable name of the path of the file
+// holding the password of EnvKeystore.
+const EnvKeystorePasswordFile = "SIAM_KEYSTORE_PASSWORD_FILE"
+
 // EnvHeadersNode is the environment variable name of the custom headers that are sent
 // to the Algorand algod-node. This is useful if you're calling custom Node providers
 // like PureStake that define their own header and API key. Use the following syntax:
@@ -36,24 +50,34 @@ func GetAlgorandEnvironmentVars() (URL string, token string, base64key string, h
 	URL = os.Getenv(EnvURLNode)
 	token = os.Getenv(EnvAlgodToken)
 	base64key = os.Getenv(EnvPrivateKey)
-	rawHeader := os.Getenv(EnvHeadersNode)
-	for _, s := range strings.Split(rawHeader, "&") {
-		kv := strings.Split(s, ":")
-		if len(kv) != 2 {
+	headers = ParseHeaders(os.Getenv(EnvHeadersNode))
+	return URL, token, base64key, headers
+}
+
+// ParseHeaders parses custom headers in the syntax of EnvHeadersNode:
+// "header1:value1&header2:value2". Only the first colon separates the header name, so
+// values may contain colons. Values can't contain "&", use a config file for those.
+func ParseHeaders(raw string) (headers []*common.Header) {
+	for _, s := range strings.Split(raw, "&") {
+		kv := strings.SplitN(s, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
 			continue
 		}
-		headers = append(headers, &common.Header{Key: kv[0], Value: kv[1]})
+		headers = append(headers, &common.Header{Key: strings.TrimSpace(kv[0]), Value: strings.TrimSpace(kv[1])})
 	}
-	return URL, token, base64key, headers
+	return headers
 }
 
 // HasEnvironmentVars returns true if the necessary configuration environment variables are set.
 // These environment variables store configuration for the AlgorandClient.
 // Key and URL MUST exist. Either token or headers need to be set. Returns false if both are missing.
+// The key is given by EnvPrivateKey, EnvKeyFile or EnvKeystore.
 func HasEnvironmentVars() bool {
 	_, existsURL := os.LookupEnv(EnvURLNode)
 	_, existsToken := os.LookupEnv(EnvAlgodToken)
 	_, existsHeaders := os.LookupEnv(EnvHeadersNode)
 	_, existsKey := os.LookupEnv(EnvPrivateKey)
-	return existsURL && existsKey && (existsToken || existsHeaders)
+	_, existsKeyFile := os.LookupEnv(EnvKeyFile)
+	_, existsKeystore := os.LookupEnv(EnvKeystore)
+	return existsURL && (existsKey || existsKeyFile || existsKeystore) && (existsToken || existsHeaders)
 }
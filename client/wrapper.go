@@ -2,11 +2,13 @@ package client
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/algorand/go-algorand-sdk/client/v2/algod"
 	"github.com/algorand/go-algorand-sdk/client/v2/common"
 	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
-	"github.com/algorand/go-algorand-sdk/crypto"
 	"github.com/algorand/go-algorand-sdk/future"
 	"github.com/algorand/go-algorand-sdk/types"
 )
@@ -15,8 +17,16 @@ import (
 // algod.Client
 type AlgorandClientWrapper struct {
 	Client *algod.Client
+
+	// Fee is the flat fee of every transaction in microAlgos. If zero, DefaultFee is
+	// used.
+	Fee uint64
 }
 
+// DefaultFee is the flat fee of transactions sent by the AlgorandClientWrapper, which
+// is the minimum fee of the network.
+const DefaultFee = 1000
+
 func CreateAlgorandClientWrapper(URL string, token string) (*AlgorandClientWrapper, error) {
 	c, err := algod.MakeClient(URL, token)
 	return &AlgorandClientWrapper{Client: c}, err
@@ -32,13 +42,26 @@ func (a *AlgorandClientWrapper) SuggestedParams(ctx context.Context) (types.Sugg
 	params, err := a.Client.SuggestedParams().Do(ctx)
 	if err == nil {
 		params.FlatFee = true
-		params.Fee = 1000
+		params.Fee = DefaultFee
+		if a.Fee != 0 {
+			params.Fee = types.MicroAlgos(a.Fee)
+		}
 	}
 	return params, err
 }
 
 func (a *AlgorandClientWrapper) HealthCheck(ctx context.Context) error {
-	return a.Client.HealthCheck().Do(ctx)
+	return healthy(a.Client.HealthCheck().Do(ctx))
+}
+
+// healthy returns the error of a health check. The SDK decodes the empty response of
+// a healthy node into a nil target, which fails with a *json.InvalidUnmarshalError.
+func healthy(err error) error {
+	var unmarshal *json.InvalidUnmarshalError
+	if errors.As(err, &unmarshal) {
+		return nil
+	}
+	return err
 }
 
 func (a *AlgorandClientWrapper) Status(ctx context.Context) (models.NodeStatus, error) {
@@ -69,19 +92,27 @@ func (a *AlgorandClientWrapper) TealCompile(b []byte, ctx context.Context) (resp
 	return a.Client.TealCompile(b).Do(ctx)
 }
 
-func (a *AlgorandClientWrapper) ExecuteTransaction(acc crypto.Account, txn types.Transaction, ctx context.Context) (models.PendingTransactionInfoResponse, error) {
-	_, signedTxn, err := crypto.SignTransaction(acc.PrivateKey, txn)
+func (a *AlgorandClientWrapper) ExecuteTransaction(s Signer, txn types.Transaction, ctx context.Context) (models.PendingTransactionInfoResponse, error) {
+	signed, err := s.SignTransactions(ctx, []types.Transaction{txn})
 	if err != nil {
-		return models.PendingTransactionInfoResponse{}, err
+		return models.PendingTransactionInfoResponse{}, &ErrRejected{Err: err}
 	}
 
-	txID, err := a.SendRawTransaction(signedTxn, ctx)
+	txID, err := a.SendRawTransaction(signed[0], ctx)
 	if err != nil {
+		// the node refused the transaction, e.g. because the contract rejected it
+		if status := httpStatus(err); status >= 400 && status < 500 {
+			return models.PendingTransactionInfoResponse{}, &ErrRejected{Err: err}
+		}
 		return models.PendingTransactionInfoResponse{}, err
 	}
 
 	_, err = future.WaitForConfirmation(a.Client, txID, 5, ctx)
 	if err != nil {
+		// WaitForConfirmation also gives up if the transaction was dropped from the pool
+		if info, _, e := a.PendingTransactionInformation(txID, ctx); e == nil && info.PoolError != "" {
+			return models.PendingTransactionInfoResponse{}, &ErrRejected{Err: err}
+		}
 		return models.PendingTransactionInfoResponse{}, err
 	}
 
@@ -89,7 +120,7 @@ func (a *AlgorandClientWrapper) ExecuteTransaction(acc crypto.Account, txn types
 	return response, err
 }
 
-func (a *AlgorandClientWrapper) DeleteApplication(acc crypto.Account, appId uint64) error {
+func (a *AlgorandClientWrapper) DeleteApplication(s Signer, appId uint64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), AlgorandDefaultTimeout)
 	params, err := a.SuggestedParams(ctx)
 	cancel()
@@ -97,15 +128,15 @@ func (a *AlgorandClientWrapper) DeleteApplication(acc crypto.Account, appId uint
 		return err
 	}
 	txn, _ := future.MakeApplicationDeleteTx(appId, nil, nil, nil, nil,
-		params, acc.Address, nil, types.Digest{}, [32]byte{}, types.Address{})
+		params, s.Address(), nil, types.Digest{}, [32]byte{}, types.Address{})
 
 	ctx, cancel = context.WithTimeout(context.Background(), AlgorandDefaultTimeout*2)
-	_, err = a.ExecuteTransaction(acc, txn, ctx)
+	_, err = a.ExecuteTransaction(s, txn, ctx)
 	cancel()
 	return err
 }
 
-func (a *AlgorandClientWrapper) CreateApplication(acc crypto.Account, approve string, clear string) (uint64, error) {
+func (a *AlgorandClientWrapper) CreateApplication(s Signer, approve string, clear string) (uint64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), AlgorandDefaultTimeout)
 	params, err := a.SuggestedParams(ctx)
 	cancel()
@@ -117,11 +148,11 @@ func (a *AlgorandClientWrapper) CreateApplication(acc crypto.Account, approve st
 	clr := CompileProgram(a, []byte(clear))
 
 	txn, _ := future.MakeApplicationCreateTx(false, appr, clr, globalSchema, localSchema,
-		nil, nil, nil, nil, params, acc.Address, nil,
+		nil, nil, nil, nil, params, s.Address(), nil,
 		types.Digest{}, [32]byte{}, types.Address{})
 
 	ctx, cancel = context.WithTimeout(context.Background(), AlgorandDefaultTimeout*2)
-	result, err := a.ExecuteTransaction(acc, txn, ctx)
+	result, err := a.ExecuteTransaction(s, txn, ctx)
 	cancel()
 	if err != nil {
 		return 0, err
@@ -129,30 +160,52 @@ func (a *AlgorandClientWrapper) CreateApplication(acc crypto.Account, approve st
 	return result.ApplicationIndex, nil
 }
 
-func (a *AlgorandClientWrapper) DeleteGlobals(acc crypto.Account, appId uint64, args ...string) error {
+func (a *AlgorandClientWrapper) DeleteGlobals(s Signer, appId uint64, args ...string) error {
 	// convert args from []string to [][]byte
 	convArg := make([][]byte, len(args))
 	for i, x := range args {
 		convArg[i] = []byte(x)
 	}
-	return a.postArgumentsToApp(acc, appId, "delete", convArg)
+	return a.postArgumentsToApp(s, appId, "delete", convArg)
 }
 
-func (a *AlgorandClientWrapper) StoreGlobals(acc crypto.Account, appId uint64, tkv []models.TealKeyValue) error {
+func (a *AlgorandClientWrapper) SweepGlobals(s Signer, appId uint64, keys ...string) error {
+	args := make([][]byte, len(keys))
+	for i, k := range keys {
+		args[i] = []byte(k)
+	}
+	return a.postArgumentsToApp(s, appId, "sweep", args)
+}
+
+func (a *AlgorandClientWrapper) StoreGlobals(s Signer, appId uint64, tkv []models.TealKeyValue) error {
 	// convert TEAL kv pair to [][]byte arguments
 	args := make([][]byte, len(tkv)*2)
 	for i, kv := range tkv {
 		args[i*2] = []byte(kv.Key)
 		args[i*2+1] = []byte(kv.Value.Bytes)
 	}
-	return a.postArgumentsToApp(acc, appId, "put", args)
+	return a.postArgumentsToApp(s, appId, "put", args)
+}
+
+func (a *AlgorandClientWrapper) UpdateGlobals(s Signer, appId uint64, keys []string, tkv []models.TealKeyValue) error {
+	// first argument is the number of keys to delete, followed by keys and kv pairs
+	args := make([][]byte, 1, 1+len(keys)+len(tkv)*2)
+	args[0] = make([]byte, 8)
+	binary.BigEndian.PutUint64(args[0], uint64(len(keys)))
+	for _, k := range keys {
+		args = append(args, []byte(k))
+	}
+	for _, kv := range tkv {
+		args = append(args, []byte(kv.Key), []byte(kv.Value.Bytes))
+	}
+	return a.postArgumentsToApp(s, appId, "update", args)
 }
 
 // postArgumentsToApp creates and publishes a No-Op transaction with given arguments
 // to the application. A note is also added to the transaction. The note determines
 // how the Arguments of the No-Op call get interpreted. You can distill note options
 // from the approval.teal contract.
-func (a *AlgorandClientWrapper) postArgumentsToApp(acc crypto.Account, appId uint64, note string, args [][]byte) error {
+func (a *AlgorandClientWrapper) postArgumentsToApp(s Signer, appId uint64, note string, args [][]byte) error {
 	ctx, cancel := context.WithTimeout(context.Background(), AlgorandDefaultTimeout)
 	params, err := a.SuggestedParams(ctx)
 	cancel()
@@ -160,10 +213,10 @@ func (a *AlgorandClientWrapper) postArgumentsToApp(acc crypto.Account, appId uin
 		return fmt.Errorf("error getting suggested tx params: %s", err)
 	}
 	txn, _ := future.MakeApplicationNoOpTx(appId, args,
-		nil, nil, nil, params, acc.Address, []byte(note), types.Digest{}, [32]byte{}, types.Address{})
+		nil, nil, nil, params, s.Address(), []byte(note), types.Digest{}, [32]byte{}, types.Address{})
 
 	ctx, cancel = context.WithTimeout(context.Background(), AlgorandDefaultTimeout)
-	_, err = a.ExecuteTransaction(acc, txn, ctx)
+	_, err = a.ExecuteTransaction(s, txn, ctx)
 	cancel()
 	return err
 }
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/types"
+	"gopkg.in/yaml.v3"
+
+	siam "github.com/m2q/algo-siam"
+	"github.com/m2q/algo-siam/client"
+)
+
+// Flags of individual commands.
+var (
+	keygenFormat  string
+	applyFile     string
+	applyDryRun   bool
+	watchInterval time.Duration
+	destroyYes    bool
+)
+
+func keygenFlags(fs *flag.FlagSet) {
+	fs.StringVar(&keygenFormat, "format", "base64", "key format: base64, mnemonic or keystore (written to -keystore)")
+}
+
+func applyFlags(fs *flag.FlagSet) {
+	fs.StringVar(&applyFile, "f", "", "YAML file with the desired state, a map of keys to values")
+	fs.BoolVar(&applyDryRun, "dry-run", false, "only print the plan")
+}
+
+func watchFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&watchInterval, "interval", 5*time.Second, "polling interval")
+}
+
+func destroyFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&destroyYes, "yes", false, "confirm that the applications and their data are deleted")
+}
+
+func runKeygen(_ context.Context, cfg *config, _ []string) error {
+	acc := crypto.GenerateAccount()
+	address := acc.Address.String()
+	var (
+		name, key string
+		err       error
+	)
+	switch keygenFormat {
+	case "base64":
+		name, key = "private_key", base64.StdEncoding.EncodeToString(acc.PrivateKey)
+	case "mnemonic":
+		name = "mnemonic"
+		if key, err = siam.FormatMnemonic.Encode(acc.PrivateKey); err != nil {
+			return err
+		}
+	case "keystore":
+		if cfg.keystore == "" || cfg.passwordFile == "" {
+			return errors.New("the keystore format needs -keystore and -password-file")
+		}
+		password, err := siam.ReadPasswordFile(cfg.passwordFile)
+		if err != nil {
+			return err
+		}
+		if err = siam.WriteKeystore(cfg.keystore, acc.PrivateKey, password); err != nil {
+			return err
+		}
+		name, key = "keystore", cfg.keystore
+	default:
+		return fmt.Errorf("unknown key format %q", keygenFormat)
+	}
+	if cfg.output == "json" {
+		return cfg.printJSON(map[string]string{"address": address, name: key})
+	}
+	return cfg.printTable([]string{"ADDRESS", strings.ToUpper(strings.ReplaceAll(name, "_", " "))}, [][]string{{address, key}})
+}
+
+func runInfo(ctx context.Context, cfg *config, _ []string) error {
+	c, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	address, err := cfg.accountAddress()
+	if err != nil {
+		return err
+	}
+	info, err := c.AccountInformation(address, ctx)
+	if err != nil {
+		return err
+	}
+
+	type appInfo struct {
+		Id         uint64 `json:"id"`
+		ByteSlices uint64 `json:"byte_slices"`
+		Uints      uint64 `json:"uints"`
+		UsedKeys   int    `json:"used_keys"`
+		Valid      bool   `json:"valid"`
+	}
+	apps := make([]appInfo, len(info.CreatedApps))
+	for i, app := range info.CreatedApps {
+		apps[i] = appInfo{
+			Id:         app.Id,
+			ByteSlices: app.Params.GlobalStateSchema.NumByteSlice,
+			Uints:      app.Params.GlobalStateSchema.NumUint,
+			UsedKeys:   len(app.Params.GlobalState),
+			Valid:      client.FulfillsSchema(app),
+		}
+	}
+	if cfg.output == "json" {
+		return cfg.printJSON(map[string]interface{}{
+			"address": info.Address,
+			"amount":  info.Amount,
+			"status":  info.Status,
+			"apps":    apps,
+		})
+	}
+	err = cfg.printTable([]string{"ADDRESS", "AMOUNT (µALGO)", "STATUS"}, [][]string{
+		{info.Address, strconv.FormatUint(info.Amount, 10), info.Status},
+	})
+	if err != nil || len(apps) == 0 {
+		return err
+	}
+	fmt.Fprintln(cfg.stdout)
+	rows := make([][]string, len(apps))
+	for i, a := range apps {
+		rows[i] = []string{
+			strconv.FormatUint(a.Id, 10),
+			fmt.Sprintf("%d bytes, %d uints", a.ByteSlices, a.Uints),
+			fmt.Sprintf("%d/%d", a.UsedKeys, a.ByteSlices+a.Uints),
+			strconv.FormatBool(a.Valid),
+		}
+	}
+	return cfg.printTable([]string{"APP", "SCHEMA", "KEYS", "VALID"}, rows)
+}
+
+func runGet(ctx context.Context, cfg *config, args []string) error {
+	r, err := cfg.reader(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := r.GetBufferRaw(ctx)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return cfg.printPairs(data)
+	}
+	selected := make(map[string][]byte, len(args))
+	for _, k := range args {
+		v, ok := data[k]
+		if !ok {
+			return fmt.Errorf("key %q doesn't exist", k)
+		}
+		selected[k] = v
+	}
+	return cfg.printPairs(selected)
+}
+
+func runPut(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("no pairs given, use key=value")
+	}
+	data := make(map[string]string, len(args))
+	for _, a := range args {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid pair %q, use key=value", a)
+		}
+		data[kv[0]] = kv[1]
+	}
+	buffer, err := cfg.buffer(siam.StartupExisting)
+	if err != nil {
+		return err
+	}
+	defer buffer.Close()
+	return buffer.PutElements(ctx, data)
+}
+
+func runDelete(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("no keys given")
+	}
+	buffer, err := cfg.buffer(siam.StartupExisting)
+	if err != nil {
+		return err
+	}
+	defer buffer.Close()
+	return buffer.DeleteElements(ctx, args...)
+}
+
+func runApply(ctx context.Context, cfg *config, _ []string) error {
+	if applyFile == "" {
+		return errors.New("no state file given, use -f state.yaml")
+	}
+	b, err := os.ReadFile(applyFile)
+	if err != nil {
+		return err
+	}
+	desired := make(map[string]string)
+	if err = yaml.Unmarshal(b, &desired); err != nil {
+		return fmt.Errorf("parsing %s: %s", applyFile, err)
+	}
+
+	buffer, err := cfg.buffer(siam.StartupExisting)
+	if err != nil {
+		return err
+	}
+	defer buffer.Close()
+	plan, err := buffer.Plan(ctx, desired)
+	if err != nil {
+		return err
+	}
+	if err = cfg.printPlan(plan); err != nil {
+		return err
+	}
+	if applyDryRun || plan.Empty() {
+		return nil
+	}
+	return buffer.Apply(ctx, plan)
+}
+
+func runWatch(ctx context.Context, cfg *config, _ []string) error {
+	r, err := cfg.reader(ctx)
+	if err != nil {
+		return err
+	}
+	for cs := range r.Watch(ctx, watchInterval) {
+		if cs.Err != nil {
+			fmt.Fprintf(cfg.stderr, "%s: %s\n", cs.Time.Format(time.RFC3339), cs.Err)
+			continue
+		}
+		if cfg.output == "json" {
+			if err = cfg.printJSON(cs); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, ch := range cs.Changes {
+			value := formatValue(ch.Value)
+			if ch.Deleted {
+				value = "(deleted)"
+			}
+			fmt.Fprintf(cfg.stdout, "%d\t%s\t%s\n", cs.Round, ch.Key, value)
+		}
+	}
+	return nil
+}
+
+func runWriters(ctx context.Context, cfg *config, args []string) error {
+	buffer, err := cfg.buffer(siam.StartupExisting)
+	if err != nil {
+		return err
+	}
+	defer buffer.Close()
+	if len(args) > 0 {
+		return manageWriters(ctx, buffer, args)
+	}
+	writers, err := buffer.Writers(ctx)
+	if err != nil {
+		return err
+	}
+	m := make(map[string]string, len(writers))
+	for addr, prefix := range writers {
+		m[addr.String()] = prefix
+	}
+	if cfg.output == "json" {
+		return cfg.printJSON(m)
+	}
+	addrs := make([]string, 0, len(m))
+	for a := range m {
+		addrs = append(addrs, a)
+	}
+	sort.Strings(addrs)
+	rows := make([][]string, len(addrs))
+	for i, a := range addrs {
+		rows[i] = []string{a, m[a]}
+	}
+	return cfg.printTable([]string{"WRITER", "PREFIX"}, rows)
+}
+
+// manageWriters runs the add and remove subcommands of the writers command.
+func manageWriters(ctx context.Context, buffer *siam.AlgorandBuffer, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing address, use %s address", args[0])
+	}
+	addr, err := types.DecodeAddress(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %s", args[1], err)
+	}
+	switch {
+	case args[0] == "add" && len(args) <= 3:
+		prefix := ""
+		if len(args) == 3 {
+			prefix = args[2]
+		}
+		return buffer.AddWriter(ctx, addr, prefix)
+	case args[0] == "remove" && len(args) == 2:
+		return buffer.RemoveWriter(ctx, addr)
+	}
+	return errors.New("use writers add address [prefix] or writers remove address")
+}
+
+func runCreate(_ context.Context, cfg *config, _ []string) error {
+	buffer, err := cfg.buffer(siam.StartupManage)
+	if err != nil {
+		return err
+	}
+	defer buffer.Close()
+	if cfg.output == "json" {
+		return cfg.printJSON(map[string]uint64{"app": buffer.AppId})
+	}
+	return cfg.printTable([]string{"APP"}, [][]string{{strconv.FormatUint(buffer.AppId, 10)}})
+}
+
+func runDestroy(ctx context.Context, cfg *config, _ []string) error {
+	s, err := cfg.signer()
+	if err != nil {
+		return err
+	}
+	c, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	info, err := c.AccountInformation(s.Address().String(), ctx)
+	if err != nil {
+		return err
+	}
+	if len(info.CreatedApps) == 0 {
+		return errors.New("account has no applications")
+	}
+	if !destroyYes {
+		ids := make([]string, len(info.CreatedApps))
+		for i, app := range info.CreatedApps {
+			ids[i] = strconv.FormatUint(app.Id, 10)
+		}
+		return fmt.Errorf("refusing to delete applications %s and their data without -yes", strings.Join(ids, ", "))
+	}
+	for _, app := range info.CreatedApps {
+		if err = c.DeleteApplication(s, app.Id); err != nil {
+			return err
+		}
+		fmt.Fprintf(cfg.stdout, "deleted application %d\n", app.Id)
+	}
+	return nil
+}
+
+// printPlan writes the changes of a plan.
+func (c *config) printPlan(p *siam.Plan) error {
+	if c.output == "json" {
+		return c.printJSON(p)
+	}
+	keys := make([]string, 0, len(p.Puts))
+	for k := range p.Puts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := make([][]string, 0, len(p.Puts)+len(p.Deletes)+len(p.Violations))
+	for _, k := range keys {
+		rows = append(rows, []string{"put", k, formatValue([]byte(p.Puts[k]))})
+	}
+	for _, k := range p.Deletes {
+		rows = append(rows, []string{"delete", k, ""})
+	}
+	for _, v := range p.Violations {
+		rows = append(rows, []string{"violation", v.Key, v.Reason})
+	}
+	if err := c.printTable([]string{"ACTION", "KEY", "VALUE"}, rows); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(c.stdout, "\n%d transactions, estimated fee %d µALGO\n", p.Transactions, p.EstimatedFee)
+	return err
+}
+
+// keySource returns the key source of the -key-file, -keystore or -key flag, in
+// that order.
+func (c *config) keySource() (siam.KeySource, error) {
+	switch {
+	case c.keyFile != "":
+		return siam.KeyFile(c.keyFile), nil
+	case c.keystore != "":
+		if c.passwordFile == "" {
+			return nil, errors.New("-keystore needs -password-file or " + client.EnvKeystorePasswordFile)
+		}
+		password, err := siam.ReadPasswordFile(c.passwordFile)
+		if err != nil {
+			return nil, err
+		}
+		return siam.Keystore{Path: c.keystore, Password: password}, nil
+	case c.key != "":
+		return siam.Base64Key(c.key), nil
+	}
+	return nil, errors.New("no private key, set -key, -key-file or -keystore")
+}
+
+// signer returns the signer of the -signer-url flag, or of the key source.
+func (c *config) signer() (client.Signer, error) {
+	if c.signerURL != "" {
+		if c.address == "" {
+			return nil, errors.New("-signer-url needs -address")
+		}
+		return client.NewRemoteSigner(c.signerURL, c.signerToken, c.address)
+	}
+	src, err := c.keySource()
+	if err != nil {
+		return nil, err
+	}
+	pk, err := src.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	acc, err := crypto.AccountFromPrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewAccountSigner(acc), nil
+}
+
+// accountAddress returns the address of the -address flag, or of the signer.
+func (c *config) accountAddress() (string, error) {
+	if c.address != "" {
+		return c.address, nil
+	}
+	s, err := c.signer()
+	if err != nil {
+		return "", err
+	}
+	return s.Address().String(), nil
+}
+
+// buffer creates the AlgorandBuffer of the account with the given startup policy.
+// Only StartupManage creates and deletes applications. With -app, the account writes
+// to the given application instead.
+func (c *config) buffer(startup siam.StartupPolicy) (*siam.AlgorandBuffer, error) {
+	algod, err := newClient(c)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.signer()
+	if err != nil {
+		return nil, err
+	}
+	if c.appId != 0 {
+		return siam.NewAlgorandBufferWithSigner(algod, s, siam.WithApplication(c.appId))
+	}
+	return siam.NewAlgorandBufferWithSigner(algod, s, siam.WithStartupPolicy(startup))
+}
+
+// reader creates a Reader for the -app flag, or the application of the account.
+// Nothing is created or deleted.
+func (c *config) reader(ctx context.Context) (*siam.Reader, error) {
+	algod, err := newClient(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.appId != 0 {
+		return siam.NewReader(algod, c.appId), nil
+	}
+	address, err := c.accountAddress()
+	if err != nil {
+		return nil, err
+	}
+	info, err := algod.AccountInformation(address, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.CreatedApps) != 1 {
+		return nil, fmt.Errorf("account has %d applications, select one with -app", len(info.CreatedApps))
+	}
+	return siam.NewReader(algod, info.CreatedApps[0].Id), nil
+}
@@ -0,0 +1,201 @@
+// Command siam inspects and modifies Siam oracle applications on the Algorand
+// blockchain.
+//
+// Usage:
+//
+//	siam <command> [flags] [arguments]
+//
+// Connection settings are read from the SIAM_* environment variables, and can be
+// overridden with flags. Run "siam help" for a list of commands.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// command is a subcommand of the CLI.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, cfg *config, args []string) error
+	// flags registers additional flags of the command.
+	flags func(fs *flag.FlagSet)
+}
+
+var commands = []*command{
+	{name: "keygen", usage: "generate a new account", run: runKeygen, flags: keygenFlags},
+	{name: "info", usage: "show the account, its balance and applications", run: runInfo},
+	{name: "get", usage: "get [key...]: print all or the given pairs", run: runGet},
+	{name: "put", usage: "put key=value...: store pairs", run: runPut},
+	{name: "delete", usage: "delete key...: delete keys", run: runDelete},
+	{name: "apply", usage: "apply -f state.yaml: turn the state into the given state", run: runApply, flags: applyFlags},
+	{name: "watch", usage: "print changes as they happen", run: runWatch, flags: watchFlags},
+	{name: "writers", usage: "writers [add address [prefix] | remove address]: list or manage the writers", run: runWriters},
+	{name: "create", usage: "create the application of the account, if it has none", run: runCreate},
+	{name: "destroy", usage: "destroy -yes: delete all applications of the account", run: runDestroy, flags: destroyFlags},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stderr)
+		return 2
+	}
+	var cmd *command
+	for _, c := range commands {
+		if c.name == args[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		printUsage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("siam "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cfg := &config{stdout: stdout, stderr: stderr}
+	cfg.register(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if cfg.output != "table" && cfg.output != "json" {
+		fmt.Fprintf(stderr, "unknown output format %q\n", cfg.output)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := cmd.run(ctx, cfg, fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: siam <command> [flags] [arguments]\n\ncommands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.usage)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nRun \"siam <command> -h\" for the flags of a command.")
+}
+
+// config holds the connection settings and output format.
+type config struct {
+	url          string
+	token        string
+	headers      string
+	key          string
+	keyFile      string
+	keystore     string
+	passwordFile string
+	signerURL    string
+	signerToken  string
+	address      string
+	appId        uint64
+	output       string
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// register adds the common flags, with defaults from the environment variables.
+func (c *config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.url, "url", os.Getenv(client.EnvURLNode), "URL of the algod node ("+client.EnvURLNode+")")
+	fs.StringVar(&c.token, "token", os.Getenv(client.EnvAlgodToken), "algod API token ("+client.EnvAlgodToken+")")
+	fs.StringVar(&c.headers, "headers", os.Getenv(client.EnvHeadersNode), "custom headers as h1:v1&h2:v2 ("+client.EnvHeadersNode+")")
+	fs.StringVar(&c.key, "key", os.Getenv(client.EnvPrivateKey), "base64 private key of the account ("+client.EnvPrivateKey+")")
+	fs.StringVar(&c.keyFile, "key-file", os.Getenv(client.EnvKeyFile), "file with the base64 private key or mnemonic ("+client.EnvKeyFile+")")
+	fs.StringVar(&c.keystore, "keystore", os.Getenv(client.EnvKeystore), "keystore file of the account ("+client.EnvKeystore+")")
+	fs.StringVar(&c.passwordFile, "password-file", os.Getenv(client.EnvKeystorePasswordFile), "file with the keystore password ("+client.EnvKeystorePasswordFile+")")
+	fs.StringVar(&c.signerURL, "signer-url", "", "URL of a remote signing service for the account of -address, instead of a key")
+	fs.StringVar(&c.signerToken, "signer-token", "", "bearer token of the remote signing service")
+	fs.StringVar(&c.address, "address", "", "address of the account, for read-only commands without -key")
+	fs.Uint64Var(&c.appId, "app", 0, "application ID, for read-only commands without -key, or of another account to write to as writer")
+	fs.StringVar(&c.output, "o", "table", "output format: table or json")
+}
+
+// newClient creates the client of the algod node. Tests replace it with a mock.
+var newClient = func(c *config) (client.AlgorandClient, error) {
+	if c.url == "" {
+		return nil, errors.New("no node URL, set -url or " + client.EnvURLNode)
+	}
+	if headers := client.ParseHeaders(c.headers); len(headers) > 0 {
+		return client.NewClientWithHeaders(c.url, c.token, headers)
+	}
+	return client.CreateAlgorandClientWrapper(c.url, c.token)
+}
+
+// printJSON writes v as indented JSON.
+func (c *config) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printTable writes the given rows as aligned columns.
+func (c *config) printTable(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+// printPairs writes key-value pairs sorted by key.
+func (c *config) printPairs(data map[string][]byte) error {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if c.output == "json" {
+		m := make(map[string]string, len(data))
+		for k, v := range data {
+			m[k] = formatValue(v)
+		}
+		return c.printJSON(m)
+	}
+	rows := make([][]string, len(keys))
+	for i, k := range keys {
+		rows[i] = []string{k, formatValue(data[k])}
+	}
+	return c.printTable([]string{"KEY", "VALUE"}, rows)
+}
+
+// formatValue returns printable values as they are, and binary values as hex with
+// a 0x prefix.
+func formatValue(b []byte) string {
+	if utf8.Valid(b) && strings.IndexFunc(string(b), func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return string(b)
+	}
+	return "0x" + hex.EncodeToString(b)
+}
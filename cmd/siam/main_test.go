@@ -0,0 +1,191 @@
+//go:build unit
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockCLI replaces the algod client with a mock, and returns a function that runs
+// the CLI with the key of a fresh account.
+func mockCLI(t *testing.T) func(args ...string) (string, string, int) {
+	c := client.CreateAlgorandClientMock("", "")
+	old := newClient
+	newClient = func(*config) (client.AlgorandClient, error) { return c, nil }
+	t.Cleanup(func() { newClient = old })
+
+	key := client.GeneratePrivateKey64()
+	return func(args ...string) (string, string, int) {
+		var stdout, stderr bytes.Buffer
+		args = append([]string{args[0], "-key", key}, args[1:]...)
+		code := run(args, &stdout, &stderr)
+		return stdout.String(), stderr.String(), code
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run(nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "commands:")
+	assert.Equal(t, 2, run([]string{"frobnicate"}, &stdout, &stderr))
+	assert.Equal(t, 2, run([]string{"keygen", "-o", "xml"}, &stdout, &stderr))
+}
+
+func TestRun_Keygen(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run([]string{"keygen", "-o", "json"}, &stdout, &stderr))
+	var out map[string]string
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &out))
+	assert.Len(t, out["address"], 58)
+	assert.NotEmpty(t, out["private_key"])
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"keygen", "-o", "json", "-format", "mnemonic"}, &stdout, &stderr))
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &out))
+	assert.Len(t, strings.Fields(out["mnemonic"]), 25)
+}
+
+func TestRun_KeygenKeystore(t *testing.T) {
+	dir := t.TempDir()
+	keystore := filepath.Join(dir, "oracle.keystore")
+	pwFile := filepath.Join(dir, "password")
+	assert.Nil(t, os.WriteFile(pwFile, []byte("hunter2\n"), 0600))
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"keygen", "-o", "json", "-format", "keystore", "-keystore", keystore, "-password-file", pwFile}
+	assert.Equal(t, 0, run(args, &stdout, &stderr), stderr.String())
+	var out map[string]string
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &out))
+
+	cfg := &config{keystore: keystore, passwordFile: pwFile}
+	s, err := cfg.signer()
+	assert.Nil(t, err)
+	assert.Equal(t, out["address"], s.Address().String())
+
+	// the keystore is not overwritten
+	assert.Equal(t, 1, run(args, &stdout, &stderr))
+}
+
+func TestRun_PutGetDelete(t *testing.T) {
+	siam := mockCLI(t)
+
+	// only create manages applications
+	_, stderr, code := siam("put", "1000=Astralis")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "no application")
+	_, _, code = siam("create")
+	assert.Equal(t, 0, code)
+
+	_, stderr, code = siam("put", "1000=Astralis", "1001=a=b")
+	assert.Equal(t, 0, code, stderr)
+
+	stdout, _, code := siam("get", "-o", "json")
+	assert.Equal(t, 0, code)
+	var out map[string]string
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &out))
+	assert.Equal(t, map[string]string{"1000": "Astralis", "1001": "a=b"}, out)
+
+	stdout, _, code = siam("get", "1000")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "Astralis")
+	assert.NotContains(t, stdout, "a=b")
+
+	_, stderr, code = siam("get", "9999")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "doesn't exist")
+
+	_, _, code = siam("delete", "1000")
+	assert.Equal(t, 0, code)
+	stdout, _, _ = siam("get")
+	assert.NotContains(t, stdout, "Astralis")
+
+	_, _, code = siam("put", "novalue")
+	assert.Equal(t, 1, code)
+}
+
+func TestRun_Apply(t *testing.T) {
+	siam := mockCLI(t)
+	_, _, code := siam("create")
+	assert.Equal(t, 0, code)
+	_, _, code = siam("put", "1000=Astralis", "1001=OG")
+	assert.Equal(t, 0, code)
+
+	file := filepath.Join(t.TempDir(), "state.yaml")
+	assert.Nil(t, os.WriteFile(file, []byte("\"1001\": G2\n\"1002\": Vitality\n"), 0600))
+
+	stdout, stderr, code := siam("apply", "-f", file, "-dry-run")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "delete  1000")
+	assert.Contains(t, stdout, "put     1002")
+	stdout, _, _ = siam("get")
+	assert.Contains(t, stdout, "Astralis")
+
+	_, stderr, code = siam("apply", "-f", file)
+	assert.Equal(t, 0, code, stderr)
+	stdout, _, _ = siam("get", "-o", "json")
+	var out map[string]string
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &out))
+	assert.Equal(t, map[string]string{"1001": "G2", "1002": "Vitality"}, out)
+}
+
+func TestRun_Writers(t *testing.T) {
+	siam := mockCLI(t)
+	stdout, _, code := siam("create", "-o", "json")
+	assert.Equal(t, 0, code)
+	var app map[string]uint64
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &app))
+	appId := strconv.FormatUint(app["app"], 10)
+
+	acc := crypto.GenerateAccount()
+	feeder := base64.StdEncoding.EncodeToString(acc.PrivateKey)
+	_, stderr, code := siam("put", "-key", feeder, "-app", appId, "feed:btc=64000")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "is not a writer")
+
+	_, stderr, code = siam("writers", "add", acc.Address.String(), "feed:")
+	assert.Equal(t, 0, code, stderr)
+	stdout, _, code = siam("writers")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, acc.Address.String()+"  feed:")
+
+	_, stderr, code = siam("put", "-key", feeder, "-app", appId, "feed:btc=64000")
+	assert.Equal(t, 0, code, stderr)
+	_, stderr, code = siam("put", "-key", feeder, "-app", appId, "btc=64000")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "writer prefix")
+
+	_, _, code = siam("writers", "remove", acc.Address.String())
+	assert.Equal(t, 0, code)
+	_, _, code = siam("writers", "remove")
+	assert.Equal(t, 1, code)
+}
+
+func TestRun_Destroy(t *testing.T) {
+	siam := mockCLI(t)
+	_, _, code := siam("create")
+	assert.Equal(t, 0, code)
+
+	_, stderr, code := siam("destroy")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "without -yes")
+
+	stdout, _, code := siam("destroy", "-yes")
+	assert.Equal(t, 0, code)
+	assert.True(t, strings.HasPrefix(stdout, "deleted application"))
+}
+
+func TestFormatValue(t *testing.T) {
+	assert.Equal(t, "Astralis", formatValue([]byte("Astralis")))
+	assert.Equal(t, "0x00ff", formatValue([]byte{0, 0xff}))
+}
@@ -0,0 +1,285 @@
+package siam
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	"github.com/algorand/go-codec/codec"
+)
+
+// Codec converts values of type T to and from the bytes stored in a kv pair.
+type Codec[T any] interface {
+	Encode(value T) ([]byte, error)
+	Decode(b []byte) (T, error)
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// MsgpackCodec encodes values with canonical msgpack, as used by the Algorand SDK.
+// Struct fields can be renamed with `codec:"name"` tags. Msgpack is usually much more
+// compact than JSON.
+type MsgpackCodec[T any] struct{}
+
+func (MsgpackCodec[T]) Encode(value T) ([]byte, error) {
+	var b []byte
+	err := codec.NewEncoderBytes(&b, msgpack.CodecHandle).Encode(value)
+	return b, err
+}
+
+func (MsgpackCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := msgpack.Decode(b, &v)
+	return v, err
+}
+
+// FixedPoint encodes numbers as integers scaled by 10^Decimals, stored as 8-byte big
+// endian two's complement. Non-negative values can be read in TEAL with btoi. Use it
+// for prices and rates, where a float64 would lose precision on-chain.
+type FixedPoint struct {
+	// Decimals is the number of decimal places that are kept. At most 18.
+	Decimals uint8
+}
+
+func (f FixedPoint) Encode(value float64) ([]byte, error) {
+	if f.Decimals > 18 {
+		return nil, fmt.Errorf("fixed point: %d decimals not supported", f.Decimals)
+	}
+	scaled := math.Round(value * math.Pow10(int(f.Decimals)))
+	if math.IsNaN(scaled) || scaled >= math.MaxInt64 || scaled < math.MinInt64 {
+		return nil, fmt.Errorf("fixed point: %v out of range for %d decimals", value, f.Decimals)
+	}
+	return itob(uint64(int64(scaled))), nil
+}
+
+func (f FixedPoint) Decode(b []byte) (float64, error) {
+	i, ok := btoi(b)
+	if !ok {
+		return 0, fmt.Errorf("fixed point: expected 8 bytes, got %d", len(b))
+	}
+	return float64(int64(i)) / math.Pow10(int(f.Decimals)), nil
+}
+
+// ProtoCodec encodes structs in the protobuf wire format, so that values can be decoded
+// by consumers using protobuf. Fields are mapped with `proto:"<number>"` tags, untagged
+// fields are ignored:
+//
+//	type Match struct {
+//		Id     uint64 `proto:"1"`
+//		Winner string `proto:"2"`
+//		Diff   int32  `proto:"3,zigzag"`
+//	}
+//
+// Supported field types are bool, integers, string and []byte. Integers are encoded as
+// varints; add the zigzag option for signed fields that are often negative (sint32,
+// sint64 in protobuf). Like in proto3, zero values are omitted, and unknown fields are
+// skipped when decoding.
+type ProtoCodec[T any] struct{}
+
+// protoField is a struct field with a proto tag.
+type protoField struct {
+	index  int
+	number uint64
+	zigzag bool
+}
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("proto: truncated value")
+
+func (ProtoCodec[T]) Encode(value T) ([]byte, error) {
+	v := reflect.ValueOf(value)
+	fields, err := protoFields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if fv.IsZero() {
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.Bool:
+			b = appendUvarint(b, f.number<<3|wireVarint)
+			b = append(b, 1)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i := fv.Int()
+			b = appendUvarint(b, f.number<<3|wireVarint)
+			if f.zigzag {
+				b = appendUvarint(b, uint64(i<<1)^uint64(i>>63))
+			} else {
+				b = appendUvarint(b, uint64(i))
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			b = appendUvarint(b, f.number<<3|wireVarint)
+			b = appendUvarint(b, fv.Uint())
+		case reflect.String:
+			b = appendUvarint(b, f.number<<3|wireBytes)
+			b = appendUvarint(b, uint64(fv.Len()))
+			b = append(b, fv.String()...)
+		case reflect.Slice:
+			b = appendUvarint(b, f.number<<3|wireBytes)
+			b = appendUvarint(b, uint64(fv.Len()))
+			b = append(b, fv.Bytes()...)
+		}
+	}
+	return b, nil
+}
+
+func (ProtoCodec[T]) Decode(b []byte) (T, error) {
+	var value T
+	v := reflect.ValueOf(&value).Elem()
+	fields, err := protoFields(v.Type())
+	if err != nil {
+		return value, err
+	}
+	byNumber := make(map[uint64]protoField, len(fields))
+	for _, f := range fields {
+		byNumber[f.number] = f
+	}
+
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return value, errProtoTruncated
+		}
+		b = b[n:]
+		number, wire := tag>>3, tag&7
+
+		var x uint64
+		var payload []byte
+		switch wire {
+		case wireVarint:
+			if x, n = binary.Uvarint(b); n <= 0 {
+				return value, errProtoTruncated
+			}
+			b = b[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wire == wireFixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return value, errProtoTruncated
+			}
+			b = b[size:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return value, errProtoTruncated
+			}
+			payload, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return value, fmt.Errorf("proto: unsupported wire type %d", wire)
+		}
+
+		f, ok := byNumber[number]
+		if !ok {
+			continue
+		}
+		fv := v.Field(f.index)
+		if err = setProtoField(fv, f, wire, x, payload); err != nil {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+// setProtoField assigns a decoded varint or length-delimited payload to a field.
+func setProtoField(fv reflect.Value, f protoField, wire uint64, x uint64, payload []byte) error {
+	expected := uint64(wireVarint)
+	if fv.Kind() == reflect.String || fv.Kind() == reflect.Slice {
+		expected = wireBytes
+	}
+	if wire != expected {
+		return fmt.Errorf("proto: field %d has wire type %d, expected %d", f.number, wire, expected)
+	}
+	switch fv.Kind() {
+	case reflect.Bool:
+		fv.SetBool(x != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := int64(x)
+		if f.zigzag {
+			i = int64(x>>1) ^ -int64(x&1)
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fv.SetUint(x)
+	case reflect.String:
+		fv.SetString(string(payload))
+	case reflect.Slice:
+		fv.SetBytes(append([]byte(nil), payload...))
+	}
+	return nil
+}
+
+// protoFields returns the tagged fields of a struct type.
+func protoFields(t reflect.Type) ([]protoField, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("proto: %v is not a struct", t)
+	}
+	var fields []protoField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("proto")
+		if !ok {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		number, err := strconv.ParseUint(parts[0], 10, 29)
+		if err != nil || number == 0 {
+			return nil, fmt.Errorf("proto: invalid field number %q of %v.%s", parts[0], t, sf.Name)
+		}
+		f := protoField{index: i, number: number}
+		for _, opt := range parts[1:] {
+			if opt != "zigzag" {
+				return nil, fmt.Errorf("proto: unknown option %q of %v.%s", opt, t, sf.Name)
+			}
+			f.zigzag = true
+		}
+		switch sf.Type.Kind() {
+		case reflect.Bool, reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		case reflect.Slice:
+			if sf.Type.Elem().Kind() != reflect.Uint8 {
+				return nil, fmt.Errorf("proto: unsupported type %v of %v.%s", sf.Type, t, sf.Name)
+			}
+		default:
+			return nil, fmt.Errorf("proto: unsupported type %v of %v.%s", sf.Type, t, sf.Name)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// appendUvarint appends the varint encoding of x to b.
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(b, buf[:n]...)
+}
@@ -0,0 +1,167 @@
+package siam
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// ValueCodec transforms values between the form seen by users of a buffer and the form
+// stored on-chain, e.g. by compressing or encrypting them. In contrast to a Codec of a
+// TypedBuffer, a ValueCodec works on []byte values and is transparent to all reads and
+// writes of the buffer.
+type ValueCodec interface {
+	Encode(key string, value []byte) ([]byte, error)
+	Decode(key string, value []byte) ([]byte, error)
+}
+
+// WithValueCodec adds a ValueCodec to the buffer. Codecs are applied in the order they
+// are given when writing, and in reverse order when reading. The expiry of
+// WithOnChainExpiry is always added after all codecs, so that the contract can read it.
+func WithValueCodec(c ValueCodec) BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		ab.codecs = append(ab.codecs, c)
+		return nil
+	}
+}
+
+// decodeValues decodes the given global state with the given codecs. Reserved keys are
+// removed, and if expiry is true, the expiry of values is stripped.
+func decodeValues(state map[string][]byte, codecs []ValueCodec, expiry bool) (map[string][]byte, error) {
+	m := make(map[string][]byte, len(state))
+	for k, v := range state {
+		if isReserved(k) {
+			continue
+		}
+		if expiry {
+			v, _, _ = DecodeExpiry(v)
+		}
+		var err error
+		for i := len(codecs) - 1; i >= 0; i-- {
+			if v, err = codecs[i].Decode(k, v); err != nil {
+				return nil, fmt.Errorf("decoding value of key %q: %w", k, err)
+			}
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// staleValueCodec is implemented by ValueCodecs whose stored values can become
+// outdated, e.g. after a key rotation.
+type staleValueCodec interface {
+	Stale(key string, value []byte) bool
+}
+
+// staleKeys returns the keys of the given global state whose values are outdated
+// according to one of the given codecs, and need to be rewritten.
+func staleKeys(state map[string][]byte, codecs []ValueCodec, expiry bool) map[string]bool {
+	stale := make(map[string]bool)
+	for k, v := range state {
+		if isReserved(k) {
+			continue
+		}
+		if expiry {
+			v, _, _ = DecodeExpiry(v)
+		}
+		for i := len(codecs) - 1; i >= 0; i-- {
+			if s, ok := codecs[i].(staleValueCodec); ok && s.Stale(k, v) {
+				stale[k] = true
+				break
+			}
+			var err error
+			if v, err = codecs[i].Decode(k, v); err != nil {
+				break
+			}
+		}
+	}
+	return stale
+}
+
+// Headers of compressed values. Values whose first byte is neither of them are stored
+// uncompressed.
+const (
+	// DeflateHeader precedes a raw deflate stream.
+	DeflateHeader byte = 0xfd
+	// DictionaryHeader precedes a raw deflate stream that uses the preset dictionary
+	// of the Compressor.
+	DictionaryHeader byte = 0xfc
+)
+
+// Compressor is a ValueCodec that compresses values with deflate. Values are only
+// compressed if this makes them shorter, so incompressible values cost nothing.
+// Uncompressed values that happen to start with a header byte are always compressed,
+// so that they can be told apart.
+//
+// Short values like JSON records barely compress on their own. A preset dictionary
+// containing typical content (field names, common values) helps a lot; consumers
+// need the same dictionary to read the values.
+//
+// Values that start with a header byte, but can't be decompressed, are decoded as
+// they are stored. They were most likely written by a writer without compression, and
+// shouldn't fail reads of all other keys.
+type Compressor struct {
+	dict []byte
+}
+
+// NewCompressor creates a Compressor with the given preset dictionary. The dictionary
+// may be nil.
+func NewCompressor(dict []byte) *Compressor {
+	return &Compressor{dict: dict}
+}
+
+// WithCompression compresses values with a Compressor using the given preset dictionary.
+// See NewCompressor.
+func WithCompression(dict []byte) BufferOption {
+	return WithValueCodec(NewCompressor(dict))
+}
+
+func (c *Compressor) Encode(_ string, value []byte) ([]byte, error) {
+	header := DeflateHeader
+	if len(c.dict) > 0 {
+		header = DictionaryHeader
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(header)
+	w, err := flate.NewWriterDict(&buf, flate.BestCompression, c.dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(value); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(value) && !isCompressionHeader(value) {
+		return value, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Compressor) Decode(_ string, value []byte) ([]byte, error) {
+	if !isCompressionHeader(value) {
+		return value, nil
+	}
+	var dict []byte
+	if value[0] == DictionaryHeader {
+		if len(c.dict) == 0 {
+			return value, nil
+		}
+		dict = c.dict
+	}
+	r := flate.NewReaderDict(bytes.NewReader(value[1:]), dict)
+	defer r.Close()
+	dec, err := io.ReadAll(r)
+	if err != nil {
+		return value, nil
+	}
+	return dec, nil
+}
+
+// isCompressionHeader returns true if the value starts with a header of compressed values.
+func isCompressionHeader(value []byte) bool {
+	return len(value) > 0 && (value[0] == DeflateHeader || value[0] == DictionaryHeader)
+}
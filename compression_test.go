@@ -0,0 +1,91 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+var matchDict = []byte(`{"id":,"team1":"","team2":"","winner":"","map":"","score":"Astralis","Natus Vincere"}`)
+
+func TestCompressor(t *testing.T) {
+	record := []byte(`{"id":1000,"team1":"Astralis","team2":"Natus Vincere","winner":"Astralis","map":"Inferno","score":"16-14"}`)
+	for _, dict := range [][]byte{nil, matchDict} {
+		c := NewCompressor(dict)
+		enc, err := c.Encode("k", record)
+		assert.Nil(t, err)
+		assert.Less(t, len(enc), len(record))
+
+		dec, err := c.Decode("k", enc)
+		assert.Nil(t, err)
+		assert.Equal(t, record, dec)
+	}
+
+	// a dictionary helps short records a lot
+	plain, _ := NewCompressor(nil).Encode("k", record)
+	withDict, _ := NewCompressor(matchDict).Encode("k", record)
+	assert.Less(t, len(withDict), len(plain))
+
+	// values that a dictionary-less compressor can't read are returned as stored
+	dec, err := NewCompressor(nil).Decode("k", withDict)
+	assert.Nil(t, err)
+	assert.Equal(t, withDict, dec)
+}
+
+func TestCompressor_SkipsIncompressible(t *testing.T) {
+	c := NewCompressor(nil)
+	enc, err := c.Encode("k", []byte("OG"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("OG"), enc)
+
+	// values starting with a header byte must be distinguishable
+	enc, _ = c.Encode("k", []byte{DeflateHeader})
+	dec, err := c.Decode("k", enc)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{DeflateHeader}, dec)
+}
+
+func TestAlgorandBuffer_Compression(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithCompression(matchDict), WithOnChainExpiry())
+
+	record := `{"id":1000,"team1":"Astralis","team2":"Natus Vincere","winner":"Astralis","map":"Inferno","score":"16-14","event":"IEM"}`
+	// too large without compression
+	assert.Greater(t, len("m1000")+len(record)+expiryOverhead, MaxPairSize)
+	assert.Nil(t, buffer.PutWithTTL(context.Background(), map[string]string{"m1000": record}, time.Hour))
+
+	data, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"m1000": record}, data)
+
+	// the expiry must stay readable for the contract
+	state, _ := buffer.getGlobalState(context.Background())
+	_, _, ok := DecodeExpiry(state["m1000"])
+	assert.True(t, ok)
+
+	r := NewReader(c, buffer.AppId)
+	r.ValueCodecs = []ValueCodec{NewCompressor(matchDict)}
+	r.OnChainExpiry = true
+	data, err = r.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"m1000": record}, data)
+}
+
+// A value that only looks compressed doesn't fail the read of other keys
+func TestAlgorandBuffer_CompressionInvalidValue(t *testing.T) {
+	key := client.GeneratePrivateKey64()
+	c := client.CreateAlgorandClientMock("", "")
+	plain, _ := NewAlgorandBuffer(c, key)
+	raw := map[string][]byte{"bin": {DeflateHeader, 0xff, 0xff}, "dict": {DictionaryHeader, 1}, "1000": []byte("Astralis")}
+	assert.Nil(t, plain.PutElementsRaw(context.Background(), raw))
+
+	buffer, _ := NewAlgorandBuffer(c, key, WithCompression(nil))
+	data, err := buffer.GetBufferRaw(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, raw, data)
+}
@@ -0,0 +1,609 @@
+package siam
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/algorand/go-algorand-sdk/client/v2/common"
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/types"
+	"gopkg.in/yaml.v3"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// EnvNetwork is the environment variable overriding the network of a config file.
+const EnvNetwork = "SIAM_NETWORK"
+
+// EnvStartupPolicy is the environment variable overriding the startup policy of a
+// config file, "manage" or "existing".
+const EnvStartupPolicy = "SIAM_STARTUP_POLICY"
+
+// Config is the content of a YAML or TOML config file. Load it with LoadConfig. A
+// minimal YAML config:
+//
+//	network: testnet
+//	nodes:
+//	  - url: https://testnet-api.algonode.cloud
+//	    token: ""
+//	    headers:
+//	      X-API-Key: "key:with:colons"
+//	key:
+//	  env: ORACLE_KEY
+//
+// The environment variables of NewAlgorandBufferFromEnv override the file: the node
+// variables replace the first node, and client.EnvPrivateKey, client.EnvKeystore or
+// client.EnvKeyFile replace the key source.
+// EnvNetwork and EnvStartupPolicy override network and startup.
+type Config struct {
+	// Network is the expected network, e.g. "mainnet" or "testnet". If set, the
+	// genesis ID of the nodes must start with it, so that an oracle is never published
+	// to the wrong network.
+	Network string `yaml:"network" toml:"network"`
+
+	// Nodes are used in order. If a node fails, the next one is used, see
+	// client.FailoverClient.
+	Nodes []NodeConfig `yaml:"nodes" toml:"nodes"`
+
+	Key        KeyConfig                  `yaml:"key" toml:"key"`
+	Timeouts   TimeoutConfig              `yaml:"timeouts" toml:"timeouts"`
+	Fees       FeeConfig                  `yaml:"fees" toml:"fees"`
+	Schema     SchemaConfig               `yaml:"schema" toml:"schema"`
+	Startup    string                     `yaml:"startup" toml:"startup"`
+	Namespaces map[string]NamespaceConfig `yaml:"namespaces" toml:"namespaces"`
+
+	// Application is the ID of an application of another account, which the key
+	// writes to as a writer, see WithApplication. If zero, the account's own
+	// application is used.
+	Application uint64 `yaml:"application" toml:"application"`
+
+	// path is the file the config was loaded from, for error messages.
+	path string
+}
+
+// NodeConfig is an algod node. Token may be empty for public nodes that don't require
+// authentication.
+type NodeConfig struct {
+	URL     string            `yaml:"url" toml:"url"`
+	Token   string            `yaml:"token" toml:"token"`
+	Headers map[string]string `yaml:"headers" toml:"headers"`
+}
+
+// KeyConfig is the source of the private key, or the signer holding it. Exactly one
+// of PrivateKey, Env, Mnemonic, File, Keystore, Kmd, Remote and Multisig must be set.
+type KeyConfig struct {
+	// PrivateKey is the base64 encoded private key.
+	PrivateKey string `yaml:"private_key" toml:"private_key"`
+	// Env is the name of an environment variable holding the base64 encoded key.
+	Env string `yaml:"env" toml:"env"`
+	// Mnemonic is a 25-word mnemonic, see MnemonicKey.
+	Mnemonic string `yaml:"mnemonic" toml:"mnemonic"`
+	// File is the path of a KeyFile.
+	File string `yaml:"file" toml:"file"`
+	// Keystore is the path of a Keystore, whose password is read from PasswordFile.
+	Keystore     string `yaml:"keystore" toml:"keystore"`
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+	// Kmd signs with a key of a kmd wallet, see client.KmdSigner.
+	Kmd *KmdConfig `yaml:"kmd" toml:"kmd"`
+	// Remote signs with a remote signing service, see client.RemoteSigner.
+	Remote *RemoteSignerConfig `yaml:"remote" toml:"remote"`
+	// Multisig signs for a multisig account, see client.MultisigSigner.
+	Multisig *MultisigConfig `yaml:"multisig" toml:"multisig"`
+
+	// Account is the address of an account that was rekeyed to the configured key or
+	// signer, see client.RekeyedSigner. Empty if the key signs for its own account.
+	Account string `yaml:"account" toml:"account"`
+}
+
+// KmdConfig is a kmd wallet holding the key of Address. The wallet password is read
+// from PasswordFile.
+type KmdConfig struct {
+	URL          string `yaml:"url" toml:"url"`
+	Token        string `yaml:"token" toml:"token"`
+	Wallet       string `yaml:"wallet" toml:"wallet"`
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+	Address      string `yaml:"address" toml:"address"`
+}
+
+// MultisigConfig is a multisig account with the given version (default 1), threshold
+// and addresses, in order. Signers are the key sources or signers of the keys that
+// are available to the oracle; if they don't reach the threshold, writes return
+// *client.ErrPartiallySigned, or *ErrPartialWrite if they need several transactions.
+type MultisigConfig struct {
+	Version   uint8       `yaml:"version" toml:"version"`
+	Threshold uint8       `yaml:"threshold" toml:"threshold"`
+	Addresses []string    `yaml:"addresses" toml:"addresses"`
+	Signers   []KeyConfig `yaml:"signers" toml:"signers"`
+}
+
+// account returns the multisig account.
+func (m *MultisigConfig) account() (crypto.MultisigAccount, error) {
+	addrs := make([]types.Address, len(m.Addresses))
+	for i, a := range m.Addresses {
+		addr, err := types.DecodeAddress(a)
+		if err != nil {
+			return crypto.MultisigAccount{}, fmt.Errorf("invalid address %q: %s", a, err)
+		}
+		addrs[i] = addr
+	}
+	version := m.Version
+	if version == 0 {
+		version = 1
+	}
+	return crypto.MultisigAccountWithParams(version, m.Threshold, addrs)
+}
+
+// RemoteSignerConfig is a signing service holding the key of Address.
+type RemoteSignerConfig struct {
+	URL     string `yaml:"url" toml:"url"`
+	Token   string `yaml:"token" toml:"token"`
+	Address string `yaml:"address" toml:"address"`
+}
+
+// TimeoutConfig holds timeouts as duration strings like "30s".
+type TimeoutConfig struct {
+	// Request is the timeout of requests to the node, see WithTimeout.
+	Request Duration `yaml:"request" toml:"request"`
+}
+
+// FeeConfig holds transaction fees.
+type FeeConfig struct {
+	// Flat is the fee of every transaction in microAlgos. At least the minimum fee of
+	// 1000. Defaults to client.DefaultFee.
+	Flat uint64 `yaml:"flat" toml:"flat"`
+}
+
+// SchemaConfig is the expected global schema of the application. The schema is fixed
+// by the contract, so these fields only assert it.
+type SchemaConfig struct {
+	GlobalBytes *uint64 `yaml:"global_bytes" toml:"global_bytes"`
+	GlobalInts  *uint64 `yaml:"global_ints" toml:"global_ints"`
+}
+
+// NamespaceConfig configures a Namespace.
+type NamespaceConfig struct {
+	Prefix string `yaml:"prefix" toml:"prefix"`
+	// Quota is the slot quota, see Namespace.SetQuota. 0 means no quota.
+	Quota int `yaml:"quota" toml:"quota"`
+}
+
+// Duration is a time.Duration that is written as string, like "30s" or "1m".
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// LoadConfig reads a config file, applies the environment overrides and validates
+// the result. The format is determined by the extension: .yaml, .yml or .toml.
+// Unknown fields are rejected, so that typos don't go unnoticed. If the config is
+// invalid, a *ConfigError listing all problems is returned.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{path: path}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err = dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("config %s: %s", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %s", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, k := range undecoded {
+				keys[i] = k.String()
+			}
+			return nil, fmt.Errorf("config %s: unknown fields %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return nil, fmt.Errorf("config %s: unknown format, use .yaml, .yml or .toml", path)
+	}
+	cfg.applyEnv()
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv applies the overrides of the environment variables.
+func (c *Config) applyEnv() {
+	node := func() *NodeConfig {
+		if len(c.Nodes) == 0 {
+			c.Nodes = append(c.Nodes, NodeConfig{})
+		}
+		return &c.Nodes[0]
+	}
+	if v, ok := os.LookupEnv(client.EnvURLNode); ok {
+		node().URL = v
+	}
+	if v, ok := os.LookupEnv(client.EnvAlgodToken); ok {
+		node().Token = v
+	}
+	if v, ok := os.LookupEnv(client.EnvHeadersNode); ok {
+		n := node()
+		n.Headers = make(map[string]string)
+		for _, h := range client.ParseHeaders(v) {
+			n.Headers[h.Key] = h.Value
+		}
+	}
+	if v, ok := os.LookupEnv(client.EnvPrivateKey); ok {
+		c.Key = KeyConfig{PrivateKey: v}
+	}
+	if v, ok := os.LookupEnv(client.EnvKeystore); ok {
+		c.Key = KeyConfig{Keystore: v, PasswordFile: os.Getenv(client.EnvKeystorePasswordFile)}
+	}
+	if v, ok := os.LookupEnv(client.EnvKeyFile); ok {
+		c.Key = KeyConfig{File: v}
+	}
+	if v, ok := os.LookupEnv(EnvNetwork); ok {
+		c.Network = v
+	}
+	if v, ok := os.LookupEnv(EnvStartupPolicy); ok {
+		c.Startup = v
+	}
+}
+
+// Validate checks the config without connecting to a node. It returns a *ConfigError
+// listing all problems.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(c.Nodes) == 0 {
+		add("nodes: at least one node is required")
+	}
+	for i, n := range c.Nodes {
+		if n.URL == "" {
+			add("nodes[%d].url: is empty", i)
+		}
+	}
+
+	if err := c.checkKey(); err != nil {
+		add("key: %s", err)
+	}
+	if c.Timeouts.Request < 0 {
+		add("timeouts.request: must be positive")
+	}
+	if c.Fees.Flat != 0 && c.Fees.Flat < client.DefaultFee {
+		add("fees.flat: %d is below the minimum fee of %d microAlgos", c.Fees.Flat, client.DefaultFee)
+	}
+	if s := c.Schema.GlobalBytes; s != nil && *s != client.GlobalBytes {
+		add("schema.global_bytes: the contract requires %d, got %d", client.GlobalBytes, *s)
+	}
+	if s := c.Schema.GlobalInts; s != nil && *s != client.GlobalInts {
+		add("schema.global_ints: the contract requires %d, got %d", client.GlobalInts, *s)
+	}
+	if _, err := c.startupPolicy(); err != nil {
+		add("startup: %s", err)
+	}
+
+	names := make([]string, 0, len(c.Namespaces))
+	for name := range c.Namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		ns := c.Namespaces[name]
+		switch {
+		case ns.Prefix == "":
+			add("namespaces.%s.prefix: is empty", name)
+		case isReserved(ns.Prefix):
+			add("namespaces.%s.prefix: %q uses the reserved prefix %q", name, ns.Prefix, ReservedPrefix)
+		}
+		if ns.Quota < 0 || ns.Quota > client.GlobalBytes {
+			add("namespaces.%s.quota: must be between 0 and %d", name, client.GlobalBytes)
+		}
+		for _, other := range names[i+1:] {
+			p := c.Namespaces[other].Prefix
+			if ns.Prefix != "" && p != "" && (strings.HasPrefix(ns.Prefix, p) || strings.HasPrefix(p, ns.Prefix)) {
+				add("namespaces.%s.prefix: overlaps with namespace %s", name, other)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Path: c.path, Problems: problems}
+	}
+	return nil
+}
+
+// KeySource returns the configured key source. Kmd wallets and remote signers don't
+// provide a key, use Signer for them.
+func (c *Config) KeySource() (KeySource, error) {
+	k := c.Key
+	switch {
+	case k.sources() > 1:
+		return nil, fmt.Errorf("set only one of %s", keySources)
+	case k.PrivateKey != "":
+		return Base64Key(k.PrivateKey), nil
+	case k.Env != "":
+		v := os.Getenv(k.Env)
+		if v == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", k.Env)
+		}
+		return Base64Key(v), nil
+	case k.Mnemonic != "":
+		return MnemonicKey(k.Mnemonic), nil
+	case k.File != "":
+		return KeyFile(k.File), nil
+	case k.Keystore != "":
+		if k.PasswordFile == "" {
+			return nil, fmt.Errorf("keystore requires password_file")
+		}
+		password, err := ReadPasswordFile(k.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		return Keystore{Path: k.Keystore, Password: password}, nil
+	case k.Kmd != nil:
+		return nil, fmt.Errorf("the key is held by the kmd wallet %q", k.Kmd.Wallet)
+	case k.Remote != nil:
+		return nil, fmt.Errorf("the key is held by the signing service %s", k.Remote.URL)
+	case k.Multisig != nil:
+		return nil, fmt.Errorf("the account is a multisig account")
+	}
+	return nil, fmt.Errorf("no key source, set one of %s", keySources)
+}
+
+// keySources lists the fields of KeyConfig, for error messages.
+const keySources = "private_key, env, mnemonic, file, keystore, kmd, remote and multisig"
+
+// sources returns the number of fields that are set.
+func (k KeyConfig) sources() int {
+	n := 0
+	for _, set := range []bool{k.PrivateKey != "", k.Env != "", k.Mnemonic != "", k.File != "",
+		k.Keystore != "", k.Kmd != nil, k.Remote != nil, k.Multisig != nil} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// Signer returns the signer of the configured kmd wallet, signing service or
+// multisig account, or of the key source. If Account is set, the signer signs for
+// that rekeyed account. Kmd signers connect to the wallet to check that it holds the
+// key.
+func (c *Config) Signer() (client.Signer, error) {
+	s, err := c.signer()
+	if err != nil || c.Key.Account == "" {
+		return s, err
+	}
+	addr, _ := types.DecodeAddress(c.Key.Account)
+	return client.NewRekeyedSigner(addr, s), nil
+}
+
+// signer returns the signer of the key section, ignoring Account.
+func (c *Config) signer() (client.Signer, error) {
+	if err := c.checkKey(); err != nil {
+		return nil, err
+	}
+	k := c.Key
+	switch {
+	case k.Multisig != nil:
+		ma, _ := k.Multisig.account()
+		signers := make([]client.Signer, len(k.Multisig.Signers))
+		for i, sub := range k.Multisig.Signers {
+			s, err := (&Config{Key: sub}).Signer()
+			if err != nil {
+				return nil, fmt.Errorf("multisig.signers[%d]: %s", i, err)
+			}
+			signers[i] = s
+		}
+		return client.NewMultisigSigner(ma, signers...)
+	case k.Remote != nil:
+		return client.NewRemoteSigner(k.Remote.URL, k.Remote.Token, k.Remote.Address)
+	case k.Kmd != nil:
+		password, err := ReadPasswordFile(k.Kmd.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		return client.NewKmdSigner(k.Kmd.URL, k.Kmd.Token, k.Kmd.Wallet, string(password), k.Kmd.Address)
+	}
+	key, _ := c.KeySource()
+	sk, _ := key.PrivateKey()
+	acc, err := crypto.AccountFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewAccountSigner(acc), nil
+}
+
+// checkKey checks the key section, without connecting to a signer.
+func (c *Config) checkKey() error {
+	k := c.Key
+	if k.Account != "" {
+		if _, err := types.DecodeAddress(k.Account); err != nil {
+			return fmt.Errorf("invalid account %q: %s", k.Account, err)
+		}
+	}
+	var url, address string
+	switch {
+	case k.sources() != 1 || (k.Kmd == nil && k.Remote == nil && k.Multisig == nil):
+		key, err := c.KeySource()
+		if err != nil {
+			return err
+		}
+		_, err = key.PrivateKey()
+		return err
+	case k.Multisig != nil:
+		if _, err := k.Multisig.account(); err != nil {
+			return fmt.Errorf("multisig: %s", err)
+		}
+		if len(k.Multisig.Signers) == 0 {
+			return fmt.Errorf("multisig.signers: at least one signer is required")
+		}
+		for i, sub := range k.Multisig.Signers {
+			if sub.Multisig != nil {
+				return fmt.Errorf("multisig.signers[%d]: multisig accounts can't be nested", i)
+			}
+			if err := (&Config{Key: sub}).checkKey(); err != nil {
+				return fmt.Errorf("multisig.signers[%d]: %s", i, err)
+			}
+		}
+		return nil
+	case k.Kmd != nil:
+		if k.Kmd.Wallet == "" {
+			return fmt.Errorf("kmd.wallet is empty")
+		}
+		if k.Kmd.PasswordFile == "" {
+			return fmt.Errorf("kmd requires password_file")
+		}
+		url, address = k.Kmd.URL, k.Kmd.Address
+	default:
+		url, address = k.Remote.URL, k.Remote.Address
+	}
+	if url == "" {
+		return fmt.Errorf("the url of the signer is empty")
+	}
+	if _, err := types.DecodeAddress(address); err != nil {
+		return fmt.Errorf("invalid signer address %q: %s", address, err)
+	}
+	return nil
+}
+
+// startupPolicy returns the StartupPolicy of the config.
+func (c *Config) startupPolicy() (StartupPolicy, error) {
+	switch c.Startup {
+	case "", "manage":
+		return StartupManage, nil
+	case "existing":
+		return StartupExisting, nil
+	}
+	return 0, fmt.Errorf("unknown policy %q, use manage or existing", c.Startup)
+}
+
+// Client creates the client of the configured nodes. With several nodes, a
+// client.FailoverClient is returned.
+func (c *Config) Client() (client.AlgorandClient, error) {
+	nodes := make([]client.AlgorandClient, len(c.Nodes))
+	for i, n := range c.Nodes {
+		keys := make([]string, 0, len(n.Headers))
+		for k := range n.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		headers := make([]*common.Header, len(keys))
+		for j, k := range keys {
+			headers[j] = &common.Header{Key: k, Value: n.Headers[k]}
+		}
+		w, err := client.NewClientWithHeaders(n.URL, n.Token, headers)
+		if err != nil {
+			return nil, fmt.Errorf("nodes[%d]: %s", i, err)
+		}
+		w.Fee = c.Fees.Flat
+		nodes[i] = w
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return client.NewFailoverClient(nodes...)
+}
+
+// Namespace returns the view of the buffer for the configured namespace with given
+// name.
+func (c *Config) Namespace(ab *AlgorandBuffer, name string) (*Namespace, error) {
+	ns, ok := c.Namespaces[name]
+	if !ok {
+		return nil, fmt.Errorf("namespace %q is not configured", name)
+	}
+	return ab.Namespace(ns.Prefix)
+}
+
+// checkNetwork verifies that the node is on the configured network.
+func (c *Config) checkNetwork(algod client.AlgorandClient) error {
+	if c.Network == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), client.AlgorandDefaultTimeout)
+	params, err := algod.SuggestedParams(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if params.GenesisID != c.Network && !strings.HasPrefix(params.GenesisID, c.Network+"-") {
+		return fmt.Errorf("node is on network %q, but the config expects %q", params.GenesisID, c.Network)
+	}
+	return nil
+}
+
+// NewAlgorandBufferFromConfig creates an AlgorandBuffer from a YAML or TOML config
+// file, see LoadConfig and Config. Additional options are applied after the options
+// of the config.
+func NewAlgorandBufferFromConfig(path string, opts ...BufferOption) (*AlgorandBuffer, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	algod, err := cfg.Client()
+	if err != nil {
+		return nil, err
+	}
+	return NewAlgorandBufferWithConfig(algod, cfg, opts...)
+}
+
+// NewAlgorandBufferWithConfig creates an AlgorandBuffer from a loaded config, using
+// the given client instead of the configured nodes.
+func NewAlgorandBufferWithConfig(c client.AlgorandClient, cfg *Config, opts ...BufferOption) (*AlgorandBuffer, error) {
+	if err := cfg.checkKey(); err != nil {
+		return nil, err
+	}
+	if err := cfg.checkNetwork(c); err != nil {
+		return nil, err
+	}
+	policy, err := cfg.startupPolicy()
+	if err != nil {
+		return nil, err
+	}
+	all := []BufferOption{WithStartupPolicy(policy)}
+	if cfg.Timeouts.Request > 0 {
+		all = append(all, WithTimeout(time.Duration(cfg.Timeouts.Request)))
+	}
+	if cfg.Application != 0 {
+		all = append(all, WithApplication(cfg.Application))
+	}
+	signer, err := cfg.Signer()
+	if err != nil {
+		return nil, err
+	}
+	buffer, err := NewAlgorandBufferWithSigner(c, signer, append(all, opts...)...)
+	if err != nil {
+		return buffer, err
+	}
+	for _, ns := range cfg.Namespaces {
+		view, err := buffer.Namespace(ns.Prefix)
+		if err != nil {
+			return buffer, err
+		}
+		view.SetQuota(ns.Quota)
+	}
+	return buffer, nil
+}
@@ -0,0 +1,233 @@
+//go:build unit
+
+package siam
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func clearEnv(t *testing.T) {
+	for _, k := range []string{client.EnvURLNode, client.EnvAlgodToken, client.EnvHeadersNode, client.EnvPrivateKey,
+		client.EnvKeyFile, client.EnvKeystore, client.EnvKeystorePasswordFile, EnvNetwork, EnvStartupPolicy} {
+		if v, ok := os.LookupEnv(k); ok {
+			os.Unsetenv(k)
+			t.Cleanup(func() { os.Setenv(k, v) })
+		}
+	}
+}
+
+func TestLoadConfig_YAML(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("ORACLE_KEY", client.GeneratePrivateKey64())
+	path := writeConfig(t, "siam.yaml", `
+network: testnet
+nodes:
+  - url: https://node-a.example.com
+    token: abc
+  - url: https://node-b.example.com
+    headers:
+      X-API-Key: "key:with:colons"
+key:
+  env: ORACLE_KEY
+timeouts:
+  request: 5s
+fees:
+  flat: 2000
+schema:
+  global_bytes: 64
+startup: existing
+namespaces:
+  cs:
+    prefix: "cs:"
+    quota: 10
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "testnet", cfg.Network)
+	assert.Len(t, cfg.Nodes, 2)
+	assert.Equal(t, "key:with:colons", cfg.Nodes[1].Headers["X-API-Key"])
+	assert.Equal(t, Duration(5*time.Second), cfg.Timeouts.Request)
+	assert.EqualValues(t, 2000, cfg.Fees.Flat)
+	assert.Equal(t, NamespaceConfig{Prefix: "cs:", Quota: 10}, cfg.Namespaces["cs"])
+
+	c, err := cfg.Client()
+	assert.Nil(t, err)
+	assert.IsType(t, &client.FailoverClient{}, c)
+}
+
+func TestLoadConfig_TOML(t *testing.T) {
+	clearEnv(t)
+	key := client.GeneratePrivateKey64()
+	path := writeConfig(t, "siam.toml", `
+network = "mainnet"
+
+[[nodes]]
+url = "https://node.example.com"
+token = "abc"
+
+[key]
+private_key = "`+key+`"
+
+[timeouts]
+request = "1m"
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, Duration(time.Minute), cfg.Timeouts.Request)
+	got, _ := cfg.KeySource()
+	assert.Equal(t, Base64Key(key), got)
+
+	c, err := cfg.Client()
+	assert.Nil(t, err)
+	assert.IsType(t, &client.AlgorandClientWrapper{}, c)
+}
+
+func TestLoadConfig_EnvOverrides(t *testing.T) {
+	clearEnv(t)
+	key := client.GeneratePrivateKey64()
+	path := writeConfig(t, "siam.yml", `
+nodes:
+  - url: https://node.example.com
+    token: abc
+key:
+  env: UNSET_VARIABLE
+`)
+	t.Setenv(client.EnvURLNode, "https://other.example.com")
+	t.Setenv(client.EnvHeadersNode, "X-API-Key:a:b&X-Other: c")
+	t.Setenv(client.EnvPrivateKey, key)
+	t.Setenv(EnvNetwork, "betanet")
+
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://other.example.com", cfg.Nodes[0].URL)
+	assert.Equal(t, "abc", cfg.Nodes[0].Token)
+	assert.Equal(t, map[string]string{"X-API-Key": "a:b", "X-Other": "c"}, cfg.Nodes[0].Headers)
+	assert.Equal(t, "betanet", cfg.Network)
+	got, _ := cfg.KeySource()
+	assert.Equal(t, Base64Key(key), got)
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, "siam.yaml", `
+nodes:
+  - url: ""
+key:
+  private_key: "bm90IGEga2V5"
+fees:
+  flat: 10
+schema:
+  global_ints: 3
+startup: sometimes
+namespaces:
+  a:
+    prefix: "cs"
+  b:
+    prefix: "cs:"
+    quota: 100
+  c:
+    prefix: "_siam_x"
+`)
+	_, err := LoadConfig(path)
+	var cfgErr *ConfigError
+	assert.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, path, cfgErr.Path)
+	assert.Equal(t, []string{
+		"nodes[0].url: is empty",
+		"key: private key must have 64 bytes, got 9",
+		"fees.flat: 10 is below the minimum fee of 1000 microAlgos",
+		"schema.global_ints: the contract requires 0, got 3",
+		`startup: unknown policy "sometimes", use manage or existing`,
+		"namespaces.a.prefix: overlaps with namespace b",
+		"namespaces.b.quota: must be between 0 and 64",
+		`namespaces.c.prefix: "_siam_x" uses the reserved prefix "_siam_"`,
+	}, cfgErr.Problems)
+
+	// unknown fields are rejected
+	_, err = LoadConfig(writeConfig(t, "siam.yaml", "nodes: []\ntimeout: 5s\n"))
+	assert.Contains(t, err.Error(), "field timeout not found")
+	_, err = LoadConfig(writeConfig(t, "siam.toml", "timeout = \"5s\"\n"))
+	assert.Contains(t, err.Error(), "unknown fields timeout")
+	_, err = LoadConfig(writeConfig(t, "siam.json", "{}"))
+	assert.Contains(t, err.Error(), "unknown format")
+}
+
+func TestNewAlgorandBufferWithConfig(t *testing.T) {
+	cfg := &Config{
+		Network:    "testnet",
+		Key:        KeyConfig{PrivateKey: client.GeneratePrivateKey64()},
+		Timeouts:   TimeoutConfig{Request: Duration(time.Second)},
+		Namespaces: map[string]NamespaceConfig{"cs": {Prefix: "cs:", Quota: 3}},
+	}
+	c := client.CreateAlgorandClientMock("", "")
+	c.Params.GenesisID = "mainnet-v1.0"
+	_, err := NewAlgorandBufferWithConfig(c, cfg)
+	assert.EqualError(t, err, `node is on network "mainnet-v1.0", but the config expects "testnet"`)
+
+	c.Params.GenesisID = "testnet-v1.0"
+	buffer, err := NewAlgorandBufferWithConfig(c, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, buffer.timeoutLength)
+	ns, err := cfg.Namespace(buffer, "cs")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, ns.Quota())
+	_, err = cfg.Namespace(buffer, "unknown")
+	assert.NotNil(t, err)
+}
+
+func TestStartupExisting(t *testing.T) {
+	cfg := &Config{Key: KeyConfig{PrivateKey: client.GeneratePrivateKey64()}, Startup: "existing"}
+	c := client.CreateAlgorandClientMock("", "")
+	_, err := NewAlgorandBufferWithConfig(c, cfg)
+	assert.IsType(t, &NoApplication{}, err)
+	assert.Empty(t, c.Account.CreatedApps)
+
+	c.CreateDummyApps(5, 6)
+	_, err = NewAlgorandBufferWithConfig(c, cfg)
+	assert.IsType(t, &TooManyApplications{}, err)
+	assert.Len(t, c.Account.CreatedApps, 2)
+
+	c.Account.CreatedApps = c.Account.CreatedApps[1:]
+	buffer, err := NewAlgorandBufferWithConfig(c, cfg)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 6, buffer.AppId)
+}
+
+func TestConfigSigner(t *testing.T) {
+	clearEnv(t)
+	acc := crypto.GenerateAccount()
+	srv := httptest.NewServer(client.NewSignerHandler(client.NewAccountSigner(acc), client.SignerPolicy{}, "secret"))
+	defer srv.Close()
+
+	cfg := &Config{Key: KeyConfig{Remote: &RemoteSignerConfig{URL: srv.URL, Token: "secret", Address: acc.Address.String()}}}
+	buffer, err := NewAlgorandBufferWithConfig(client.CreateAlgorandClientMock("", ""), cfg)
+	assert.Nil(t, err)
+	assert.IsType(t, &client.RemoteSigner{}, buffer.Signer)
+	assert.Equal(t, acc.Address, buffer.Signer.Address())
+	_, err = cfg.KeySource()
+	assert.EqualError(t, err, "the key is held by the signing service "+srv.URL)
+
+	cfg.Key.Remote.Address = "nope"
+	cfg.Key.Kmd = &KmdConfig{URL: "http://localhost:7833", Wallet: "oracle"}
+	problems := cfg.Validate().(*ConfigError).Problems
+	assert.Contains(t, problems, "key: set only one of private_key, env, mnemonic, file, keystore, kmd, remote and multisig")
+
+	cfg.Key.Remote = nil
+	problems = cfg.Validate().(*ConfigError).Problems
+	assert.Contains(t, problems, "key: kmd requires password_file")
+}
@@ -0,0 +1,148 @@
+package siam
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+)
+
+// EncryptionHeader is the first byte of encrypted values. It is followed by the key ID,
+// a 12 byte nonce and the AES-GCM ciphertext.
+const EncryptionHeader byte = 0xfb
+
+// EncryptionOverhead is the number of bytes that encryption adds to a value: header,
+// key ID, nonce and authentication tag. It counts towards the 128 byte pair limit.
+const EncryptionOverhead = 2 + 12 + 16
+
+// Encryptor is a ValueCodec that encrypts values with AES-GCM, so that only holders of
+// the shared key can read them. Every key is identified by a one-byte ID, which is
+// stored in front of the ciphertext. The kv key is authenticated as additional data,
+// so values can't be moved to a different key.
+//
+// To rotate the key, add the new key with AddKey, make it active with SetActiveKey
+// and call AlgorandBuffer.Reencrypt. Keep the old key until all values have been
+// re-encrypted.
+type Encryptor struct {
+	mu     sync.RWMutex
+	keys   map[byte]cipher.AEAD
+	active byte
+}
+
+// NewEncryptor creates an Encryptor that encrypts with the given key. The key must have
+// 16, 24 or 32 bytes, selecting AES-128, AES-192 or AES-256.
+func NewEncryptor(id byte, key []byte) (*Encryptor, error) {
+	e := &Encryptor{keys: make(map[byte]cipher.AEAD)}
+	if err := e.AddKey(id, key); err != nil {
+		return nil, err
+	}
+	e.active = id
+	return e, nil
+}
+
+// WithEncryption encrypts values with the given Encryptor. If values are also
+// compressed, add this option after WithCompression, since ciphertexts don't compress.
+func WithEncryption(e *Encryptor) BufferOption {
+	return WithValueCodec(e)
+}
+
+// AddKey adds a key that can be used to decrypt values, and to encrypt them once it is
+// activated with SetActiveKey. IDs can't be reused while their key exists, since values
+// encrypted with it would become unreadable; remove the old key first.
+func (e *Encryptor) AddKey(id byte, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.keys[id]; ok {
+		return fmt.Errorf("key %d exists already", id)
+	}
+	e.keys[id] = aead
+	return nil
+}
+
+// RemoveKey removes a key. Values encrypted with it can't be read anymore. The active
+// key can't be removed.
+func (e *Encryptor) RemoveKey(id byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if id == e.active {
+		return fmt.Errorf("key %d is active", id)
+	}
+	delete(e.keys, id)
+	return nil
+}
+
+// SetActiveKey selects the key that is used to encrypt new values.
+func (e *Encryptor) SetActiveKey(id byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.keys[id]; !ok {
+		return fmt.Errorf("unknown key %d", id)
+	}
+	e.active = id
+	return nil
+}
+
+// ActiveKey returns the ID of the key that is used to encrypt new values.
+func (e *Encryptor) ActiveKey() byte {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.active
+}
+
+func (e *Encryptor) Encode(key string, value []byte) ([]byte, error) {
+	e.mu.RLock()
+	id, aead := e.active, e.keys[e.active]
+	e.mu.RUnlock()
+
+	b := make([]byte, 2+aead.NonceSize(), EncryptionOverhead+len(value))
+	b[0], b[1] = EncryptionHeader, id
+	if _, err := rand.Read(b[2:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(b, b[2:], value, []byte(key)), nil
+}
+
+// Decode decrypts the value. Values without the EncryptionHeader are returned as they are.
+func (e *Encryptor) Decode(key string, value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != EncryptionHeader {
+		return value, nil
+	}
+	if len(value) < EncryptionOverhead {
+		return nil, fmt.Errorf("encrypted value too short")
+	}
+	e.mu.RLock()
+	aead, ok := e.keys[value[1]]
+	e.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("value was encrypted with unknown key %d", value[1])
+	}
+	nonce := value[2 : 2+aead.NonceSize()]
+	return aead.Open(nil, nonce, value[2+aead.NonceSize():], []byte(key))
+}
+
+// Stale returns true if the value is not encrypted, or encrypted with a key that is not
+// active anymore.
+func (e *Encryptor) Stale(_ string, value []byte) bool {
+	return len(value) < 2 || value[0] != EncryptionHeader || value[1] != e.ActiveKey()
+}
+
+// Reencrypt rewrites all values that are stored in an outdated form, e.g. encrypted
+// with a key that is no longer active. Values are rewritten through AchieveDesiredState,
+// so the expiry of keys written with PutWithTTL is not preserved.
+func (ab *AlgorandBuffer) Reencrypt(ctx context.Context) error {
+	data, err := ab.GetBuffer(ctx)
+	if err != nil {
+		return err
+	}
+	return ab.AchieveDesiredState(ctx, data)
+}
@@ -0,0 +1,95 @@
+//go:build unit
+
+package siam
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{1}, 32)
+	testKey2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestEncryptor(t *testing.T) {
+	e, err := NewEncryptor(1, testKey1)
+	assert.Nil(t, err)
+
+	enc, err := e.Encode("1000", []byte("Astralis"))
+	assert.Nil(t, err)
+	assert.Len(t, enc, len("Astralis")+EncryptionOverhead)
+	assert.Equal(t, []byte{EncryptionHeader, 1}, enc[:2])
+
+	dec, err := e.Decode("1000", enc)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Astralis"), dec)
+
+	// values are bound to their key
+	_, err = e.Decode("1001", enc)
+	assert.NotNil(t, err)
+
+	other, _ := NewEncryptor(2, testKey2)
+	_, err = other.Decode("1000", enc)
+	assert.NotNil(t, err)
+
+	_, err = NewEncryptor(1, []byte("short"))
+	assert.NotNil(t, err)
+	assert.NotNil(t, e.RemoveKey(1))
+}
+
+func TestAlgorandBuffer_EncryptionOverhead(t *testing.T) {
+	e, _ := NewEncryptor(1, testKey1)
+	buffer, _ := NewAlgorandBuffer(client.CreateAlgorandClientMock("", ""), client.GeneratePrivateKey64(), WithEncryption(e))
+
+	fits := strings.Repeat("x", MaxPairSize-EncryptionOverhead-1)
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"k": fits}))
+	err := buffer.PutElements(context.Background(), map[string]string{"k": fits + "x"})
+	assert.IsType(t, &ErrPairTooLarge{}, err)
+}
+
+func TestAlgorandBuffer_Reencrypt(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	e, _ := NewEncryptor(1, testKey1)
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithEncryption(e))
+	data := map[string]string{"1000": "Astralis", "1001": "OG"}
+	assert.Nil(t, buffer.PutElements(context.Background(), data))
+
+	// partners without the key only see ciphertexts
+	r := NewReader(c, buffer.AppId)
+	raw, _ := r.GetBuffer(context.Background())
+	assert.NotEqual(t, data, raw)
+
+	partner, _ := NewEncryptor(1, testKey1)
+	r.ValueCodecs = []ValueCodec{partner}
+	raw, err := r.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, data, raw)
+
+	// rotate
+	assert.EqualError(t, e.AddKey(1, testKey2), "key 1 exists already")
+	assert.Nil(t, e.AddKey(2, testKey2))
+	assert.Nil(t, e.SetActiveKey(2))
+	p, err := buffer.Plan(context.Background(), data)
+	assert.Nil(t, err)
+	assert.Equal(t, data, p.Puts)
+	assert.Nil(t, buffer.Reencrypt(context.Background()))
+
+	state, _ := buffer.getGlobalState(context.Background())
+	for _, v := range state {
+		assert.Equal(t, byte(2), v[1])
+	}
+	assert.Nil(t, e.RemoveKey(1))
+	got, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+
+	// nothing left to rotate
+	p, _ = buffer.Plan(context.Background(), data)
+	assert.True(t, p.Empty())
+}
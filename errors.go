@@ -1,30 +1,222 @@
 package siam
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
 
-	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/types"
+
+	"github.com/m2q/algo-siam/client"
 )
 
 // NoApplication is returned upon creation of an Algorand buffer for an account
 // that owns no application.
 type NoApplication struct {
-	Account crypto.Account
+	Address types.Address
 }
 
 func (e *NoApplication) Error() string {
-	return fmt.Sprintf("no application registered for given account {%s}", e.Account.Address)
+	return fmt.Sprintf("no application registered for given account {%s}", e.Address)
 }
 
 // TooManyApplications is returned upon creation of an Algorand buffer for an
 // account that has more than 1 application registered.
 type TooManyApplications struct {
-	Account crypto.Account
+	Address types.Address
 	Apps    []models.Application
 }
 
 func (e *TooManyApplications) Error() string {
-	return fmt.Sprintf("given account owns more than one application {%s}", e.Account.Address)
+	return fmt.Sprintf("given account owns more than one application {%s}", e.Address)
+}
+
+// ErrShardedAccount is returned upon creation of an Algorand buffer for an account
+// that owns the directory of a ShardedBuffer. Managing the applications of such an
+// account would delete its shards, so it must be used with a ShardedBuffer.
+type ErrShardedAccount struct {
+	Address     types.Address
+	DirectoryId uint64
+}
+
+func (e *ErrShardedAccount) Error() string {
+	return fmt.Sprintf("account {%s} owns the shard directory %d, use a ShardedBuffer", e.Address, e.DirectoryId)
+}
+
+// ErrContractUpgrade is returned upon creation of an Algorand buffer for an application
+// whose approval program isn't the client.ApproveTeal of this version. The buffer would
+// send calls the deployed contract doesn't know, like sweeps or writes of writers.
+type ErrContractUpgrade struct {
+	AppId uint64
+}
+
+func (e *ErrContractUpgrade) Error() string {
+	return fmt.Sprintf("application %d doesn't run contract version %d: contract upgrade required", e.AppId, client.ContractVersion)
+}
+
+// ErrAuthAddrMismatch is returned upon creation of an Algorand buffer whose signer
+// doesn't sign with the key that is authorized for the account on-chain, e.g. because
+// the account was rekeyed by another process.
+type ErrAuthAddrMismatch struct {
+	Address types.Address
+	// AuthAddr is the on-chain auth address, empty if the account isn't rekeyed.
+	AuthAddr string
+	// Signer is the address of the key the signer signs with.
+	Signer types.Address
+}
+
+func (e *ErrAuthAddrMismatch) Error() string {
+	if e.AuthAddr == "" {
+		return fmt.Sprintf("account %s isn't rekeyed, but the signer signs with %s", e.Address, e.Signer)
+	}
+	return fmt.Sprintf("account %s is rekeyed to %s, but the signer signs with %s", e.Address, e.AuthAddr, e.Signer)
+}
+
+// ErrPartialWrite is returned if the signer could only partially sign a write that
+// needs several transactions. Partially signed transactions are completed and
+// submitted one at a time, which would apply only a part of the write, so nothing is
+// returned for offline signing. Write fewer keys at once.
+type ErrPartialWrite struct {
+	Transactions int
+}
+
+func (e *ErrPartialWrite) Error() string {
+	return fmt.Sprintf("the write needs %d transactions, but partially signed writes must fit into one", e.Transactions)
+}
+
+// ErrNotWriter is returned upon creation of an Algorand buffer for the application of
+// another account, if the signer's account isn't on the writer allowlist of the
+// application.
+type ErrNotWriter struct {
+	Address types.Address
+	AppId   uint64
+}
+
+func (e *ErrNotWriter) Error() string {
+	return fmt.Sprintf("account %s is not a writer of application %d", e.Address, e.AppId)
+}
+
+// ErrWriterPrefix is returned when a writer writes or deletes a key outside the prefix
+// it is restricted to.
+type ErrWriterPrefix struct {
+	Key    string
+	Prefix string
+}
+
+func (e *ErrWriterPrefix) Error() string {
+	return fmt.Sprintf("key %q doesn't start with the writer prefix %q", e.Key, e.Prefix)
+}
+
+// ErrNotCreator is returned when a writer tries to manage the writers of an application.
+// Only the creator of the application can add or remove writers.
+var ErrNotCreator = errors.New("only the creator of the application can manage writers")
+
+// ErrWriterChecksum is returned if writers restricted to a prefix are combined with a
+// checksum. They can't update the ChecksumKey, so readers would fail with ErrTornRead
+// after their writes.
+var ErrWriterChecksum = errors.New("writers restricted to a prefix can't update the checksum")
+
+// ErrMetadataDisabled is returned by Heartbeat if the buffer doesn't maintain the
+// reserved metadata keys.
+var ErrMetadataDisabled = errors.New("metadata is not enabled, see WithMetadata")
+
+// ErrStateChanged is returned when a Plan is applied, but the on-chain state changed
+// since the plan was made.
+var ErrStateChanged = errors.New("on-chain state changed since the plan was made")
+
+// ErrStaleChecksum is returned by SubmitSigned if the checksum written by the signed
+// transactions doesn't match the state they would produce, because the state changed
+// since they were signed. Such transactions must be discarded.
+var ErrStaleChecksum = errors.New("the signed checksum doesn't match the current state")
+
+// ErrCapacityExceeded is returned when a write would create more keys than the
+// application's global state can hold. No data is written in this case.
+type ErrCapacityExceeded struct {
+	// Keys are the new keys that don't fit into the remaining free slots.
+	Keys []string
+	// Free is the number of free slots at the time of the check.
+	Free int
+}
+
+func (e *ErrCapacityExceeded) Error() string {
+	return fmt.Sprintf("application storage exceeded: %d free slots, %d overflowing keys %v", e.Free, len(e.Keys), e.Keys)
+}
+
+// ErrReservedKey is returned when a key with the ReservedPrefix is written or deleted.
+// These keys are maintained by the buffer itself.
+type ErrReservedKey struct {
+	Key string
+}
+
+func (e *ErrReservedKey) Error() string {
+	return fmt.Sprintf("key %q uses the reserved prefix %q", e.Key, ReservedPrefix)
+}
+
+// ErrQuotaExceeded is returned when a write would create more keys in a Namespace
+// than its quota allows. No data is written in this case.
+type ErrQuotaExceeded struct {
+	Namespace string
+	Quota     int
+	// Keys are the new keys (without prefix) that exceed the quota.
+	Keys []string
+}
+
+func (e *ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("quota of namespace %q exceeded: limit %d, %d overflowing keys %v", e.Namespace, e.Quota, len(e.Keys), e.Keys)
+}
+
+// ErrNamespaceOverlap is returned when a Namespace is created whose prefix is a prefix
+// of the prefix of an existing namespace, or vice versa. Such namespaces would see and
+// delete each other's keys.
+type ErrNamespaceOverlap struct {
+	Prefix   string
+	Existing string
+}
+
+func (e *ErrNamespaceOverlap) Error() string {
+	return fmt.Sprintf("namespace prefix %q overlaps with existing namespace %q", e.Prefix, e.Existing)
+}
+
+// ErrPairTooLarge is returned when an encoded kv pair exceeds MaxPairSize.
+type ErrPairTooLarge struct {
+	Key string
+	// Size is the length of the key plus the length of the encoded value.
+	Size int
+}
+
+func (e *ErrPairTooLarge) Error() string {
+	return fmt.Sprintf("kv pair %q has %d bytes, exceeding the limit of %d bytes", e.Key, e.Size, MaxPairSize)
+}
+
+// ErrInvalidSignature is returned when the signature of a signed value doesn't verify.
+var ErrInvalidSignature = errors.New("invalid value signature")
+
+// ErrTornRead is returned when the state of an application doesn't match its checksum,
+// e.g. because a write consisting of several transactions is in progress. Reading again
+// later usually succeeds.
+var ErrTornRead = errors.New("state doesn't match its checksum, possibly a write is in progress")
+
+// ConfigError is returned when a config file is invalid. It lists all problems, each
+// starting with the path of the field.
+type ConfigError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid config %s: %s", e.Path, strings.Join(e.Problems, "; "))
+}
+
+// ErrInsecureKeyFile is returned when a file holding a private key or password is
+// accessible by group or others.
+type ErrInsecureKeyFile struct {
+	Path string
+	Mode os.FileMode
+}
+
+func (e *ErrInsecureKeyFile) Error() string {
+	return fmt.Sprintf("key file %s has mode %#o, it must not be accessible by group or others (use chmod 600)", e.Path, e.Mode)
 }
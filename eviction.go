@@ -0,0 +1,212 @@
+package siam
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// KeyInfo describes a stored key, as seen by an EvictionPolicy. The metadata is kept
+// locally by the AlgorandBuffer. Keys that were written before the buffer was created
+// have zero timestamps, and are therefore the first candidates for time-based policies.
+type KeyInfo struct {
+	Key string
+
+	// Created is the time this buffer first wrote the key.
+	Created time.Time
+
+	// Written is the time this buffer last wrote the key.
+	Written time.Time
+
+	// Priority is set with AlgorandBuffer.SetPriority. Defaults to 0.
+	Priority int
+
+	// Expires is the time the key expires, if it was written with PutWithTTL.
+	Expires time.Time
+}
+
+// EvictionPolicy selects which keys are deleted when new keys don't fit into the
+// application anymore. See WithEvictionPolicy.
+type EvictionPolicy interface {
+	// Evict returns exactly n keys out of the given candidates. Candidates never
+	// include keys that are part of the current write.
+	Evict(candidates []KeyInfo, n int) []string
+}
+
+// EvictionFunc is a custom EvictionPolicy.
+type EvictionFunc func(candidates []KeyInfo, n int) []string
+
+func (f EvictionFunc) Evict(candidates []KeyInfo, n int) []string {
+	return f(candidates, n)
+}
+
+// sortedEviction evicts the first n candidates according to the given order.
+type sortedEviction func(a, b KeyInfo) bool
+
+func (less sortedEviction) Evict(candidates []KeyInfo, n int) []string {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return less(candidates[i], candidates[j])
+	})
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = candidates[i].Key
+	}
+	return keys
+}
+
+var (
+	// EvictLRU evicts the keys that were written least recently.
+	EvictLRU EvictionPolicy = sortedEviction(func(a, b KeyInfo) bool {
+		return a.Written.Before(b.Written)
+	})
+
+	// EvictFIFO evicts the keys that were created first.
+	EvictFIFO EvictionPolicy = sortedEviction(func(a, b KeyInfo) bool {
+		return a.Created.Before(b.Created)
+	})
+
+	// EvictLowestPriority evicts the keys with the lowest priority. Keys with equal
+	// priority are evicted in LRU order.
+	EvictLowestPriority EvictionPolicy = sortedEviction(func(a, b KeyInfo) bool {
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.Written.Before(b.Written)
+	})
+)
+
+// WithEvictionPolicy makes the buffer evict existing keys when a write creates more
+// keys than there are free slots. Evicted keys are deleted in the same transaction
+// as the keys that take their slots.
+func WithEvictionPolicy(p EvictionPolicy) BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		ab.eviction = p
+		return nil
+	}
+}
+
+// SetPriority sets the priority of a key, used by EvictLowestPriority and custom
+// policies. Keys with a lower priority are evicted first.
+func (ab *AlgorandBuffer) SetPriority(key string, priority int) {
+	ab.metaMu.Lock()
+	defer ab.metaMu.Unlock()
+	ab.keyInfo(key).Priority = priority
+}
+
+// keyInfo returns the metadata of a key, creating it if it doesn't exist. The caller
+// must hold metaMu.
+func (ab *AlgorandBuffer) keyInfo(key string) *KeyInfo {
+	info, ok := ab.keyMeta[key]
+	if !ok {
+		info = &KeyInfo{Key: key}
+		ab.keyMeta[key] = info
+	}
+	return info
+}
+
+// touchKeys updates the metadata after the given keys were deleted and written.
+func (ab *AlgorandBuffer) touchKeys(del []string, put map[string][]byte) {
+	ab.metaMu.Lock()
+	defer ab.metaMu.Unlock()
+	now := time.Now()
+	for _, k := range del {
+		if _, ok := put[k]; !ok {
+			delete(ab.keyMeta, k)
+		}
+	}
+	for k := range put {
+		if isReserved(k) {
+			continue
+		}
+		info := ab.keyInfo(k)
+		if info.Created.IsZero() {
+			info.Created = now
+		}
+		info.Written = now
+		info.Expires = time.Time{}
+	}
+}
+
+// selectVictims asks the eviction policy for n keys of the state that can be
+// deleted to make room for data.
+func (ab *AlgorandBuffer) selectVictims(state map[string][]byte, data map[string][]byte, n int) ([]string, error) {
+	ab.metaMu.Lock()
+	candidates := make([]KeyInfo, 0, len(state))
+	for k := range state {
+		if _, ok := data[k]; ok || isReserved(k) {
+			continue
+		}
+		if info, ok := ab.keyMeta[k]; ok {
+			candidates = append(candidates, *info)
+		} else {
+			candidates = append(candidates, KeyInfo{Key: k})
+		}
+	}
+	ab.metaMu.Unlock()
+	if len(candidates) < n {
+		return nil, fmt.Errorf("cannot evict %d keys, only %d candidates", n, len(candidates))
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Key < candidates[j].Key })
+
+	victims := ab.eviction.Evict(candidates, n)
+	unique := make(map[string]bool, len(victims))
+	for _, v := range victims {
+		_, isCandidate := state[v]
+		_, isWritten := data[v]
+		if !isCandidate || isWritten || unique[v] {
+			return nil, fmt.Errorf("eviction policy returned invalid key %q", v)
+		}
+		unique[v] = true
+	}
+	if len(victims) != n {
+		return nil, fmt.Errorf("eviction policy returned %d keys, expected %d", len(victims), n)
+	}
+	return victims, nil
+}
+
+// evictionTxns returns the transactions to store data, deleting the victims to make
+// room for new keys. Each victim is deleted in the same transaction as the new key
+// that takes its slot.
+func evictionTxns(state map[string][]byte, data map[string][]byte, victims []string) []txnOp {
+	newKeys := make([]string, 0)
+	for k := range data {
+		if _, ok := state[k]; !ok {
+			newKeys = append(newKeys, k)
+		}
+	}
+	sort.Strings(newKeys)
+
+	// the last len(victims) new keys need a victim, everything else fits as is
+	displacing := newKeys[len(newKeys)-len(victims):]
+	plain := make(map[string][]byte, len(data)-len(displacing))
+	for k, v := range data {
+		plain[k] = v
+	}
+	for _, k := range displacing {
+		delete(plain, k)
+	}
+	txns := make([]txnOp, 0)
+	if len(plain) > 0 {
+		for _, p := range partitionMapByte(plain, client.MaxKVArgs) {
+			txns = append(txns, txnOp{put: p})
+		}
+	}
+
+	// each displacement needs three arguments: the victim, the key and the value.
+	// One argument is reserved for the number of deleted keys.
+	perTxn := (client.MaxArgs - 1) / 3
+	for i := 0; i < len(displacing); i += perTxn {
+		end := i + perTxn
+		if end > len(displacing) {
+			end = len(displacing)
+		}
+		put := make(map[string][]byte, end-i)
+		for _, k := range displacing[i:end] {
+			put[k] = data[k]
+		}
+		txns = append(txns, txnOp{del: victims[i:end], put: put})
+	}
+	return txns
+}
@@ -0,0 +1,86 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// fillBuffer puts client.GlobalBytes keys into the buffer, one by one, so that
+// every key has a distinct write time.
+func fillBuffer(t *testing.T, buffer *AlgorandBuffer) {
+	for i := 0; i < client.GlobalBytes; i++ {
+		err := buffer.PutElements(context.Background(), map[string]string{fmt.Sprintf("%02d", i): "x"})
+		assert.Nil(t, err)
+	}
+}
+
+func TestEviction_FIFO(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithEvictionPolicy(EvictFIFO))
+	fillBuffer(t, buffer)
+
+	// rewriting doesn't change the creation time
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"00": "y"}))
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"new": "z"}))
+
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Len(t, d, client.GlobalBytes)
+	assert.Equal(t, "z", d["new"])
+	assert.NotContains(t, d, "00")
+}
+
+func TestEviction_LRU(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithEvictionPolicy(EvictLRU))
+	fillBuffer(t, buffer)
+
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"00": "y"}))
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"new": "z"}))
+
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Contains(t, d, "00")
+	assert.NotContains(t, d, "01")
+}
+
+// Evicting more keys than fit into a single transaction
+func TestEviction_LowestPriorityMany(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithEvictionPolicy(EvictLowestPriority))
+	fillBuffer(t, buffer)
+	for i := 0; i < client.GlobalBytes; i++ {
+		buffer.SetPriority(fmt.Sprintf("%02d", i), client.GlobalBytes-i)
+	}
+
+	data := make(map[string]string)
+	for i := 0; i < 12; i++ {
+		data[fmt.Sprintf("new%02d", i)] = "z"
+	}
+	assert.Nil(t, buffer.PutElements(context.Background(), data))
+
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Len(t, d, client.GlobalBytes)
+	assert.Contains(t, d, "00")
+	assert.NotContains(t, d, "63")
+	assert.NotContains(t, d, "52")
+	assert.Contains(t, d, "51")
+}
+
+// Custom policies returning invalid keys must not change the state
+func TestEviction_InvalidCustomPolicy(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	policy := EvictionFunc(func(candidates []KeyInfo, n int) []string {
+		return []string{"does-not-exist"}
+	})
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithEvictionPolicy(policy))
+	fillBuffer(t, buffer)
+
+	assert.NotNil(t, buffer.PutElements(context.Background(), map[string]string{"new": "z"}))
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.NotContains(t, d, "new")
+}
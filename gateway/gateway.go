@@ -0,0 +1,409 @@
+// Package gateway exposes an AlgorandBuffer as a REST service, so that publishers that
+// aren't written in Go can write to an oracle application.
+//
+// All routes live under /state and exchange JSON. Values are strings:
+//
+//	GET    /state               all pairs
+//	GET    /state/{key}         a single pair
+//	PUT    /state/{key}         store the request body as the value of key
+//	PATCH  /state               store the pairs of a JSON object, other keys are kept
+//	POST   /state               turn the state into the pairs of a JSON object, keys that
+//	                            aren't in the object are deleted. ?dry_run=true only
+//	                            returns the plan
+//	DELETE /state/{key}         delete a key
+//	DELETE /state?key=a&key=b   delete several keys
+//
+// Every request must carry one of the configured tokens as "Authorization: Bearer
+// <token>". Writes respond with a Receipt, errors with an ErrorResponse.
+//
+// Stream is a separate handler that streams the changes of configured applications to
+// dashboards, as server-sent events or newline-delimited JSON.
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	siam "github.com/m2q/algo-siam"
+	"github.com/m2q/algo-siam/client"
+)
+
+// MaxBodySize is the maximum size of a request body in bytes. A full application holds
+// 64 pairs of 128 bytes, so anything larger can't be written anyway.
+const MaxBodySize = 64 << 10
+
+// Handler is an http.Handler serving the state of an AlgorandBuffer. Create it with
+// New.
+type Handler struct {
+	buffer *siam.AlgorandBuffer
+	tokens [][]byte
+
+	// mu serializes writes, so that the transactions of concurrent requests don't
+	// interleave, and desired states are planned against the state they are applied to.
+	mu sync.Mutex
+}
+
+// New returns a Handler for the given buffer that accepts the given bearer tokens.
+// Without tokens, every request is rejected.
+func New(buffer *siam.AlgorandBuffer, tokens ...string) *Handler {
+	h := &Handler{buffer: buffer}
+	for _, t := range tokens {
+		if t != "" {
+			h.tokens = append(h.tokens, []byte(t))
+		}
+	}
+	return h
+}
+
+// Receipt is the response of a successful write.
+type Receipt struct {
+	AppId uint64 `json:"app"`
+	// Round is the last round of the node after the write was confirmed.
+	Round uint64 `json:"round"`
+	// Put and Deleted are the keys that were written and deleted, sorted.
+	Put     []string `json:"put"`
+	Deleted []string `json:"deleted"`
+	// Transactions is the number of transactions, only set for desired states.
+	Transactions int `json:"transactions,omitempty"`
+	// EstimatedFee is the estimated fee in microAlgos, only set for desired states.
+	EstimatedFee uint64 `json:"estimated_fee,omitempty"`
+	// DryRun is true if nothing was written.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// ErrorResponse is the body of all error responses, and of writes that must be signed
+// offline (202).
+type ErrorResponse struct {
+	// Code is a stable, machine-readable identifier of the error.
+	Code    string `json:"code"`
+	Message string `json:"error"`
+	// Keys are the keys causing the error, if any.
+	Keys []string `json:"keys,omitempty"`
+	// Transactions are the msgpack encoded, partially signed transactions of a write
+	// that lacks signatures, see client.ErrPartiallySigned.
+	Transactions [][]byte `json:"transactions,omitempty"`
+}
+
+// stateResponse is the body of GET /state.
+type stateResponse struct {
+	AppId uint64            `json:"app"`
+	Pairs map[string]string `json:"pairs"`
+}
+
+// pairResponse is the body of GET /state/{key}.
+type pairResponse struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, h.tokens) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="siam"`)
+		writeError(w, http.StatusUnauthorized, &ErrorResponse{Code: "unauthorized", Message: "missing or invalid bearer token"})
+		return
+	}
+
+	key, ok := parsePath(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, &ErrorResponse{Code: "not_found", Message: "unknown path " + r.URL.Path})
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		h.getState(w, r)
+	case r.Method == http.MethodGet:
+		h.getKey(w, r, key)
+	case r.Method == http.MethodPut && key != "":
+		h.putKey(w, r, key)
+	case r.Method == http.MethodPatch && key == "":
+		h.patchState(w, r)
+	case r.Method == http.MethodPost && key == "":
+		h.postState(w, r)
+	case r.Method == http.MethodDelete && key != "":
+		h.deleteKeys(w, r, []string{key})
+	case r.Method == http.MethodDelete:
+		h.deleteKeys(w, r, r.URL.Query()["key"])
+	default:
+		writeError(w, http.StatusMethodNotAllowed, &ErrorResponse{
+			Code:    "method_not_allowed",
+			Message: fmt.Sprintf("%s is not supported on %s", r.Method, r.URL.Path),
+		})
+	}
+}
+
+// authorized returns true if the request carries one of the tokens.
+func authorized(r *http.Request, tokens [][]byte) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	given := []byte(strings.TrimPrefix(auth, "Bearer "))
+	ok := false
+	for _, t := range tokens {
+		// check all tokens, so the timing doesn't reveal which one matched
+		if subtle.ConstantTimeCompare(given, t) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// parsePath returns the unescaped key of /state/{key}, or "" for /state.
+func parsePath(path string) (string, bool) {
+	if path == "/state" || path == "/state/" {
+		return "", true
+	}
+	if !strings.HasPrefix(path, "/state/") {
+		return "", false
+	}
+	key, err := url.PathUnescape(strings.TrimPrefix(path, "/state/"))
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
+
+func (h *Handler) getState(w http.ResponseWriter, r *http.Request) {
+	data, err := h.buffer.GetBuffer(r.Context())
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stateResponse{AppId: h.buffer.AppId, Pairs: data})
+}
+
+func (h *Handler) getKey(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := h.buffer.GetBuffer(r.Context())
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	v, ok := data[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, &ErrorResponse{Code: "key_not_found", Message: fmt.Sprintf("key %q doesn't exist", key), Keys: []string{key}})
+		return
+	}
+	writeJSON(w, http.StatusOK, pairResponse{Key: key, Value: v})
+}
+
+func (h *Handler) putKey(w http.ResponseWriter, r *http.Request, key string) {
+	b, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	h.put(w, r, map[string]string{key: string(b)})
+}
+
+func (h *Handler) patchState(w http.ResponseWriter, r *http.Request) {
+	pairs, ok := decodePairs(w, r)
+	if !ok {
+		return
+	}
+	h.put(w, r, pairs)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, pairs map[string]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.buffer.PutElements(r.Context(), pairs); err != nil {
+		writeErr(w, err)
+		return
+	}
+	put := make([]string, 0, len(pairs))
+	for k := range pairs {
+		put = append(put, k)
+	}
+	h.writeReceipt(w, r, &Receipt{Put: put, Deleted: []string{}})
+}
+
+func (h *Handler) postState(w http.ResponseWriter, r *http.Request) {
+	desired, ok := decodePairs(w, r)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	plan, err := h.buffer.Plan(r.Context(), desired)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if len(plan.Violations) > 0 {
+		resp := &ErrorResponse{Code: "plan_violation", Message: plan.Violations[0].String()}
+		for _, v := range plan.Violations {
+			resp.Keys = append(resp.Keys, v.Key)
+		}
+		writeError(w, http.StatusUnprocessableEntity, resp)
+		return
+	}
+
+	receipt := &Receipt{
+		Deleted:      plan.Deletes,
+		Transactions: plan.Transactions,
+		EstimatedFee: plan.EstimatedFee,
+		DryRun:       r.URL.Query().Get("dry_run") == "true",
+	}
+	for k := range plan.Puts {
+		receipt.Put = append(receipt.Put, k)
+	}
+	if !receipt.DryRun {
+		if err = h.buffer.Apply(r.Context(), plan); err != nil {
+			writeErr(w, err)
+			return
+		}
+	}
+	h.writeReceipt(w, r, receipt)
+}
+
+func (h *Handler) deleteKeys(w http.ResponseWriter, r *http.Request, keys []string) {
+	if len(keys) == 0 {
+		writeError(w, http.StatusBadRequest, &ErrorResponse{Code: "bad_request", Message: "no keys given, use ?key="})
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.buffer.DeleteElements(r.Context(), keys...); err != nil {
+		writeErr(w, err)
+		return
+	}
+	h.writeReceipt(w, r, &Receipt{Put: []string{}, Deleted: keys})
+}
+
+// writeReceipt completes the receipt with the application and round, and writes it.
+func (h *Handler) writeReceipt(w http.ResponseWriter, r *http.Request, receipt *Receipt) {
+	receipt.AppId = h.buffer.AppId
+	if receipt.Put == nil {
+		receipt.Put = []string{}
+	}
+	if receipt.Deleted == nil {
+		receipt.Deleted = []string{}
+	}
+	sort.Strings(receipt.Put)
+	sort.Strings(receipt.Deleted)
+	// the write succeeded, so a failing status request only leaves the round empty
+	if status, err := h.buffer.Client.Status(r.Context()); err == nil {
+		receipt.Round = status.LastRound
+	}
+	writeJSON(w, http.StatusOK, receipt)
+}
+
+// readBody reads the request body. If that fails, or the body exceeds MaxBodySize, an
+// error response is written.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	b, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, &ErrorResponse{Code: "bad_request", Message: err.Error()})
+		return nil, false
+	}
+	if len(b) > MaxBodySize {
+		writeError(w, http.StatusRequestEntityTooLarge, &ErrorResponse{
+			Code:    "body_too_large",
+			Message: fmt.Sprintf("body exceeds %d bytes", MaxBodySize),
+		})
+		return nil, false
+	}
+	return b, true
+}
+
+// decodePairs decodes a JSON object of string values from the request body. If that
+// fails, an error response is written.
+func decodePairs(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+	b, ok := readBody(w, r)
+	if !ok {
+		return nil, false
+	}
+	pairs := make(map[string]string)
+	if err := json.Unmarshal(b, &pairs); err != nil {
+		writeError(w, http.StatusBadRequest, &ErrorResponse{Code: "bad_request", Message: "body must be a JSON object of strings: " + err.Error()})
+		return nil, false
+	}
+	return pairs, true
+}
+
+// writeErr maps an error of the buffer to a status code and writes it.
+func writeErr(w http.ResponseWriter, err error) {
+	status, resp := statusOf(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeError(w, status, resp)
+}
+
+// statusOf returns the status code and response of an error.
+func statusOf(err error) (int, *ErrorResponse) {
+	resp := &ErrorResponse{Message: err.Error()}
+	var (
+		capacity *siam.ErrCapacityExceeded
+		quota    *siam.ErrQuotaExceeded
+		reserved *siam.ErrReservedKey
+		tooLarge *siam.ErrPairTooLarge
+		prefix   *siam.ErrWriterPrefix
+		writer   *siam.ErrNotWriter
+		partial  *client.ErrPartiallySigned
+		pw       *siam.ErrPartialWrite
+	)
+	switch {
+	case errors.As(err, &partial):
+		// nothing was sent, the transactions must be completed by the other key holders
+		resp.Code, resp.Transactions = "partially_signed", partial.Transactions
+		return http.StatusAccepted, resp
+	case errors.As(err, &pw):
+		resp.Code = "partial_write"
+		return http.StatusUnprocessableEntity, resp
+	case errors.As(err, &capacity):
+		resp.Code, resp.Keys = "capacity_exceeded", capacity.Keys
+		return http.StatusInsufficientStorage, resp
+	case errors.As(err, &quota):
+		resp.Code, resp.Keys = "quota_exceeded", quota.Keys
+		return http.StatusInsufficientStorage, resp
+	case errors.As(err, &reserved):
+		resp.Code, resp.Keys = "reserved_key", []string{reserved.Key}
+		return http.StatusForbidden, resp
+	case errors.As(err, &prefix):
+		resp.Code, resp.Keys = "writer_prefix", []string{prefix.Key}
+		return http.StatusForbidden, resp
+	case errors.As(err, &writer):
+		resp.Code = "not_writer"
+		return http.StatusForbidden, resp
+	case errors.Is(err, siam.ErrNotCreator):
+		resp.Code = "not_creator"
+		return http.StatusForbidden, resp
+	case errors.As(err, &tooLarge):
+		resp.Code, resp.Keys = "pair_too_large", []string{tooLarge.Key}
+		return http.StatusRequestEntityTooLarge, resp
+	case errors.Is(err, siam.ErrStateChanged):
+		resp.Code = "state_changed"
+		return http.StatusConflict, resp
+	case errors.Is(err, siam.ErrTornRead):
+		resp.Code = "torn_read"
+		return http.StatusServiceUnavailable, resp
+	case errors.Is(err, siam.ErrInvalidSignature):
+		resp.Code = "invalid_signature"
+		return http.StatusBadGateway, resp
+	case errors.Is(err, context.DeadlineExceeded):
+		resp.Code = "timeout"
+		return http.StatusGatewayTimeout, resp
+	}
+	// everything else is an error of the node
+	resp.Code = "node_error"
+	return http.StatusBadGateway, resp
+}
+
+func writeError(w http.ResponseWriter, status int, resp *ErrorResponse) {
+	writeJSON(w, status, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
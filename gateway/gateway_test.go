@@ -0,0 +1,259 @@
+//go:build unit
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/crypto"
+	siam "github.com/m2q/algo-siam"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+const token = "s3cr3t"
+
+func newServer(t *testing.T, opts ...siam.BufferOption) (*httptest.Server, *siam.AlgorandBuffer, *client.AlgorandMock) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.NodeStatus.LastRound = 4242
+	buffer, err := siam.NewAlgorandBuffer(c, client.GeneratePrivateKey64(), opts...)
+	assert.Nil(t, err)
+	srv := httptest.NewServer(New(buffer, token))
+	t.Cleanup(srv.Close)
+	return srv, buffer, c
+}
+
+// do sends a request and decodes the JSON response into out.
+func do(t *testing.T, srv *httptest.Server, method, path, body string, out interface{}) *http.Response {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := srv.Client().Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	if out != nil {
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp
+}
+
+func TestHandler_Auth(t *testing.T) {
+	srv, _, _ := newServer(t)
+	resp, err := srv.Client().Get(srv.URL + "/state")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/state", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	resp, err = srv.Client().Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// a handler without tokens rejects everything
+	rec := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/state", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	New(nil).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestHandler_ReadWrite(t *testing.T) {
+	srv, buffer, _ := newServer(t)
+
+	var receipt Receipt
+	resp := do(t, srv, http.MethodPatch, "/state", `{"1000":"Astralis","1001":"OG"}`, &receipt)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, Receipt{AppId: buffer.AppId, Round: 4242, Put: []string{"1000", "1001"}, Deleted: []string{}}, receipt)
+
+	resp = do(t, srv, http.MethodPut, "/state/team%20b", "G2", &receipt)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"team b"}, receipt.Put)
+
+	var state stateResponse
+	resp = do(t, srv, http.MethodGet, "/state", "", &state)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]string{"1000": "Astralis", "1001": "OG", "team b": "G2"}, state.Pairs)
+
+	var pair pairResponse
+	do(t, srv, http.MethodGet, "/state/1001", "", &pair)
+	assert.Equal(t, pairResponse{Key: "1001", Value: "OG"}, pair)
+
+	var errResp ErrorResponse
+	resp = do(t, srv, http.MethodGet, "/state/9999", "", &errResp)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "key_not_found", errResp.Code)
+
+	resp = do(t, srv, http.MethodDelete, "/state?key=1000&key=1001", "", &receipt)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"1000", "1001"}, receipt.Deleted)
+	resp = do(t, srv, http.MethodDelete, "/state/team%20b", "", &receipt)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	data, _ := buffer.GetBuffer(context.Background())
+	assert.Empty(t, data)
+}
+
+func TestHandler_DesiredState(t *testing.T) {
+	srv, buffer, _ := newServer(t)
+	do(t, srv, http.MethodPatch, "/state", `{"1000":"Astralis","1001":"OG"}`, nil)
+
+	var receipt Receipt
+	resp := do(t, srv, http.MethodPost, "/state?dry_run=true", `{"1001":"G2","1002":"Vitality"}`, &receipt)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, receipt.DryRun)
+	assert.Equal(t, []string{"1001", "1002"}, receipt.Put)
+	assert.Equal(t, []string{"1000"}, receipt.Deleted)
+	assert.Equal(t, 2, receipt.Transactions)
+
+	var state stateResponse
+	do(t, srv, http.MethodGet, "/state", "", &state)
+	assert.Equal(t, "Astralis", state.Pairs["1000"])
+
+	receipt = Receipt{}
+	resp = do(t, srv, http.MethodPost, "/state", `{"1001":"G2","1002":"Vitality"}`, &receipt)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, receipt.DryRun)
+	data, _ := buffer.GetBuffer(context.Background())
+	assert.Equal(t, map[string]string{"1001": "G2", "1002": "Vitality"}, data)
+}
+
+func TestHandler_Errors(t *testing.T) {
+	srv, buffer, c := newServer(t, siam.WithChecksum())
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{http.MethodPut, "/state/" + siam.MetaVersionKey, "1", http.StatusForbidden, "reserved_key"},
+		{http.MethodPut, "/state/k", strings.Repeat("x", 200), http.StatusRequestEntityTooLarge, "pair_too_large"},
+		{http.MethodPatch, "/state", `["not", "an", "object"]`, http.StatusBadRequest, "bad_request"},
+		{http.MethodPatch, "/state", strings.Repeat(" ", MaxBodySize+1), http.StatusRequestEntityTooLarge, "body_too_large"},
+		{http.MethodPost, "/state", `{"k":"` + strings.Repeat("x", 200) + `"}`, http.StatusUnprocessableEntity, "plan_violation"},
+		{http.MethodDelete, "/state", "", http.StatusBadRequest, "bad_request"},
+		{http.MethodPut, "/state", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{http.MethodGet, "/other", "", http.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		var errResp ErrorResponse
+		resp := do(t, srv, tt.method, tt.path, tt.body, &errResp)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.method+" "+tt.path)
+		assert.Equal(t, tt.code, errResp.Code, tt.method+" "+tt.path)
+	}
+
+	// more keys than the application can hold
+	pairs := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		pairs["k"+strconv.Itoa(i)] = "v"
+	}
+	body, _ := json.Marshal(pairs)
+	var errResp ErrorResponse
+	resp := do(t, srv, http.MethodPatch, "/state", string(body), &errResp)
+	assert.Equal(t, http.StatusInsufficientStorage, resp.StatusCode)
+	assert.Equal(t, "capacity_exceeded", errResp.Code)
+	assert.NotEmpty(t, errResp.Keys)
+
+	// a partially visible write
+	do(t, srv, http.MethodPatch, "/state", `{"1000":"Astralis"}`, nil)
+	assert.Nil(t, c.StoreGlobals(buffer.Signer, buffer.AppId, []models.TealKeyValue{
+		{Key: "1001", Value: models.TealValue{Bytes: "OG"}},
+	}))
+	resp = do(t, srv, http.MethodGet, "/state", "", &errResp)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "torn_read", errResp.Code)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	// node errors
+	c.SetError(true, (*client.AlgorandMock).GetApplicationByID)
+	resp = do(t, srv, http.MethodGet, "/state", "", &errResp)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, "node_error", errResp.Code)
+}
+
+func TestHandler_WriterErrors(t *testing.T) {
+	admin, feeder := crypto.GenerateAccount(), crypto.GenerateAccount()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := siam.NewAlgorandBufferWithSigner(c, client.NewAccountSigner(admin))
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.AddWriter(context.Background(), feeder.Address, "feed:"))
+	writer, err := siam.NewAlgorandBufferWithSigner(c, client.NewAccountSigner(feeder), siam.WithApplication(buffer.AppId))
+	assert.Nil(t, err)
+	srv := httptest.NewServer(New(writer, token))
+	defer srv.Close()
+
+	var errResp ErrorResponse
+	resp := do(t, srv, http.MethodPut, "/state/btc", "64000", &errResp)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "writer_prefix", errResp.Code)
+	assert.Equal(t, []string{"btc"}, errResp.Keys)
+	resp = do(t, srv, http.MethodPut, "/state/feed:btc", "64000", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	status, body := statusOf(&siam.ErrNotWriter{Address: feeder.Address, AppId: buffer.AppId})
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "not_writer", body.Code)
+	status, body = statusOf(siam.ErrNotCreator)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "not_creator", body.Code)
+}
+
+// Writes that lack signatures return the transactions to complete offline
+func TestHandler_PartialSignatures(t *testing.T) {
+	txns := [][]byte{{1, 2, 3}}
+	status, body := statusOf(fmt.Errorf("writing: %w", &client.ErrPartiallySigned{Transactions: txns, Signatures: 1, Threshold: 2}))
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, "partially_signed", body.Code)
+	b, _ := json.Marshal(body)
+	assert.Contains(t, string(b), `"transactions":["AQID"]`)
+
+	status, body = statusOf(&siam.ErrPartialWrite{Transactions: 2})
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Equal(t, "partial_write", body.Code)
+}
+
+// The transactions of concurrent writes don't interleave
+func TestHandler_SerializesWrites(t *testing.T) {
+	var mu sync.Mutex
+	var txns [][]string
+	srv, _, _ := newServer(t, siam.WithWriteHook(func(res siam.WriteResult) {
+		mu.Lock()
+		txns = append(txns, res.Put)
+		mu.Unlock()
+		// give other requests the chance to send a transaction in between
+		time.Sleep(5 * time.Millisecond)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		pairs := make(map[string]string)
+		for j := 0; j < 12; j++ {
+			pairs[strconv.Itoa(i)+"_"+strconv.Itoa(j)] = "v"
+		}
+		body, _ := json.Marshal(pairs)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, do(t, srv, http.MethodPatch, "/state", string(body), nil).StatusCode)
+		}()
+	}
+	wg.Wait()
+
+	// every write takes two transactions, which must be adjacent
+	assert.Len(t, txns, 8)
+	for i := 0; i < len(txns); i += 2 {
+		prefix := txns[i][0][:2]
+		for _, k := range append(txns[i], txns[i+1]...) {
+			assert.Equal(t, prefix, k[:2])
+		}
+	}
+}
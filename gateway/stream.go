@@ -0,0 +1,364 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	siam "github.com/m2q/algo-siam"
+	"github.com/m2q/algo-siam/client"
+)
+
+// Event is a message of the change stream.
+type Event struct {
+	Round uint64    `json:"round"`
+	Time  time.Time `json:"time"`
+	// Snapshot is true if Changes contain the full state. Clients discard what they
+	// had before.
+	Snapshot bool `json:"snapshot,omitempty"`
+	// Changes are sorted by key.
+	Changes []siam.EncodedChange `json:"changes"`
+}
+
+// Stream is an http.Handler streaming the changes of applications, at
+//
+//	GET /apps/{id}/changes
+//
+// as server-sent events, or as newline-delimited JSON with ?format=ndjson. SSE is
+// used if the Accept header contains text/event-stream or with ?format=sse. Every
+// message is an Event. The first Event is a snapshot of the full state.
+//
+// Clients resume with ?from=<round>, or the Last-Event-ID header of SSE, which is the
+// round of the last received Event. Several Events can have the same round, so all
+// Events from that round on are sent again if they are still known; otherwise the
+// stream starts with a snapshot. Changes are idempotent, so repeating them is safe.
+//
+// Only the applications passed to NewStream are served. All clients of an application
+// share a single poller, which stops with the last client. Create a Stream with
+// NewStream.
+type Stream struct {
+	// Client is used to poll applications.
+	Client client.AlgorandClient
+
+	// Interval is the polling interval.
+	Interval time.Duration
+
+	// History is the number of Events kept per application for resuming clients.
+	History int
+
+	// KeepAlive is the interval of SSE comments sent to keep idle connections open.
+	KeepAlive time.Duration
+
+	// Configure is called for every new siam.Reader, e.g. to set its ValueCodecs.
+	Configure func(*siam.Reader)
+
+	// MaxFeeds is the number of applications that are polled at the same time. Clients
+	// of further applications are rejected with 503.
+	MaxFeeds int
+
+	apps   map[uint64]bool
+	tokens [][]byte
+	mu     sync.Mutex
+	feeds  map[uint64]*feed
+}
+
+// NewStream returns a Stream of the given applications, polling with given client every
+// interval. If tokens are given, clients must send one of them as bearer token. Without
+// tokens the stream is public, just like the on-chain state itself.
+func NewStream(c client.AlgorandClient, interval time.Duration, apps []uint64, tokens ...string) *Stream {
+	s := &Stream{
+		Client:    c,
+		Interval:  interval,
+		History:   256,
+		KeepAlive: 15 * time.Second,
+		MaxFeeds:  16,
+		apps:      make(map[uint64]bool, len(apps)),
+		feeds:     make(map[uint64]*feed),
+	}
+	for _, id := range apps {
+		s.apps[id] = true
+	}
+	for _, t := range tokens {
+		if t != "" {
+			s.tokens = append(s.tokens, []byte(t))
+		}
+	}
+	return s
+}
+
+// subscriberBuffer is the number of Events buffered for a client. Clients that fall
+// further behind are disconnected, and can resume.
+const subscriberBuffer = 64
+
+// feed polls a single application and distributes its events.
+type feed struct {
+	cancel context.CancelFunc
+	// state is the last observed state. nil before the first poll.
+	state map[string]string
+	round uint64
+	// history holds the last events in order. covered is the last round whose changes
+	// aren't all known: a client that saw round r > covered can be brought up to date
+	// by the events in history from r on.
+	history []Event
+	covered uint64
+	subs    map[chan Event]struct{}
+}
+
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(s.tokens) > 0 && !authorized(r, s.tokens) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="siam"`)
+		writeError(w, http.StatusUnauthorized, &ErrorResponse{Code: "unauthorized", Message: "missing or invalid bearer token"})
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, &ErrorResponse{Code: "method_not_allowed", Message: r.Method + " is not supported"})
+		return
+	}
+	appId, ok := parseStreamPath(r.URL.Path)
+	if !ok || !s.apps[appId] {
+		writeError(w, http.StatusNotFound, &ErrorResponse{Code: "not_found", Message: "unknown path " + r.URL.Path})
+		return
+	}
+	sse, ok := streamFormat(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, &ErrorResponse{Code: "bad_request", Message: "format must be sse or ndjson"})
+		return
+	}
+	from, err := resumeRound(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, &ErrorResponse{Code: "bad_request", Message: "invalid resume round: " + err.Error()})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, &ErrorResponse{Code: "streaming_unsupported", Message: "response can't be streamed"})
+		return
+	}
+
+	ch := s.subscribe(appId, from)
+	if ch == nil {
+		writeError(w, http.StatusServiceUnavailable, &ErrorResponse{Code: "too_many_feeds", Message: "too many applications are streamed"})
+		return
+	}
+	defer s.unsubscribe(appId, ch)
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(s.KeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if sse {
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			}
+		case ev, ok := <-ch:
+			if !ok {
+				// too slow, the client can resume
+				return
+			}
+			if err := writeEvent(w, ev, sse); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// subscribe registers a client of the application that saw the given round, and
+// queues the events it's missing. It returns nil if MaxFeeds applications are polled
+// already.
+func (s *Stream) subscribe(appId uint64, from uint64) chan Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.feeds[appId]
+	if !ok {
+		if len(s.feeds) >= s.MaxFeeds {
+			return nil
+		}
+		f = s.startFeed(appId)
+	}
+	ch := make(chan Event, subscriberBuffer)
+	f.subs[ch] = struct{}{}
+
+	if f.state == nil {
+		// the first poll sends a snapshot
+		return ch
+	}
+	if from == 0 || from <= f.covered {
+		ch <- snapshot(f.round, f.state)
+		return ch
+	}
+	missing := f.history
+	for len(missing) > 0 && missing[0].Round < from {
+		missing = missing[1:]
+	}
+	if len(missing) > subscriberBuffer {
+		ch <- snapshot(f.round, f.state)
+		return ch
+	}
+	for _, ev := range missing {
+		ch <- ev
+	}
+	return ch
+}
+
+// unsubscribe removes a client. The poller of the application stops with its last
+// client.
+func (s *Stream) unsubscribe(appId uint64, ch chan Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.feeds[appId]
+	if !ok {
+		return
+	}
+	if _, ok = f.subs[ch]; ok {
+		delete(f.subs, ch)
+		close(ch)
+	}
+	if len(f.subs) == 0 {
+		f.cancel()
+		delete(s.feeds, appId)
+	}
+}
+
+// startFeed starts polling the application. s.mu must be held.
+func (s *Stream) startFeed(appId uint64) *feed {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &feed{cancel: cancel, subs: make(map[chan Event]struct{})}
+	s.feeds[appId] = f
+
+	reader := siam.NewReader(s.Client, appId)
+	if s.Configure != nil {
+		s.Configure(reader)
+	}
+	changes := reader.Watch(ctx, s.Interval)
+	go func() {
+		for cs := range changes {
+			if cs.Err != nil {
+				// the next poll retries
+				continue
+			}
+			s.publish(f, cs)
+		}
+	}()
+	return f
+}
+
+// publish applies a ChangeSet to the feed and sends it to all clients.
+func (s *Stream) publish(f *feed, cs siam.ChangeSet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ev := Event{Round: cs.Round, Time: cs.Time, Changes: make([]siam.EncodedChange, len(cs.Changes))}
+	if f.state == nil {
+		// the first ChangeSet of Watch contains all keys
+		ev.Snapshot = true
+		f.state = make(map[string]string)
+		f.covered = cs.Round
+	}
+	for i, c := range cs.Changes {
+		ev.Changes[i] = c.Encode()
+		if c.Deleted {
+			delete(f.state, c.Key)
+		} else {
+			f.state[c.Key] = string(c.Value)
+		}
+	}
+	f.round = cs.Round
+	if !ev.Snapshot {
+		f.history = append(f.history, ev)
+		if len(f.history) > s.History {
+			f.covered = f.history[0].Round
+			f.history = f.history[1:]
+		}
+	}
+
+	for ch := range f.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// snapshot returns an Event containing the full state.
+func snapshot(round uint64, state map[string]string) Event {
+	ev := Event{Round: round, Time: time.Now(), Snapshot: true, Changes: make([]siam.EncodedChange, 0, len(state))}
+	for k, v := range state {
+		ev.Changes = append(ev.Changes, siam.Change{Key: k, Value: []byte(v)}.Encode())
+	}
+	sort.Slice(ev.Changes, func(i, j int) bool { return ev.Changes[i].Key < ev.Changes[j].Key })
+	return ev
+}
+
+// writeEvent writes an Event as SSE message or JSON line.
+func writeEvent(w http.ResponseWriter, ev Event, sse bool) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if !sse {
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+	name := "changes"
+	if ev.Snapshot {
+		name = "snapshot"
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Round, name, b)
+	return err
+}
+
+// parseStreamPath returns the application ID of /apps/{id}/changes.
+func parseStreamPath(path string) (uint64, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "apps" || parts[2] != "changes" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	return id, err == nil && id != 0
+}
+
+// streamFormat returns true if the request asks for SSE, and false if it asks for
+// NDJSON.
+func streamFormat(r *http.Request) (sse bool, ok bool) {
+	switch r.URL.Query().Get("format") {
+	case "sse":
+		return true, true
+	case "ndjson":
+		return false, true
+	case "":
+		return strings.Contains(r.Header.Get("Accept"), "text/event-stream"), true
+	}
+	return false, false
+}
+
+// resumeRound returns the round of ?from or the Last-Event-ID header, or 0.
+func resumeRound(r *http.Request) (uint64, error) {
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		from = r.Header.Get("Last-Event-ID")
+	}
+	if from == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(from, 10, 64)
+}
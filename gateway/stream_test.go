@@ -0,0 +1,174 @@
+//go:build unit
+
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	siam "github.com/m2q/algo-siam"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream_NDJSON(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.NodeStatus.LastRound = 4242
+	buffer, _ := siam.NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"}))
+
+	s := NewStream(c, 10*time.Millisecond, []uint64{buffer.AppId})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/apps/"+strconv.FormatUint(buffer.AppId, 10)+"/changes?format=ndjson", nil)
+	resp, err := srv.Client().Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewScanner(resp.Body)
+	var ev Event
+	assert.True(t, lines.Scan())
+	assert.Nil(t, json.Unmarshal(lines.Bytes(), &ev))
+	assert.Equal(t, Event{Round: 4242, Time: ev.Time, Snapshot: true, Changes: []siam.EncodedChange{{Key: "1000", Value: "Astralis"}}}, ev)
+
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1001": "OG"}))
+	assert.True(t, lines.Scan())
+	ev = Event{}
+	assert.Nil(t, json.Unmarshal(lines.Bytes(), &ev))
+	assert.False(t, ev.Snapshot)
+	assert.Equal(t, []siam.EncodedChange{{Key: "1001", Value: "OG"}}, ev.Changes)
+
+	// binary values are sent base64 encoded
+	assert.Nil(t, buffer.PutElementsRaw(context.Background(), map[string][]byte{"1002": {0xff, 0x00}}))
+	assert.True(t, lines.Scan())
+	ev = Event{}
+	assert.Nil(t, json.Unmarshal(lines.Bytes(), &ev))
+	assert.Equal(t, []siam.EncodedChange{{Key: "1002", ValueB64: "/wA="}}, ev.Changes)
+
+	// the poller stops with its last client
+	cancel()
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.feeds) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestStream_SSE(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.NodeStatus.LastRound = 7
+	buffer, _ := siam.NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	srv := httptest.NewServer(NewStream(c, 10*time.Millisecond, []uint64{buffer.AppId}, token))
+	defer srv.Close()
+	path := srv.URL + "/apps/" + strconv.FormatUint(buffer.AppId, 10) + "/changes"
+
+	resp, err := srv.Client().Get(path)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err = srv.Client().Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewScanner(resp.Body)
+	var msg []string
+	for lines.Scan() && lines.Text() != "" {
+		msg = append(msg, lines.Text())
+	}
+	assert.Len(t, msg, 3)
+	assert.Equal(t, "id: 7", msg[0])
+	assert.Equal(t, "event: snapshot", msg[1])
+	assert.True(t, strings.HasPrefix(msg[2], `data: {"round":7`))
+}
+
+func TestStream_Resume(t *testing.T) {
+	s := NewStream(client.CreateAlgorandClientMock("", ""), time.Hour, []uint64{1})
+	s.History = 3
+	f := &feed{cancel: func() {}, subs: make(map[chan Event]struct{})}
+	s.feeds[1] = f
+
+	s.publish(f, siam.ChangeSet{Round: 10, Changes: []siam.Change{{Key: "a", Value: []byte("1")}}})
+	s.publish(f, siam.ChangeSet{Round: 11, Changes: []siam.Change{{Key: "b", Value: []byte("2")}}})
+	s.publish(f, siam.ChangeSet{Round: 12, Changes: []siam.Change{{Key: "a", Deleted: true}}})
+	s.publish(f, siam.ChangeSet{Round: 12, Changes: []siam.Change{{Key: "c", Value: []byte("3")}}})
+
+	// all events from the last seen round on, as it can have several
+	ch := s.subscribe(1, 12)
+	ev := <-ch
+	assert.Equal(t, uint64(12), ev.Round)
+	assert.Equal(t, []siam.EncodedChange{{Key: "a", Deleted: true}}, ev.Changes)
+	ev = <-ch
+	assert.Equal(t, uint64(12), ev.Round)
+	assert.Equal(t, []siam.EncodedChange{{Key: "c", Value: "3"}}, ev.Changes)
+	assert.Empty(t, ch)
+
+	ch = s.subscribe(1, 11)
+	assert.Equal(t, uint64(11), (<-ch).Round)
+	assert.Len(t, ch, 2)
+
+	// the changes of the first round aren't in the history
+	ch = s.subscribe(1, 10)
+	ev = <-ch
+	assert.True(t, ev.Snapshot)
+	assert.Equal(t, uint64(12), ev.Round)
+
+	// new clients get a snapshot
+	ch = s.subscribe(1, 0)
+	ev = <-ch
+	assert.True(t, ev.Snapshot)
+	assert.Equal(t, uint64(12), ev.Round)
+	assert.Equal(t, []siam.EncodedChange{{Key: "b", Value: "2"}, {Key: "c", Value: "3"}}, ev.Changes)
+
+	// round 11 is no longer covered by the history
+	s.publish(f, siam.ChangeSet{Round: 13, Changes: []siam.Change{{Key: "d", Value: []byte("4")}}})
+	ch = s.subscribe(1, 11)
+	ev = <-ch
+	assert.True(t, ev.Snapshot)
+	assert.Equal(t, uint64(13), ev.Round)
+	ch = s.subscribe(1, 12)
+	assert.Len(t, ch, 3)
+}
+
+func TestStream_BadRequests(t *testing.T) {
+	s := NewStream(client.CreateAlgorandClientMock("", ""), time.Hour, []uint64{1})
+	for path, status := range map[string]int{
+		"/apps/x/changes":              http.StatusNotFound,
+		"/apps/1/other":                http.StatusNotFound,
+		"/apps/2/changes":              http.StatusNotFound,
+		"/apps/1/changes?format=xml":   http.StatusBadRequest,
+		"/apps/1/changes?from=unknown": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, status, rec.Code, path)
+	}
+}
+
+func TestStream_MaxFeeds(t *testing.T) {
+	s := NewStream(client.CreateAlgorandClientMock("", ""), time.Hour, []uint64{1, 2})
+	s.MaxFeeds = 1
+	s.feeds[1] = &feed{cancel: func() {}, subs: make(map[chan Event]struct{})}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/apps/2/changes", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "too_many_feeds")
+	assert.Len(t, s.feeds, 1)
+}
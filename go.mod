@@ -1,18 +1,19 @@
 module github.com/m2q/algo-siam
 
-go 1.17
+go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/algorand/go-algorand-sdk v1.13.0
+	github.com/algorand/go-codec/codec v1.1.7
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/algorand/go-algorand v0.0.0-20211020145413-1e5603c2691d // indirect
-	github.com/algorand/go-codec/codec v1.1.7 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/google/go-querystring v1.0.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776 // indirect
 )
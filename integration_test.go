@@ -5,6 +5,7 @@ package siam
 import (
 	"context"
 	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/future"
 	"github.com/algorand/go-algorand-sdk/types"
 	"strconv"
 	"testing"
@@ -29,7 +30,7 @@ func TestIntegration_ValidAccount(t *testing.T) {
 	assert.Nil(t, err)
 
 	ctx, cancel := context.WithTimeout(context.Background(), client.AlgorandDefaultTimeout)
-	info, err := buffer.Client.AccountInformation(buffer.AccountCrypt.Address.String(), ctx)
+	info, err := buffer.Client.AccountInformation(buffer.Signer.Address().String(), ctx)
 	cancel()
 
 	assert.Nil(t, err)
@@ -43,12 +44,12 @@ func TestSmartContract_DeleteWrongAcc(t *testing.T) {
 	assert.Nil(t, err)
 
 	// Random Account deleting app should throw error
-	randomAcc := crypto.GenerateAccount()
+	randomAcc := client.NewAccountSigner(crypto.GenerateAccount())
 	err = buffer.Client.DeleteApplication(randomAcc, buffer.AppId)
 	assert.NotNil(t, err)
 
 	// Creator account deleting app should pass without problems
-	err = buffer.Client.DeleteApplication(buffer.AccountCrypt, buffer.AppId)
+	err = buffer.Client.DeleteApplication(buffer.Signer, buffer.AppId)
 	assert.Nil(t, err)
 }
 
@@ -64,12 +65,12 @@ func TestSmartContract_GenerateTransaction(t *testing.T) {
 	assert.Nil(t, err)
 
 	// NoOp Application call from original creator
-	txn, err := client.GenerateApplicationCallTx(buffer.AppId, buffer.AccountCrypt, params, types.NoOpOC)
+	txn, err := client.GenerateApplicationCallTx(buffer.AppId, buffer.Signer, params, types.NoOpOC)
 	assert.Nil(t, err)
 
 	// Execute Transaction
 	ctx, cancel := context.WithTimeout(context.Background(), client.AlgorandDefaultTimeout)
-	_, err = buffer.Client.ExecuteTransaction(buffer.AccountCrypt, txn, ctx)
+	_, err = buffer.Client.ExecuteTransaction(buffer.Signer, txn, ctx)
 	cancel()
 	assert.Nil(t, err)
 }
@@ -95,11 +96,11 @@ func TestSmartContract_RejectNonSupportedOps(t *testing.T) {
 
 	// Deny every transaction with the
 	for _, oc := range denyOc {
-		txn, err := client.GenerateApplicationCallTx(buffer.AppId, buffer.AccountCrypt, params, oc)
+		txn, err := client.GenerateApplicationCallTx(buffer.AppId, buffer.Signer, params, oc)
 		assert.Nil(t, err)
 		// Execute Transaction
 		ctx, cancel := context.WithTimeout(context.Background(), client.AlgorandDefaultTimeout)
-		_, err = buffer.Client.ExecuteTransaction(buffer.AccountCrypt, txn, ctx)
+		_, err = buffer.Client.ExecuteTransaction(buffer.Signer, txn, ctx)
 		cancel()
 		assert.NotNil(t, err)
 	}
@@ -223,3 +224,70 @@ func TestSmartContract_PutManyData(t *testing.T) {
 	err = buffer.PutElements(context.Background(), data)
 	assert.Nil(t, err)
 }
+
+// Delete and store keys within a single update transaction
+func TestSmartContract_UpdateGlobals(t *testing.T) {
+	_ = createBufferAndRemoveApps(t)
+	buffer, err := NewAlgorandBufferFromEnv()
+	assert.Nil(t, err)
+
+	data := map[string]string{
+		"1000": "Astralis",
+		"1001": "Vitality",
+	}
+	assert.Nil(t, buffer.PutElements(context.Background(), data))
+
+	kv := toTealKeyValues(map[string][]byte{"1002": []byte("Gambit")})
+	err = buffer.Client.UpdateGlobals(buffer.Signer, buffer.AppId, []string{"1000"}, kv)
+	assert.Nil(t, err)
+
+	d, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1001": "Vitality", "1002": "Gambit"}, d)
+}
+
+// Check if the smart contract restricts writers to their prefix, keeps them away from
+// reserved keys, and refuses them once removed.
+func TestSmartContract_Writers(t *testing.T) {
+	_ = createBufferAndRemoveApps(t)
+	buffer, err := NewAlgorandBufferFromEnv()
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	feeder := crypto.GenerateAccount()
+	fundAccount(t, buffer, feeder.Address)
+	feed := client.NewAccountSigner(feeder)
+	assert.Nil(t, buffer.AddWriter(ctx, feeder.Address, "feed:"))
+
+	w, err := NewAlgorandBufferWithSigner(buffer.Client, feed, WithApplication(buffer.AppId))
+	assert.Nil(t, err)
+	assert.Nil(t, w.PutElements(ctx, map[string]string{"feed:btc": "64000"}))
+
+	// the contract must refuse what the client would refuse as well
+	for _, key := range []string{"btc", ChecksumKey, MetaVersionKey, client.SweepKey, client.WriterKey(feeder.Address)} {
+		kv := toTealKeyValues(map[string][]byte{key: []byte("1")})
+		assert.NotNil(t, buffer.Client.UpdateGlobals(feed, buffer.AppId, nil, kv), key)
+	}
+
+	assert.Nil(t, buffer.RemoveWriter(ctx, feeder.Address))
+	assert.NotNil(t, w.PutElements(ctx, map[string]string{"feed:btc": "65000"}))
+
+	d, err := buffer.GetBuffer(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"feed:btc": "64000"}, d)
+}
+
+// fundAccount sends one Algo from the account of the buffer to addr, so it can pay fees.
+func fundAccount(t *testing.T, buffer *AlgorandBuffer, addr types.Address) {
+	params, err := buffer.Client.SuggestedParams(context.Background())
+	assert.Nil(t, err)
+	txn, err := future.MakePaymentTxn(buffer.Signer.Address().String(), addr.String(), 1000000, nil, "", params)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.AlgorandDefaultTimeout)
+	_, err = buffer.Client.ExecuteTransaction(buffer.Signer, txn, ctx)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,487 @@
+package siam
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/types"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// JournalStatus describes the state of an operation recorded in a Journal.
+type JournalStatus string
+
+const (
+	// JournalPending marks an operation that was about to be sent to the node, but
+	// was never confirmed.
+	JournalPending JournalStatus = "pending"
+	// JournalConfirmed marks an operation that is known to be applied on-chain.
+	JournalConfirmed JournalStatus = "confirmed"
+	// JournalFailed marks an operation that is known to not be applied, because the
+	// node rejected it, or that was abandoned during reconciliation.
+	JournalFailed JournalStatus = "failed"
+	// JournalUnsigned marks an operation whose transaction the signer signed only
+	// partially (see client.ErrPartiallySigned). It stays open until SubmitSigned sends
+	// the completed transaction, and isn't replayed.
+	JournalUnsigned JournalStatus = "unsigned"
+)
+
+// Journal operations. They correspond to the notes of the approval.teal contract.
+const (
+	journalPut    = "put"
+	journalDelete = "delete"
+	journalUpdate = "update"
+)
+
+// JournalEntry is a single record of the write-ahead journal. Intent records carry
+// an Op together with the pairs to store and the keys to delete. Status records only
+// carry the Seq of the intent they refer to and its new Status. Pending status records
+// carry the signed transactions of the intent and their TxIDs, which are recorded
+// right before the transactions are sent. Unsigned status records carry the partially
+// signed transactions instead.
+type JournalEntry struct {
+	Seq    uint64            `json:"seq"`
+	Op     string            `json:"op,omitempty"`
+	AppId  uint64            `json:"app,omitempty"`
+	Pairs  map[string][]byte `json:"pairs,omitempty"`
+	Keys   []string          `json:"keys,omitempty"`
+	Signed [][]byte          `json:"signed,omitempty"`
+	TxIDs  []string          `json:"txids,omitempty"`
+	Status JournalStatus     `json:"status"`
+	Error  string            `json:"error,omitempty"`
+	Time   time.Time         `json:"time"`
+}
+
+// Journal is an append-only, line-delimited JSON log of the writes an AlgorandBuffer
+// intends to make. Every transaction is recorded as pending before it is sent, and
+// marked as confirmed once the node accepted it. If the process crashes in between,
+// the pending entries are reconciled against the chain by NewAlgorandBuffer.
+//
+// Use WithJournal to attach a journal to an AlgorandBuffer.
+type Journal struct {
+	mu      sync.Mutex
+	file    *os.File
+	path    string
+	nextSeq uint64
+
+	// pending holds all intents that have not been confirmed or failed yet.
+	pending map[uint64]*JournalEntry
+
+	// lastTouch maps every key to the sequence number of the most recent confirmed
+	// intent that wrote or deleted it. Used to skip keys superseded by later writes.
+	lastTouch map[string]uint64
+}
+
+// OpenJournal opens the journal at the given path, creating it if it doesn't exist.
+// Existing records are loaded so that pending entries can be reconciled.
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{
+		path:      path,
+		nextSeq:   1,
+		pending:   make(map[uint64]*JournalEntry),
+		lastTouch: make(map[string]uint64),
+	}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.file = f
+	return j, nil
+}
+
+// load reads all records of the journal file and rebuilds the in-memory index.
+// A truncated last line (e.g. from a crash during a write) is ignored, any other
+// corrupt line is an error.
+func (j *Journal) load() error {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var corrupt error
+	for line := 1; scanner.Scan(); line++ {
+		if corrupt != nil {
+			return corrupt
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			corrupt = fmt.Errorf("journal %s: line %d is corrupt: %s", j.path, line, err)
+			continue
+		}
+		j.apply(&e)
+	}
+	return scanner.Err()
+}
+
+// apply updates the in-memory index with a record.
+func (j *Journal) apply(e *JournalEntry) {
+	if e.Seq >= j.nextSeq {
+		j.nextSeq = e.Seq + 1
+	}
+	if e.Op == "" {
+		p, ok := j.pending[e.Seq]
+		switch {
+		case !ok:
+		case e.Status == JournalPending || e.Status == JournalUnsigned:
+			p.Status = e.Status
+			p.Signed = append(p.Signed, e.Signed...)
+			p.TxIDs = append(p.TxIDs, e.TxIDs...)
+		default:
+			if e.Status == JournalConfirmed {
+				j.touch(p)
+			}
+			delete(j.pending, e.Seq)
+		}
+		return
+	}
+	if e.Status == JournalPending || e.Status == JournalUnsigned {
+		j.pending[e.Seq] = e
+	}
+}
+
+// touch records the keys of a confirmed intent in lastTouch.
+func (j *Journal) touch(e *JournalEntry) {
+	for k := range e.Pairs {
+		if j.lastTouch[k] < e.Seq {
+			j.lastTouch[k] = e.Seq
+		}
+	}
+	for _, k := range e.Keys {
+		if j.lastTouch[k] < e.Seq {
+			j.lastTouch[k] = e.Seq
+		}
+	}
+}
+
+// append writes a record to the end of the journal and syncs it to disk.
+func (j *Journal) append(e *JournalEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = j.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err = j.file.Sync(); err != nil {
+		return err
+	}
+	j.apply(e)
+	return nil
+}
+
+// begin records the intent of a put or delete operation and returns its sequence
+// number. Calling begin on a nil Journal is a no-op.
+func (j *Journal) begin(op string, appId uint64, pairs map[string][]byte, keys []string) (uint64, error) {
+	if j == nil {
+		return 0, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e := &JournalEntry{
+		Seq:    j.nextSeq,
+		Op:     op,
+		AppId:  appId,
+		Keys:   append([]string(nil), keys...),
+		Status: JournalPending,
+		Time:   time.Now(),
+	}
+	if pairs != nil {
+		e.Pairs = make(map[string][]byte, len(pairs))
+		for k, v := range pairs {
+			e.Pairs[k] = append([]byte(nil), v...)
+		}
+	}
+	return e.Seq, j.append(e)
+}
+
+// finish records the final status of the intent with the given sequence number.
+// Calling finish on a nil Journal is a no-op.
+func (j *Journal) finish(seq uint64, status JournalStatus, cause error) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e := &JournalEntry{Seq: seq, Status: status, Time: time.Now()}
+	if cause != nil {
+		e.Error = cause.Error()
+	}
+	return j.append(e)
+}
+
+// signed records the signed transactions of the intent with the given sequence
+// number, before they are sent, or with JournalUnsigned the partially signed ones.
+// Calling signed on a nil Journal is a no-op.
+func (j *Journal) signed(seq uint64, status JournalStatus, signed [][]byte) error {
+	if j == nil {
+		return nil
+	}
+	e := &JournalEntry{Seq: seq, Status: status, Time: time.Now()}
+	for _, b := range signed {
+		var stx types.SignedTxn
+		if err := msgpack.Decode(b, &stx); err != nil {
+			return fmt.Errorf("invalid signed transaction: %s", err)
+		}
+		e.Signed = append(e.Signed, b)
+		e.TxIDs = append(e.TxIDs, crypto.TransactionIDString(stx.Txn))
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.append(e)
+}
+
+// signer returns a Signer that records the transactions signed by s for the intent
+// with the given sequence number. Without journal, s is returned.
+func (j *Journal) signer(s client.Signer, seq uint64) client.Signer {
+	if j == nil {
+		return s
+	}
+	return &journalSigner{Signer: s, journal: j, seq: seq}
+}
+
+// journalSigner records signed transactions in the journal before they are returned
+// to the client, which sends them.
+type journalSigner struct {
+	client.Signer
+	journal *Journal
+	seq     uint64
+}
+
+func (s *journalSigner) SignTransactions(ctx context.Context, txns []types.Transaction) ([][]byte, error) {
+	signed, err := s.Signer.SignTransactions(ctx, txns)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.journal.signed(s.seq, JournalPending, signed); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// finishSigned records the final status of the open intents that recorded one of the
+// given signed transactions. Calling finishSigned on a nil Journal is a no-op.
+func (j *Journal) finishSigned(signed [][]byte, status JournalStatus, cause error) error {
+	if j == nil {
+		return nil
+	}
+	txids := make(map[string]bool, len(signed))
+	for _, b := range signed {
+		var stx types.SignedTxn
+		if err := msgpack.Decode(b, &stx); err == nil {
+			txids[crypto.TransactionIDString(stx.Txn)] = true
+		}
+	}
+	for _, e := range j.Pending() {
+		for _, txid := range e.TxIDs {
+			if txids[txid] {
+				if err := j.finish(e.Seq, status, cause); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Pending returns all intents that have not been confirmed yet, ordered by their
+// sequence number. Keys that were superseded by a later, confirmed intent are left
+// out. Intents
+// waiting for SubmitSigned have the status JournalUnsigned.
+func (j *Journal) Pending() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := make([]JournalEntry, 0, len(j.pending))
+	for _, e := range j.pending {
+		entries = append(entries, j.effective(e))
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+	return entries
+}
+
+// effective returns a copy of e, stripped of all keys that a later, confirmed intent
+// touched.
+func (j *Journal) effective(e *JournalEntry) JournalEntry {
+	c := *e
+	c.Keys = nil
+	c.Pairs = nil
+	for _, k := range e.Keys {
+		if j.lastTouch[k] < e.Seq {
+			c.Keys = append(c.Keys, k)
+		}
+	}
+	for k, v := range e.Pairs {
+		if j.lastTouch[k] < e.Seq {
+			if c.Pairs == nil {
+				c.Pairs = make(map[string][]byte)
+			}
+			c.Pairs[k] = v
+		}
+	}
+	return c
+}
+
+// Compact rewrites the journal so that it only contains the pending intents. Call
+// it periodically to keep the file from growing unbounded.
+func (j *Journal) Compact() error {
+	entries := j.Pending()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for i := range entries {
+		if err = enc.Encode(&entries[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = j.file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	j.file, err = os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("reopening journal: %s", err)
+	}
+
+	j.pending = make(map[uint64]*JournalEntry)
+	j.lastTouch = make(map[string]uint64)
+	for i := range entries {
+		j.apply(&entries[i])
+	}
+	return nil
+}
+
+// Close closes the underlying journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// replayJournal reconciles the pending entries of the journal against the chain.
+// Entries whose recorded transaction was confirmed, and keys that already hold their
+// intended state are confirmed right away, all other keys are written again like any
+// other write, so reserved keys like the checksum are updated. Puts and deletes are
+// idempotent, so resending them is safe. Unsigned entries are left open, and entries
+// whose keys don't fit anymore are abandoned as failed.
+func (ab *AlgorandBuffer) replayJournal(ctx context.Context) error {
+	if ab.journal == nil {
+		return nil
+	}
+	for _, e := range ab.journal.Pending() {
+		if e.AppId != ab.AppId {
+			err := ab.journal.finish(e.Seq, JournalFailed, errors.New("application was replaced"))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if ab.confirmedTxn(ctx, e.TxIDs) {
+			if err := ab.journal.finish(e.Seq, JournalConfirmed, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if e.Status == JournalUnsigned {
+			// the transaction is still being signed offline, see SubmitSigned
+			continue
+		}
+		state, err := ab.getGlobalState(ctx)
+		if err != nil {
+			return ab.writeFailed(e.Keys, e.Pairs, err)
+		}
+		// the trailer of the entry is outdated, commit writes a new one
+		trailer := make(map[string]bool)
+		for _, k := range ab.reservedKeys() {
+			trailer[k] = true
+		}
+		missing := make(map[string][]byte)
+		for k, v := range e.Pairs {
+			if cur, ok := state[k]; !trailer[k] && (!ok || !bytes.Equal(cur, v)) {
+				missing[k] = v
+			}
+		}
+		present := make([]string, 0)
+		for _, k := range e.Keys {
+			if _, ok := state[k]; !trailer[k] && ok {
+				present = append(present, k)
+			}
+		}
+		// like PutElements, the keys must fit or be made room for by evicting
+		txns := []txnOp{{del: present, put: missing}}
+		if len(present) == 0 {
+			txns, err = ab.putTxns(state, missing)
+		} else {
+			err = checkCapacity(ab.withReserved(state), getKeysByte(missing), present)
+		}
+		if err != nil {
+			_ = ab.writeFailed(e.Keys, e.Pairs, err)
+			if err = ab.journal.finish(e.Seq, JournalFailed, err); err != nil {
+				return err
+			}
+			continue
+		}
+		err = ab.commit(ctx, txns)
+		if err != nil {
+			return fmt.Errorf("replaying journal entry %d: %s", e.Seq, err)
+		}
+		if err = ab.journal.finish(e.Seq, JournalConfirmed, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// confirmedTxn returns true if the node knows one of the given transactions as
+// confirmed. Nodes forget transactions some rounds after confirmation, so false
+// doesn't mean that none was applied.
+func (ab *AlgorandBuffer) confirmedTxn(ctx context.Context, txids []string) bool {
+	for _, txid := range txids {
+		ctx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+		info, _, err := ab.Client.PendingTransactionInformation(txid, ctx)
+		cancel()
+		if err == nil && info.ConfirmedRound > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// Every successful write should be recorded as confirmed, leaving nothing pending
+func TestJournal_ConfirmsWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "siam.journal")
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithJournal(path))
+	assert.Nil(t, err)
+	defer buffer.Close()
+
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"}))
+	assert.Nil(t, buffer.DeleteElements(context.Background(), "1000"))
+	assert.Len(t, buffer.journal.Pending(), 0)
+}
+
+// A put that was journaled but never sent (e.g. due to a crash) should be
+// replayed when the buffer is created again
+func TestJournal_ReplayPendingPut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "siam.journal")
+	key := client.GeneratePrivateKey64()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBuffer(c, key, WithJournal(path))
+	assert.Nil(t, err)
+
+	// simulate crash between journaling and sending
+	_, err = buffer.journal.begin(journalPut, buffer.AppId, map[string][]byte{"1000": []byte("Vitality")}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.Close())
+
+	buffer, err = NewAlgorandBuffer(c, key, WithJournal(path))
+	assert.Nil(t, err)
+	defer buffer.Close()
+
+	d, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "Vitality", d["1000"])
+	assert.Len(t, buffer.journal.Pending(), 0)
+}
+
+// A pending put must not override a key that a later, confirmed write changed
+func TestJournal_SupersededKeysAreSkipped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "siam.journal")
+	key := client.GeneratePrivateKey64()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBuffer(c, key, WithJournal(path))
+	assert.Nil(t, err)
+
+	_, err = buffer.journal.begin(journalPut, buffer.AppId, map[string][]byte{"1000": []byte("old")}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "new"}))
+	assert.Nil(t, buffer.journal.Compact())
+	assert.Nil(t, buffer.Close())
+
+	buffer, err = NewAlgorandBuffer(c, key, WithJournal(path))
+	assert.Nil(t, err)
+	defer buffer.Close()
+
+	d, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "new", d["1000"])
+}
+
+// A delete that was journaled but never sent should be replayed
+// Replayed writes update the checksum, so readers don't see a torn state
+func TestJournal_ReplayUpdatesChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "siam.journal")
+	key := client.GeneratePrivateKey64()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBuffer(c, key, WithJournal(path), WithChecksum())
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "G2"}))
+
+	// the recorded checksum is that of the state before the crash
+	state, _ := buffer.getGlobalState(context.Background())
+	pairs := map[string][]byte{"1001": []byte("OG"), ChecksumKey: state[ChecksumKey]}
+	_, err = buffer.journal.begin(journalPut, buffer.AppId, pairs, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.Close())
+
+	buffer, err = NewAlgorandBuffer(c, key, WithJournal(path), WithChecksum())
+	assert.Nil(t, err)
+	defer buffer.Close()
+
+	d, err := NewReader(c, buffer.AppId).GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1000": "G2", "1001": "OG"}, d)
+	assert.Len(t, buffer.journal.Pending(), 0)
+}
+
+func TestJournal_ReplayPendingDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "siam.journal")
+	key := client.GeneratePrivateKey64()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBuffer(c, key, WithJournal(path))
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "G2", "1001": "OG"}))
+
+	_, err = buffer.journal.begin(journalDelete, buffer.AppId, nil, []string{"1001"})
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.Close())
+
+	buffer, err = NewAlgorandBuffer(c, key, WithJournal(path))
+	assert.Nil(t, err)
+	defer buffer.Close()
+
+	d, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1000": "G2"}, d)
+}
+
+// Writes the node rejected are failed, writes with unknown outcome stay pending
+func TestJournal_RejectedWritesFail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "siam.journal")
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithJournal(path))
+	assert.Nil(t, err)
+	defer buffer.Close()
+
+	c.SetError(true, (*client.AlgorandMock).UpdateGlobals)
+	assert.NotNil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"}))
+	assert.Len(t, buffer.journal.Pending(), 1)
+	c.ClearFunctionErrors()
+
+	// the contract rejects writes of other accounts
+	c.App.Params.Creator = crypto.GenerateAccount().Address.String()
+	err = buffer.PutElements(context.Background(), map[string]string{"1001": "OG"})
+	assert.True(t, client.IsRejected(err))
+	assert.Len(t, buffer.journal.Pending(), 1)
+}
+
+// Signed transactions are recorded before they are sent, and an entry whose
+// transaction was confirmed isn't sent again
+func TestJournal_RecordsSignedTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "siam.journal")
+	acc := crypto.GenerateAccount()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBufferWithSigner(c, client.NewAccountSigner(acc), WithJournal(path))
+	assert.Nil(t, err)
+
+	seq, err := buffer.journal.begin(journalPut, buffer.AppId, map[string][]byte{"1000": []byte("Vitality")}, nil)
+	assert.Nil(t, err)
+	params := types.SuggestedParams{Fee: 1000, FlatFee: true, FirstRoundValid: 1, LastRoundValid: 1000,
+		GenesisHash: make([]byte, 32)}
+	txn, _ := client.GenerateApplicationCallTx(buffer.AppId, buffer.Signer, params, types.NoOpOC)
+	signed, err := buffer.journal.signer(buffer.Signer, seq).SignTransactions(context.Background(), []types.Transaction{txn})
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.Close())
+
+	j, err := OpenJournal(path)
+	assert.Nil(t, err)
+	pending := j.Pending()
+	assert.Nil(t, j.Close())
+	assert.Len(t, pending, 1)
+	assert.Equal(t, signed, pending[0].Signed)
+	assert.Equal(t, []string{crypto.TransactionIDString(txn)}, pending[0].TxIDs)
+
+	c.PendingTXNInfo.ConfirmedRound = 10
+	buffer, err = NewAlgorandBufferWithSigner(c, client.NewAccountSigner(acc), WithJournal(path))
+	assert.Nil(t, err)
+	defer buffer.Close()
+	assert.Len(t, buffer.journal.Pending(), 0)
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Empty(t, d)
+}
+
+// Only the last line may be truncated by a crash, other corrupt lines are errors
+func TestJournal_CorruptLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "siam.journal")
+	j, err := OpenJournal(path)
+	assert.Nil(t, err)
+	_, err = j.begin(journalPut, 1, map[string][]byte{"1000": []byte("Astralis")}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, j.Close())
+
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	_, _ = f.WriteString(`{"seq":2,"op":"pu`)
+	assert.Nil(t, f.Close())
+	j, err = OpenJournal(path)
+	assert.Nil(t, err)
+	assert.Len(t, j.Pending(), 1)
+	assert.Nil(t, j.Close())
+
+	f, _ = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	_, _ = f.WriteString("\n{\"seq\":3,\"status\":\"confirmed\"}\n")
+	assert.Nil(t, f.Close())
+	_, err = OpenJournal(path)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 2 is corrupt")
+}
+
+// Only confirmed intents supersede the keys of earlier ones
+func TestJournal_FailedWritesDontSupersede(t *testing.T) {
+	j, err := OpenJournal(filepath.Join(t.TempDir(), "siam.journal"))
+	assert.Nil(t, err)
+	defer j.Close()
+
+	first, _ := j.begin(journalPut, 1, map[string][]byte{"1000": []byte("old")}, nil)
+	failed, _ := j.begin(journalPut, 1, map[string][]byte{"1000": []byte("new")}, nil)
+	assert.Nil(t, j.finish(failed, JournalFailed, nil))
+	open, _ := j.begin(journalDelete, 1, nil, []string{"1000"})
+	pending := j.Pending()
+	assert.Len(t, pending, 2)
+	assert.Equal(t, map[string][]byte{"1000": []byte("old")}, pending[0].Pairs)
+	assert.Equal(t, []string{"1000"}, pending[1].Keys)
+
+	assert.Nil(t, j.finish(open, JournalConfirmed, nil))
+	pending = j.Pending()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, first, pending[0].Seq)
+	assert.Empty(t, pending[0].Pairs)
+}
+
+// Replayed puts that don't fit anymore are abandoned instead of failing the startup
+func TestJournal_ReplayCapacity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "siam.journal")
+	key := client.GeneratePrivateKey64()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBuffer(c, key, WithJournal(path))
+	assert.Nil(t, err)
+	data := make(map[string]string)
+	for i := 0; i < client.GlobalBytes; i++ {
+		data[strconv.Itoa(i)] = "OG"
+	}
+	assert.Nil(t, buffer.PutElements(context.Background(), data))
+	_, err = buffer.journal.begin(journalPut, buffer.AppId, map[string][]byte{"1000": []byte("Vitality")}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.Close())
+
+	var results []WriteResult
+	buffer, err = NewAlgorandBuffer(c, key, WithJournal(path), WithWriteHook(func(r WriteResult) { results = append(results, r) }))
+	assert.Nil(t, err)
+	defer buffer.Close()
+	assert.Empty(t, buffer.journal.Pending())
+	assert.Len(t, results, 1)
+	assert.IsType(t, &ErrCapacityExceeded{}, results[0].Err)
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.NotContains(t, d, "1000")
+}
@@ -0,0 +1,312 @@
+package siam
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/mnemonic"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// KeySource provides the private key of the target account. Use it with
+// NewAlgorandBufferWithKey.
+type KeySource interface {
+	PrivateKey() (ed25519.PrivateKey, error)
+}
+
+// Base64Key is a base64 encoded private key, the format of client.EnvPrivateKey.
+type Base64Key string
+
+func (k Base64Key) PrivateKey() (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(string(k))
+	if err != nil {
+		return nil, fmt.Errorf("private key is not valid base64: %s", err)
+	}
+	if len(b) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("private key must have %d bytes, got %d", ed25519.PrivateKeySize, len(b))
+	}
+	return b, nil
+}
+
+// MnemonicKey is a 25-word Algorand mnemonic, as exported by wallets.
+type MnemonicKey string
+
+func (k MnemonicKey) PrivateKey() (ed25519.PrivateKey, error) {
+	sk, err := mnemonic.ToPrivateKey(strings.Join(strings.Fields(string(k)), " "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %s", err)
+	}
+	return sk, nil
+}
+
+// KeyFile is the path of a file holding a base64 encoded private key or a mnemonic.
+// The file must not be accessible by group or others (e.g. mode 0600), otherwise
+// *ErrInsecureKeyFile is returned. The permission check is skipped on Windows.
+type KeyFile string
+
+func (k KeyFile) PrivateKey() (ed25519.PrivateKey, error) {
+	path := string(k)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, &ErrInsecureKeyFile{Path: path, Mode: info.Mode().Perm()}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content := strings.TrimSpace(string(b))
+	if len(strings.Fields(content)) > 1 {
+		return MnemonicKey(content).PrivateKey()
+	}
+	return Base64Key(content).PrivateKey()
+}
+
+// Keystore is a password-encrypted keystore file, created with WriteKeystore.
+type Keystore struct {
+	Path     string
+	Password []byte
+}
+
+func (k Keystore) PrivateKey() (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(k.Path)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptKeystore(b, k.Password)
+}
+
+// keystoreVersion is the version of the keystore format.
+const keystoreVersion = 1
+
+// Parameters of the keystore KDF. N = 2^15 takes about 100ms on current hardware.
+const (
+	keystoreScryptN = 1 << 15
+	keystoreScryptR = 8
+	keystoreScryptP = 1
+)
+
+// Bounds of the KDF parameters of keystores that are decrypted. They keep a crafted
+// keystore from using weak parameters, or from exhausting memory or CPU.
+const (
+	minScryptN      = 1 << 10
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 256 << 20
+	minScryptSalt   = 16
+)
+
+// keystoreFile is the JSON format of a keystore. The ed25519 seed of the key is
+// encrypted with AES-256-GCM, using a key derived from the password with scrypt. The
+// address is authenticated as additional data.
+type keystoreFile struct {
+	Version    int       `json:"version"`
+	Address    string    `json:"address"`
+	KDF        scryptKDF `json:"kdf"`
+	Cipher     string    `json:"cipher"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+type scryptKDF struct {
+	Name string `json:"name"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+}
+
+// check returns an error if the parameters are outside the accepted bounds.
+func (kdf scryptKDF) check() error {
+	n, r, p := kdf.N, kdf.R, kdf.P
+	if n < minScryptN || n > maxScryptN || n&(n-1) != 0 {
+		return fmt.Errorf("invalid keystore: scrypt n must be a power of 2 between %d and %d, got %d", minScryptN, maxScryptN, n)
+	}
+	if r < 1 || r > maxScryptR {
+		return fmt.Errorf("invalid keystore: scrypt r must be between 1 and %d, got %d", maxScryptR, r)
+	}
+	if p < 1 || p > maxScryptP {
+		return fmt.Errorf("invalid keystore: scrypt p must be between 1 and %d, got %d", maxScryptP, p)
+	}
+	if 128*n*r > maxScryptMemory {
+		return fmt.Errorf("invalid keystore: scrypt parameters need more than %d MiB", maxScryptMemory>>20)
+	}
+	if len(kdf.Salt) < minScryptSalt {
+		return fmt.Errorf("invalid keystore: salt must have at least %d bytes", minScryptSalt)
+	}
+	return nil
+}
+
+// EncryptKeystore encrypts a private key with a password, in the format read by
+// DecryptKeystore and Keystore.
+func EncryptKeystore(sk ed25519.PrivateKey, password []byte) ([]byte, error) {
+	acc, err := crypto.AccountFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	ks := keystoreFile{
+		Version: keystoreVersion,
+		Address: acc.Address.String(),
+		KDF:     scryptKDF{Name: "scrypt", N: keystoreScryptN, R: keystoreScryptR, P: keystoreScryptP, Salt: make([]byte, 32)},
+		Cipher:  "aes-256-gcm",
+	}
+	if _, err = rand.Read(ks.KDF.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := ks.aead(password)
+	if err != nil {
+		return nil, err
+	}
+	ks.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(ks.Nonce); err != nil {
+		return nil, err
+	}
+	ks.Ciphertext = aead.Seal(nil, ks.Nonce, sk.Seed(), []byte(ks.Address))
+	return json.MarshalIndent(ks, "", "  ")
+}
+
+// DecryptKeystore decrypts a keystore created by EncryptKeystore.
+func DecryptKeystore(data []byte, password []byte) (ed25519.PrivateKey, error) {
+	var ks keystoreFile
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("invalid keystore: %s", err)
+	}
+	if ks.Version != keystoreVersion || ks.KDF.Name != "scrypt" || ks.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported keystore version %d (%s, %s)", ks.Version, ks.KDF.Name, ks.Cipher)
+	}
+	aead, err := ks.aead(password)
+	if err != nil {
+		return nil, err
+	}
+	if len(ks.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid keystore: bad nonce")
+	}
+	seed, err := aead.Open(nil, ks.Nonce, ks.Ciphertext, []byte(ks.Address))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("wrong keystore password, or the keystore is corrupted")
+	}
+	sk := ed25519.NewKeyFromSeed(seed)
+	acc, _ := crypto.AccountFromPrivateKey(sk)
+	if acc.Address.String() != ks.Address {
+		return nil, errors.New("keystore address doesn't match its key")
+	}
+	return sk, nil
+}
+
+// aead derives the key of the keystore from the password.
+func (ks *keystoreFile) aead(password []byte) (cipher.AEAD, error) {
+	if err := ks.KDF.check(); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(password, ks.KDF.Salt, ks.KDF.N, ks.KDF.R, ks.KDF.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// WriteKeystore encrypts a private key with a password and writes it to path, with
+// mode 0600. Existing files are not overwritten.
+func WriteKeystore(path string, sk ed25519.PrivateKey, password []byte) error {
+	b, err := EncryptKeystore(sk, password)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// envKeySource returns the key source configured by the environment. Key files and
+// keystores take precedence over client.EnvPrivateKey.
+func envKeySource() (KeySource, error) {
+	if path := os.Getenv(client.EnvKeyFile); path != "" {
+		return KeyFile(path), nil
+	}
+	if path := os.Getenv(client.EnvKeystore); path != "" {
+		pwFile := os.Getenv(client.EnvKeystorePasswordFile)
+		if pwFile == "" {
+			return nil, fmt.Errorf("%s requires %s", client.EnvKeystore, client.EnvKeystorePasswordFile)
+		}
+		password, err := ReadPasswordFile(pwFile)
+		if err != nil {
+			return nil, err
+		}
+		return Keystore{Path: path, Password: password}, nil
+	}
+	return Base64Key(os.Getenv(client.EnvPrivateKey)), nil
+}
+
+// ReadPasswordFile reads a keystore password from a file, without the trailing
+// newline. The file has the same permission requirements as a KeyFile.
+func ReadPasswordFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, &ErrInsecureKeyFile{Path: path, Mode: info.Mode().Perm()}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+// KeyFormat encodes a private key for PrintNewAccount.
+type KeyFormat struct {
+	// Name labels the output.
+	Name   string
+	Encode func(sk ed25519.PrivateKey) (string, error)
+}
+
+// FormatBase64 prints the base64 encoded key, for client.EnvPrivateKey or a KeyFile.
+var FormatBase64 = KeyFormat{Name: "Private Key", Encode: func(sk ed25519.PrivateKey) (string, error) {
+	return base64.StdEncoding.EncodeToString(sk), nil
+}}
+
+// FormatMnemonic prints the 25-word mnemonic, for MnemonicKey or a KeyFile.
+var FormatMnemonic = KeyFormat{Name: "Mnemonic", Encode: func(sk ed25519.PrivateKey) (string, error) {
+	return mnemonic.FromPrivateKey(sk)
+}}
+
+// FormatKeystore prints a keystore encrypted with the given password. Save the output
+// as file for Keystore.
+func FormatKeystore(password []byte) KeyFormat {
+	return KeyFormat{Name: "Keystore", Encode: func(sk ed25519.PrivateKey) (string, error) {
+		b, err := EncryptKeystore(sk, password)
+		if err != nil {
+			return "", err
+		}
+		var compact bytes.Buffer
+		err = json.Compact(&compact, b)
+		return compact.String(), err
+	}}
+}
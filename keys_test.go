@@ -0,0 +1,177 @@
+//go:build unit
+
+package siam
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMnemonicKey(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	m, err := FormatMnemonic.Encode(acc.PrivateKey)
+	assert.Nil(t, err)
+
+	// extra whitespace, e.g. from copying line-wrapped words, is ignored
+	sk, err := MnemonicKey("  " + m + "\n").PrivateKey()
+	assert.Nil(t, err)
+	assert.Equal(t, acc.PrivateKey, sk)
+
+	_, err = MnemonicKey("abandon abandon").PrivateKey()
+	assert.NotNil(t, err)
+}
+
+func TestKeyFile(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	m, _ := FormatMnemonic.Encode(acc.PrivateKey)
+	for _, content := range []string{base64.StdEncoding.EncodeToString(acc.PrivateKey) + "\n", m} {
+		path := writeConfig(t, "key", content)
+		sk, err := KeyFile(path).PrivateKey()
+		assert.Nil(t, err)
+		assert.Equal(t, acc.PrivateKey, sk)
+
+		assert.Nil(t, os.Chmod(path, 0644))
+		_, err = KeyFile(path).PrivateKey()
+		assert.IsType(t, &ErrInsecureKeyFile{}, err)
+	}
+
+	_, err := KeyFile(filepath.Join(t.TempDir(), "missing")).PrivateKey()
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestKeystore(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	path := filepath.Join(t.TempDir(), "oracle.keystore")
+	assert.Nil(t, WriteKeystore(path, acc.PrivateKey, []byte("hunter2")))
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	sk, err := Keystore{Path: path, Password: []byte("hunter2")}.PrivateKey()
+	assert.Nil(t, err)
+	assert.Equal(t, acc.PrivateKey, sk)
+
+	_, err = Keystore{Path: path, Password: []byte("hunter3")}.PrivateKey()
+	assert.EqualError(t, err, "wrong keystore password, or the keystore is corrupted")
+
+	// existing keystores are never overwritten
+	assert.NotNil(t, WriteKeystore(path, crypto.GenerateAccount().PrivateKey, []byte("hunter2")))
+	sk, _ = Keystore{Path: path, Password: []byte("hunter2")}.PrivateKey()
+	assert.Equal(t, acc.PrivateKey, sk)
+}
+
+func TestKeystore_KDFBounds(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	b, err := EncryptKeystore(acc.PrivateKey, []byte("pw"))
+	assert.Nil(t, err)
+	var ks map[string]interface{}
+	assert.Nil(t, json.Unmarshal(b, &ks))
+	kdf := ks["kdf"].(map[string]interface{})
+
+	for _, c := range []struct {
+		field string
+		value interface{}
+	}{
+		{"n", 0},
+		{"n", 1000},
+		{"n", 1 << 30},
+		{"r", 0},
+		{"r", 1 << 20},
+		{"p", 0},
+		{"p", 1 << 20},
+		{"n", 1 << 20},
+		{"salt", ""},
+	} {
+		old := kdf[c.field]
+		kdf[c.field] = c.value
+		crafted, _ := json.Marshal(ks)
+		_, err = DecryptKeystore(crafted, []byte("pw"))
+		if assert.NotNil(t, err, "%s = %v", c.field, c.value) {
+			assert.Contains(t, err.Error(), "invalid keystore")
+		}
+		kdf[c.field] = old
+	}
+	crafted, _ := json.Marshal(ks)
+	sk, err := DecryptKeystore(crafted, []byte("pw"))
+	assert.Nil(t, err)
+	assert.Equal(t, ed25519.PrivateKey(acc.PrivateKey), sk)
+}
+
+func TestFormatKeystore(t *testing.T) {
+	acc := crypto.GenerateAccount()
+	encoded, err := FormatKeystore([]byte("pw")).Encode(acc.PrivateKey)
+	assert.Nil(t, err)
+	assert.NotContains(t, encoded, "\n")
+
+	sk, err := DecryptKeystore([]byte(encoded), []byte("pw"))
+	assert.Nil(t, err)
+	assert.Equal(t, ed25519.PrivateKey(acc.PrivateKey), sk)
+}
+
+func TestNewAlgorandBufferFromEnv_KeySources(t *testing.T) {
+	clearEnv(t)
+	acc := crypto.GenerateAccount()
+	dir := t.TempDir()
+	keystore := filepath.Join(dir, "oracle.keystore")
+	assert.Nil(t, WriteKeystore(keystore, acc.PrivateKey, []byte("pw")))
+	pwFile := writeConfig(t, "password", "pw\n")
+
+	t.Setenv(client.EnvKeystore, keystore)
+	_, err := envKeySource()
+	assert.EqualError(t, err, "SIAM_KEYSTORE requires SIAM_KEYSTORE_PASSWORD_FILE")
+
+	t.Setenv(client.EnvKeystorePasswordFile, pwFile)
+	src, err := envKeySource()
+	assert.Nil(t, err)
+	sk, err := src.PrivateKey()
+	assert.Nil(t, err)
+	assert.Equal(t, acc.PrivateKey, sk)
+
+	// key files take precedence
+	t.Setenv(client.EnvKeyFile, writeConfig(t, "key", client.GeneratePrivateKey64()))
+	src, _ = envKeySource()
+	assert.IsType(t, KeyFile(""), src)
+
+	buffer, err := NewAlgorandBufferWithKey(client.CreateAlgorandClientMock("", ""), src)
+	assert.Nil(t, err)
+	expected, _ := src.PrivateKey()
+	owner, _ := crypto.AccountFromPrivateKey(expected)
+	assert.Equal(t, owner.Address, buffer.Signer.Address())
+}
+
+func TestConfigKeySources(t *testing.T) {
+	clearEnv(t)
+	acc := crypto.GenerateAccount()
+	m, _ := FormatMnemonic.Encode(acc.PrivateKey)
+	keyFile := writeConfig(t, "key", m)
+	keystore := filepath.Join(t.TempDir(), "oracle.keystore")
+	assert.Nil(t, WriteKeystore(keystore, acc.PrivateKey, []byte("pw")))
+	pwFile := writeConfig(t, "password", "pw")
+
+	for _, key := range []KeyConfig{
+		{Mnemonic: m},
+		{File: keyFile},
+		{Keystore: keystore, PasswordFile: pwFile},
+	} {
+		cfg := &Config{Key: key}
+		src, err := cfg.KeySource()
+		assert.Nil(t, err)
+		sk, err := src.PrivateKey()
+		assert.Nil(t, err)
+		assert.Equal(t, acc.PrivateKey, sk)
+	}
+
+	_, err := (&Config{Key: KeyConfig{Mnemonic: m, File: keyFile}}).KeySource()
+	assert.EqualError(t, err, "set only one of private_key, env, mnemonic, file, keystore, kmd, remote and multisig")
+	_, err = (&Config{Key: KeyConfig{Keystore: keystore}}).KeySource()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,310 @@
+package siam
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Keys of a Merkle commitment, written by a MerkleBuffer. All values except the root
+// are 8-byte big endian integers.
+const (
+	MerkleRootKey    = "merkle_root"
+	MerkleVersionKey = "merkle_version"
+	MerkleCountKey   = "merkle_count"
+)
+
+// Prefixes of the hashed data of leaves and inner nodes. They make it impossible to
+// pass off an inner node as a leaf.
+const (
+	merkleLeafPrefix byte = 0x00
+	merkleNodePrefix byte = 0x01
+)
+
+// merkleStepSize is the length of a single step of a proof path.
+const merkleStepSize = 1 + sha256.Size
+
+// MerkleCommitment is the on-chain commitment to a dataset of a MerkleBuffer.
+type MerkleCommitment struct {
+	Root    []byte
+	Version uint64
+	Count   uint64
+}
+
+// DecodeCommitment reads a Merkle commitment from the given buffer contents, e.g. the
+// result of Reader.GetBufferRaw. ok is false if the contents hold no commitment.
+func DecodeCommitment(data map[string][]byte) (c MerkleCommitment, ok bool) {
+	c.Root, ok = data[MerkleRootKey]
+	if !ok || len(c.Root) != sha256.Size {
+		return c, false
+	}
+	c.Version, _ = btoi(data[MerkleVersionKey])
+	c.Count, _ = btoi(data[MerkleCountKey])
+	return c, true
+}
+
+// MerkleBuffer keeps a dataset that is too large for an application in a local store,
+// and only publishes a commitment to it: the Merkle root, a dataset version and the
+// number of entries. Consumers receive entries off-chain together with a MerkleProof,
+// and check them against the published root, either with MerkleProof.Verify or
+// on-chain with the TEAL subroutine client.VerifyMerkleTeal.
+//
+// The tree is built over all entries sorted by key. A leaf is
+// sha256(0x00 || len(key) || key || value), an inner node is
+// sha256(0x01 || left || right). If a level has an odd number of nodes, the last node
+// is promoted to the next level unchanged.
+type MerkleBuffer struct {
+	buffer RawBuffer
+	path   string
+
+	mu      sync.Mutex
+	version uint64
+	data    map[string][]byte
+
+	// levels caches the tree, levels[0] are the leaves. nil if the data changed.
+	levels [][][]byte
+	// keys are the sorted keys of data, in leaf order.
+	keys []string
+}
+
+// merkleStore is the content of the local store file.
+type merkleStore struct {
+	Version uint64            `json:"version"`
+	Data    map[string][]byte `json:"data"`
+}
+
+// NewMerkleBuffer creates a MerkleBuffer that publishes its commitment to the given
+// buffer. If path is not empty, the dataset is persisted to this file, and a
+// previously persisted dataset is loaded.
+func NewMerkleBuffer(buffer RawBuffer, path string) (*MerkleBuffer, error) {
+	mb := &MerkleBuffer{buffer: buffer, path: path, data: make(map[string][]byte)}
+	if path == "" {
+		return mb, nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return mb, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s merkleStore
+	if err = json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	mb.version = s.Version
+	if s.Data != nil {
+		mb.data = s.Data
+	}
+	return mb, nil
+}
+
+// Put adds or replaces the given entries, and publishes the new commitment. Keys can
+// have at most 255 bytes.
+func (mb *MerkleBuffer) Put(ctx context.Context, data map[string][]byte) error {
+	for k := range data {
+		if len(k) > 255 {
+			return fmt.Errorf("merkle key %q exceeds 255 bytes", k)
+		}
+	}
+	return mb.update(ctx, func() {
+		for k, v := range data {
+			mb.data[k] = append([]byte(nil), v...)
+		}
+	})
+}
+
+// Delete removes the given entries, and publishes the new commitment.
+func (mb *MerkleBuffer) Delete(ctx context.Context, keys ...string) error {
+	return mb.update(ctx, func() {
+		for _, k := range keys {
+			delete(mb.data, k)
+		}
+	})
+}
+
+// update applies the given change, increments the version, persists the dataset and
+// publishes the commitment.
+func (mb *MerkleBuffer) update(ctx context.Context, change func()) error {
+	mb.mu.Lock()
+	change()
+	mb.version++
+	mb.levels = nil
+	err := mb.persist()
+	mb.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return mb.Commit(ctx)
+}
+
+// Commit publishes the commitment of the current dataset. Put and Delete commit
+// automatically; call Commit to retry after a failed write.
+func (mb *MerkleBuffer) Commit(ctx context.Context) error {
+	c := mb.Commitment()
+	return mb.buffer.PutElementsRaw(ctx, map[string][]byte{
+		MerkleRootKey:    c.Root,
+		MerkleVersionKey: itob(c.Version),
+		MerkleCountKey:   itob(c.Count),
+	})
+}
+
+// Get returns the value of the given key.
+func (mb *MerkleBuffer) Get(key string) ([]byte, bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	v, ok := mb.data[key]
+	return v, ok
+}
+
+// Commitment returns the commitment of the current dataset.
+func (mb *MerkleBuffer) Commitment() MerkleCommitment {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.build()
+	root := mb.levels[len(mb.levels)-1]
+	c := MerkleCommitment{Version: mb.version, Count: uint64(len(mb.keys))}
+	if len(root) == 1 {
+		c.Root = root[0]
+	} else {
+		// the root of the empty tree
+		h := sha256.Sum256(nil)
+		c.Root = h[:]
+	}
+	return c
+}
+
+// Prove returns an inclusion proof for the given key.
+func (mb *MerkleBuffer) Prove(key string) (*MerkleProof, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	value, ok := mb.data[key]
+	if !ok {
+		return nil, fmt.Errorf("key %q doesn't exist", key)
+	}
+	mb.build()
+
+	index := sort.SearchStrings(mb.keys, key)
+	p := &MerkleProof{Key: key, Value: value}
+	for _, level := range mb.levels[:len(mb.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			direction := byte(0)
+			if sibling < index {
+				direction = 1
+			}
+			p.Path = append(p.Path, direction)
+			p.Path = append(p.Path, level[sibling]...)
+		}
+		index /= 2
+	}
+	return p, nil
+}
+
+// build computes the tree levels if they are not cached.
+func (mb *MerkleBuffer) build() {
+	if mb.levels != nil {
+		return
+	}
+	mb.keys = make([]string, 0, len(mb.data))
+	for k := range mb.data {
+		mb.keys = append(mb.keys, k)
+	}
+	sort.Strings(mb.keys)
+
+	level := make([][]byte, len(mb.keys))
+	for i, k := range mb.keys {
+		level[i] = MerkleLeaf(k, mb.data[k])
+	}
+	mb.levels = [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, merkleNode(level[i], level[i+1]))
+			}
+		}
+		mb.levels = append(mb.levels, next)
+		level = next
+	}
+}
+
+// persist atomically writes the dataset to the store file.
+func (mb *MerkleBuffer) persist() error {
+	if mb.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(merkleStore{Version: mb.version, Data: mb.data})
+	if err != nil {
+		return err
+	}
+	tmp := mb.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, mb.path)
+}
+
+// MerkleProof proves that a key-value pair is part of a committed dataset.
+type MerkleProof struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+
+	// Path contains one step per tree level, from the leaf to the root. Every step is
+	// a direction byte (0 if the sibling is on the right, 1 if it is on the left)
+	// followed by the 32 byte sibling hash. Levels where the node has no sibling are
+	// skipped. The path can be passed as-is to the TEAL subroutine.
+	Path []byte `json:"path"`
+}
+
+// Verify returns true if the proof is valid for the given root.
+func (p *MerkleProof) Verify(root []byte) bool {
+	return VerifyMerkleProof(root, p.Key, p.Value, p.Path)
+}
+
+// VerifyMerkleProof returns true if the key-value pair is part of the dataset with the
+// given root, according to the proof path. See MerkleProof.
+func VerifyMerkleProof(root []byte, key string, value []byte, path []byte) bool {
+	if len(key) > 255 || len(path)%merkleStepSize != 0 {
+		return false
+	}
+	h := MerkleLeaf(key, value)
+	for i := 0; i < len(path); i += merkleStepSize {
+		sibling := path[i+1 : i+merkleStepSize]
+		switch path[i] {
+		case 0:
+			h = merkleNode(h, sibling)
+		case 1:
+			h = merkleNode(sibling, h)
+		default:
+			return false
+		}
+	}
+	return bytes.Equal(h, root)
+}
+
+// MerkleLeaf returns the leaf hash of a key-value pair:
+// sha256(0x00 || len(key) || key || value).
+func MerkleLeaf(key string, value []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix, byte(len(key))})
+	h.Write([]byte(key))
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+// merkleNode returns the hash of an inner node: sha256(0x01 || left || right).
+func merkleNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
@@ -0,0 +1,79 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleBuffer_Proofs(t *testing.T) {
+	buffer, _ := NewAlgorandBuffer(client.CreateAlgorandClientMock("", ""), client.GeneratePrivateKey64())
+	mb, _ := NewMerkleBuffer(buffer, "")
+
+	// odd and even sizes exercise promoted nodes
+	for n := 1; n <= 9; n++ {
+		assert.Nil(t, mb.Put(context.Background(), map[string][]byte{"m" + strconv.Itoa(n): []byte(strconv.Itoa(n * n))}))
+		root := mb.Commitment().Root
+		for i := 1; i <= n; i++ {
+			p, err := mb.Prove("m" + strconv.Itoa(i))
+			assert.Nil(t, err)
+			assert.True(t, p.Verify(root), "n=%d, i=%d", n, i)
+		}
+	}
+
+	p, _ := mb.Prove("m3")
+	root := mb.Commitment().Root
+	p.Value = []byte("10")
+	assert.False(t, p.Verify(root))
+	p.Value = []byte("9")
+	p.Path[0] = 2
+	assert.False(t, p.Verify(root))
+	p.Path[0] = 0
+	assert.False(t, VerifyMerkleProof(root, "m3", []byte("9"), p.Path[:len(p.Path)-1]))
+
+	_, err := mb.Prove("missing")
+	assert.NotNil(t, err)
+}
+
+func TestMerkleBuffer_Commitment(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	path := filepath.Join(t.TempDir(), "dataset.json")
+	ns, _ := buffer.Namespace("cs/")
+	mb, err := NewMerkleBuffer(ns, path)
+	assert.Nil(t, err)
+
+	data := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		data[strconv.Itoa(i)] = []byte("match result " + strconv.Itoa(i))
+	}
+	assert.Nil(t, mb.Put(context.Background(), data))
+	assert.Nil(t, mb.Delete(context.Background(), "0"))
+
+	// only the commitment is written on-chain
+	state, _ := NewReader(c, buffer.AppId).GetBufferRaw(context.Background())
+	assert.Len(t, state, 3)
+	committed, ok := DecodeCommitment(map[string][]byte{
+		MerkleRootKey:    state["cs/"+MerkleRootKey],
+		MerkleVersionKey: state["cs/"+MerkleVersionKey],
+		MerkleCountKey:   state["cs/"+MerkleCountKey],
+	})
+	assert.True(t, ok)
+	assert.EqualValues(t, 2, committed.Version)
+	assert.EqualValues(t, 999, committed.Count)
+	assert.Equal(t, mb.Commitment(), committed)
+
+	// the dataset survives restarts
+	restored, err := NewMerkleBuffer(ns, path)
+	assert.Nil(t, err)
+	assert.Equal(t, committed, restored.Commitment())
+	p, err := restored.Prove("500")
+	assert.Nil(t, err)
+	assert.True(t, p.Verify(committed.Root))
+}
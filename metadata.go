@@ -0,0 +1,224 @@
+package siam
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// ReservedPrefix is the prefix of global keys that are maintained by the buffer
+// itself. Reserved keys can't be written or deleted by users, and are excluded from
+// GetBuffer and from the user capacity.
+const ReservedPrefix = "_siam_"
+
+// Reserved metadata keys. All values are 8-byte big endian integers, so that they can
+// be read in TEAL with btoi.
+const (
+	// MetaVersionKey holds the client.ContractVersion of the application.
+	MetaVersionKey = ReservedPrefix + "v"
+	// MetaRoundKey holds the last round the buffer observed before its last write.
+	MetaRoundKey = ReservedPrefix + "rnd"
+	// MetaTimestampKey holds the unix timestamp of the last write.
+	MetaTimestampKey = ReservedPrefix + "ts"
+	// MetaHeartbeatKey holds the unix timestamp of the last heartbeat.
+	MetaHeartbeatKey = ReservedPrefix + "hb"
+)
+
+// metadataKeys are the reserved keys that WithMetadata maintains.
+var metadataKeys = []string{MetaVersionKey, MetaRoundKey, MetaTimestampKey, MetaHeartbeatKey}
+
+// WithMetadata makes the buffer maintain the reserved metadata keys. The contract
+// version, last-write round and last-write timestamp are updated with the last
+// transaction of every write. The heartbeat is refreshed by SpawnHeartbeatRoutine.
+// The metadata keys occupy 4 slots of the application.
+func WithMetadata() BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		ab.metadata = true
+		return nil
+	}
+}
+
+// Metadata describes the liveness of a Siam application, as read from its reserved
+// metadata keys. Fields are zero if the corresponding key doesn't exist.
+type Metadata struct {
+	Version        uint64
+	LastWriteRound uint64
+	LastWrite      time.Time
+	Heartbeat      time.Time
+}
+
+// LastActivity returns the latest of LastWrite and Heartbeat.
+func (m Metadata) LastActivity() time.Time {
+	if m.Heartbeat.After(m.LastWrite) {
+		return m.Heartbeat
+	}
+	return m.LastWrite
+}
+
+// Fresh returns true if the oracle wrote data or a heartbeat within the given duration.
+func (m Metadata) Fresh(maxAge time.Duration) bool {
+	last := m.LastActivity()
+	return !last.IsZero() && time.Since(last) <= maxAge
+}
+
+// decodeMetadata reads the metadata from the reserved keys of the given state.
+func decodeMetadata(state map[string][]byte) Metadata {
+	var m Metadata
+	m.Version, _ = btoi(state[MetaVersionKey])
+	m.LastWriteRound, _ = btoi(state[MetaRoundKey])
+	if ts, ok := btoi(state[MetaTimestampKey]); ok {
+		m.LastWrite = time.Unix(int64(ts), 0)
+	}
+	if ts, ok := btoi(state[MetaHeartbeatKey]); ok {
+		m.Heartbeat = time.Unix(int64(ts), 0)
+	}
+	return m
+}
+
+// Metadata returns the metadata of the buffer's application.
+func (ab *AlgorandBuffer) Metadata(ctx context.Context) (Metadata, error) {
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return decodeMetadata(state), nil
+}
+
+// Heartbeat refreshes the heartbeat key, signalling consumers that the oracle is alive
+// even if no data changed. Returns ErrMetadataDisabled if the buffer doesn't maintain
+// metadata, see WithMetadata.
+func (ab *AlgorandBuffer) Heartbeat(ctx context.Context) error {
+	pairs := map[string][]byte{
+		MetaVersionKey:   itob(client.ContractVersion),
+		MetaHeartbeatKey: itob(uint64(time.Now().Unix())),
+	}
+	if !ab.metadata || ab.writerPrefix != "" {
+		return ab.writeFailed(nil, pairs, ErrMetadataDisabled)
+	}
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return ab.writeFailed(nil, pairs, err)
+	}
+	if err = checkCapacity(state, getKeysByte(pairs), nil); err != nil {
+		return ab.writeFailed(nil, pairs, err)
+	}
+	return ab.writeTxn(nil, pairs)
+}
+
+// SpawnHeartbeatRoutine starts a goroutine that calls Heartbeat in the given interval.
+// Errors are ignored and retried in the next interval. The routine exits when ctx is
+// cancelled. Use the returned WaitGroup to wait for the exit.
+func (ab *AlgorandBuffer) SpawnHeartbeatRoutine(ctx context.Context, interval time.Duration) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			hbCtx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+			_ = ab.Heartbeat(hbCtx)
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return wg
+}
+
+// trailer returns the reserved pairs that are written with the last transaction of
+// the given write. Returns nil if the buffer maintains neither metadata nor a checksum.
+func (ab *AlgorandBuffer) trailer(ctx context.Context, txns []txnOp) (map[string][]byte, error) {
+	pairs := make(map[string][]byte)
+	if ab.metadata {
+		sctx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+		status, err := ab.Client.Status(sctx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		pairs[MetaVersionKey] = itob(client.ContractVersion)
+		pairs[MetaRoundKey] = itob(status.LastRound)
+		pairs[MetaTimestampKey] = itob(uint64(time.Now().Unix()))
+	}
+	if ab.checksum {
+		state, err := ab.getGlobalState(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, prefix := range parseWriters(state) {
+			if prefix != "" {
+				return nil, ErrWriterChecksum
+			}
+		}
+		for _, op := range txns {
+			for _, k := range op.del {
+				delete(state, k)
+			}
+			for k, v := range op.put {
+				state[k] = v
+			}
+		}
+		pairs[ChecksumKey] = StateChecksum(state)
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	return pairs, nil
+}
+
+// reservedKeys returns the reserved keys this buffer maintains.
+func (ab *AlgorandBuffer) reservedKeys() []string {
+	var keys []string
+	if ab.metadata {
+		keys = append(keys, metadataKeys...)
+	}
+	if ab.checksum {
+		keys = append(keys, ChecksumKey)
+	}
+	return keys
+}
+
+// withReserved returns a copy of the given state, which additionally contains all
+// reserved keys the buffer maintains. Use it to make sure that capacity checks keep
+// slots free for reserved keys that have not been written yet.
+func (ab *AlgorandBuffer) withReserved(state map[string][]byte) map[string][]byte {
+	m := make(map[string][]byte, len(state)+len(metadataKeys)+1)
+	for k, v := range state {
+		m[k] = v
+	}
+	for _, k := range ab.reservedKeys() {
+		if _, ok := m[k]; !ok {
+			m[k] = nil
+		}
+	}
+	return m
+}
+
+// isReserved returns true if the key starts with the ReservedPrefix.
+func isReserved(key string) bool {
+	return strings.HasPrefix(key, ReservedPrefix)
+}
+
+// itob encodes an integer as 8-byte big endian, like the TEAL opcode of the same name.
+func itob(i uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, i)
+	return b
+}
+
+// btoi decodes an 8-byte big endian integer. ok is false if b has the wrong length.
+func btoi(b []byte) (i uint64, ok bool) {
+	if len(b) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(b), true
+}
@@ -0,0 +1,105 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlgorandBuffer_Metadata(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.NodeStatus.LastRound = 4242
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithMetadata())
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"}))
+
+	// reserved keys are hidden from users
+	data, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1000": "Astralis"}, data)
+
+	m, err := buffer.Metadata(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, client.ContractVersion, m.Version)
+	assert.EqualValues(t, 4242, m.LastWriteRound)
+	assert.True(t, m.Heartbeat.IsZero())
+	assert.True(t, m.Fresh(time.Minute))
+
+	assert.Nil(t, buffer.Heartbeat(context.Background()))
+	m, _ = buffer.Metadata(context.Background())
+	assert.False(t, m.Heartbeat.IsZero())
+}
+
+func TestAlgorandBuffer_HeartbeatRequiresMetadata(t *testing.T) {
+	key := client.GeneratePrivateKey64()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, key)
+	assert.Equal(t, ErrMetadataDisabled, buffer.Heartbeat(context.Background()))
+	state, _ := buffer.getGlobalState(context.Background())
+	assert.Empty(t, state)
+
+	// an application filled before metadata was enabled has no slot for the heartbeat
+	data := make(map[string]string, client.GlobalBytes)
+	for i := 0; i < client.GlobalBytes; i++ {
+		data["k"+strconv.Itoa(100+i)] = "v"
+	}
+	assert.Nil(t, buffer.PutElements(context.Background(), data))
+	buffer, _ = NewAlgorandBuffer(c, key, WithMetadata())
+	assert.IsType(t, &ErrCapacityExceeded{}, buffer.Heartbeat(context.Background()))
+}
+
+func TestAlgorandBuffer_ReservedKeysRejected(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithMetadata())
+
+	err := buffer.PutElements(context.Background(), map[string]string{MetaHeartbeatKey: "0"})
+	assert.IsType(t, &ErrReservedKey{}, err)
+	err = buffer.DeleteElements(context.Background(), MetaVersionKey)
+	assert.IsType(t, &ErrReservedKey{}, err)
+}
+
+// Reserved keys must keep their slots, even before they have been written
+func TestAlgorandBuffer_MetadataCapacity(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithMetadata())
+
+	info, err := buffer.Capacity(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, len(metadataKeys), info.ReservedSlots)
+	assert.Equal(t, client.GlobalBytes-len(metadataKeys), info.FreeSlots)
+
+	data := make(map[string]string, info.FreeSlots)
+	for i := 0; i < info.FreeSlots; i++ {
+		data["k"+strconv.Itoa(100+i)] = "v"
+	}
+	assert.Nil(t, buffer.PutElements(context.Background(), data))
+	err = buffer.PutElements(context.Background(), map[string]string{"x": "y"})
+	assert.IsType(t, &ErrCapacityExceeded{}, err)
+
+	// the heartbeat still fits into its reserved slot
+	assert.Nil(t, buffer.Heartbeat(context.Background()))
+}
+
+func TestReader_Fresh(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithMetadata())
+	r := NewReader(c, buffer.AppId)
+
+	fresh, err := r.Fresh(context.Background(), time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, fresh)
+
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"}))
+	fresh, err = r.Fresh(context.Background(), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, fresh)
+
+	data, err := r.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1000": "Astralis"}, data)
+}
@@ -0,0 +1,164 @@
+package siam
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/algorand/go-algorand-sdk/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/types"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// SubmitSigned sends transactions that were signed outside the buffer, e.g. the
+// Transactions of a *client.ErrPartiallySigned after the remaining key holders signed
+// them offline. Several transactions are sent as one group. It blocks until the
+// transactions are confirmed, or ctx is done. Confirmed writes are reported to the
+// write hooks, and finish their unsigned journal entries.
+//
+// Only NoOp calls of the buffer's application that write keys are accepted, and
+// multisig transactions must carry enough signatures. Signed transactions don't
+// change when the state does, so a checksum they write can be stale; then
+// ErrStaleChecksum is returned and nothing is sent. Other trailer keys, like the
+// metadata of WithMetadata, are written as they were signed.
+func (ab *AlgorandBuffer) SubmitSigned(ctx context.Context, signed ...[]byte) error {
+	if len(signed) == 0 {
+		return errors.New("no transactions to submit")
+	}
+	ops := make([]txnOp, len(signed))
+	for i, b := range signed {
+		var stx types.SignedTxn
+		if err := msgpack.Decode(b, &stx); err != nil {
+			return fmt.Errorf("invalid signed transaction %d: %s", i, err)
+		}
+		if stx.Txn.Type != types.ApplicationCallTx || uint64(stx.Txn.ApplicationID) != ab.AppId {
+			return fmt.Errorf("transaction %d is not a call of application %d", i, ab.AppId)
+		}
+		if stx.Txn.OnCompletion != types.NoOpOC {
+			return fmt.Errorf("transaction %d is not a NoOp call", i)
+		}
+		if !stx.Msig.Blank() && !client.MultisigComplete(b) {
+			return fmt.Errorf("transaction %d doesn't have enough multisig signatures", i)
+		}
+		op, err := decodeWrite(stx.Txn)
+		if err != nil {
+			return fmt.Errorf("transaction %d: %s", i, err)
+		}
+		ops[i] = op
+	}
+	if err := ab.checkSignedChecksum(ctx, ops); err != nil {
+		return err
+	}
+
+	err := ab.sendSigned(ctx, signed)
+	for _, op := range ops {
+		ab.runWriteHooks(op.del, op.put, err)
+	}
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		ab.touchKeys(op.del, op.put)
+	}
+	return ab.journal.finishSigned(signed, JournalConfirmed, nil)
+}
+
+// sendSigned sends the signed transactions as one group, and waits until they are
+// confirmed.
+func (ab *AlgorandBuffer) sendSigned(ctx context.Context, signed [][]byte) error {
+	status, err := ab.Client.Status(ctx)
+	if err != nil {
+		return err
+	}
+	txid, err := ab.Client.SendRawTransaction(bytes.Join(signed, nil), ctx)
+	if err != nil {
+		return err
+	}
+	for round := status.LastRound + 1; ; round++ {
+		info, _, err := ab.Client.PendingTransactionInformation(txid, ctx)
+		if err != nil {
+			return err
+		}
+		if info.PoolError != "" {
+			return fmt.Errorf("transaction %s was rejected: %s", txid, info.PoolError)
+		}
+		if info.ConfirmedRound > 0 {
+			return nil
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if _, err = ab.Client.StatusAfterBlock(round, ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// checkSignedChecksum returns ErrStaleChecksum if the given writes store a checksum
+// that doesn't match the state they produce.
+func (ab *AlgorandBuffer) checkSignedChecksum(ctx context.Context, ops []txnOp) error {
+	var sum []byte
+	for _, op := range ops {
+		if v, ok := op.put[ChecksumKey]; ok {
+			sum = v
+		}
+	}
+	if sum == nil {
+		return nil
+	}
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		for _, k := range op.del {
+			delete(state, k)
+		}
+		for k, v := range op.put {
+			state[k] = v
+		}
+	}
+	if !bytes.Equal(sum, StateChecksum(state)) {
+		return ErrStaleChecksum
+	}
+	return nil
+}
+
+// decodeWrite returns the keys an application call deletes and the pairs it stores,
+// according to the notes of the approval.teal contract.
+func decodeWrite(txn types.Transaction) (txnOp, error) {
+	args := txn.ApplicationArgs
+	op := txnOp{put: make(map[string][]byte)}
+	switch string(txn.Note) {
+	case journalPut:
+	case journalDelete:
+		for _, k := range args {
+			op.del = append(op.del, string(k))
+		}
+		return op, nil
+	case journalUpdate:
+		if len(args) == 0 || len(args[0]) != 8 {
+			return op, errors.New("update without number of deleted keys")
+		}
+		n := binary.BigEndian.Uint64(args[0])
+		if n > uint64(len(args)-1) {
+			return op, errors.New("update deletes more keys than it has arguments")
+		}
+		for _, k := range args[1 : 1+n] {
+			op.del = append(op.del, string(k))
+		}
+		args = args[1+n:]
+	default:
+		return op, fmt.Errorf("note %q is not a write of the buffer", txn.Note)
+	}
+	if len(args)%2 != 0 {
+		return op, errors.New("key without value")
+	}
+	for i := 0; i < len(args); i += 2 {
+		op.put[string(args[i])] = args[i+1]
+	}
+	return op, nil
+}
@@ -0,0 +1,194 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/future"
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubmitSigned(t *testing.T) {
+	a, b := crypto.GenerateAccount(), crypto.GenerateAccount()
+	ma, _ := crypto.MultisigAccountWithParams(1, 2, []types.Address{a.Address, b.Address})
+	signer, err := client.NewMultisigSigner(ma, client.NewAccountSigner(a))
+	assert.Nil(t, err)
+
+	c := client.CreateAlgorandClientMock("", "")
+	var results []WriteResult
+	buffer, err := NewAlgorandBufferWithSigner(signingMock{c}, signer,
+		WithJournal(filepath.Join(t.TempDir(), "siam.journal")),
+		WithWriteHook(func(r WriteResult) { results = append(results, r) }))
+	assert.Nil(t, err)
+	addr, _ := ma.Address()
+	assert.Equal(t, addr, buffer.Signer.Address())
+	ctx := context.Background()
+
+	// the partially signed write stays in the journal, but isn't replayed
+	err = buffer.PutElements(ctx, map[string]string{"1000": "Astralis"})
+	var partial *client.ErrPartiallySigned
+	assert.True(t, errors.As(err, &partial))
+	pending := buffer.journal.Pending()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, JournalUnsigned, pending[0].Status)
+	assert.Nil(t, buffer.replayJournal(ctx))
+	assert.Len(t, buffer.journal.Pending(), 1)
+	assert.NotContains(t, buffer.keyMeta, "1000")
+
+	assert.EqualError(t, buffer.SubmitSigned(ctx, partial.Transactions...),
+		"transaction 0 doesn't have enough multisig signatures")
+
+	signed, err := client.CosignTransaction(ctx, client.NewAccountSigner(b), partial.Transactions[0])
+	assert.Nil(t, err)
+	c.PendingTXNInfo.PoolError = "overspend"
+	assert.NotNil(t, buffer.SubmitSigned(ctx, signed))
+	assert.Len(t, buffer.journal.Pending(), 1)
+
+	c.PendingTXNInfo.PoolError = ""
+	c.PendingTXNInfo.ConfirmedRound = 10
+	results = nil
+	assert.Nil(t, buffer.SubmitSigned(ctx, signed))
+	assert.Len(t, results, 1)
+	assert.Equal(t, []string{"1000"}, results[0].Put)
+	assert.Nil(t, results[0].Err)
+	assert.Empty(t, buffer.journal.Pending())
+	assert.Contains(t, buffer.keyMeta, "1000")
+
+	params := types.SuggestedParams{Fee: 1000, FlatFee: true, FirstRoundValid: 1, LastRoundValid: 1000,
+		GenesisHash: make([]byte, 32)}
+	for msg, txn := range map[string]types.Transaction{
+		"transaction 0 is not a call of application 4512":       writeTxnOf(buffer.AppId+1, addr, params, "put"),
+		"transaction 0: note \"\" is not a write of the buffer": writeTxnOf(buffer.AppId, addr, params, ""),
+	} {
+		_, err = signer.SignTransactions(ctx, []types.Transaction{txn})
+		errors.As(err, &partial)
+		signed, _ = client.CosignTransaction(ctx, client.NewAccountSigner(b), partial.Transactions[0])
+		assert.EqualError(t, buffer.SubmitSigned(ctx, signed), msg)
+	}
+	txn := writeTxnOf(buffer.AppId, addr, params, "put")
+	txn.OnCompletion = types.DeleteApplicationOC
+	_, err = signer.SignTransactions(ctx, []types.Transaction{txn})
+	errors.As(err, &partial)
+	signed, _ = client.CosignTransaction(ctx, client.NewAccountSigner(b), partial.Transactions[0])
+	assert.EqualError(t, buffer.SubmitSigned(ctx, signed), "transaction 0 is not a NoOp call")
+}
+
+// Signed checksums must match the state when the transaction is submitted
+func TestSubmitSigned_StaleChecksum(t *testing.T) {
+	a, b := crypto.GenerateAccount(), crypto.GenerateAccount()
+	ma, _ := crypto.MultisigAccountWithParams(1, 2, []types.Address{a.Address, b.Address})
+	signer, _ := client.NewMultisigSigner(ma, client.NewAccountSigner(a))
+	c := client.CreateAlgorandClientMock("", "")
+	c.PendingTXNInfo.ConfirmedRound = 10
+	buffer, err := NewAlgorandBufferWithSigner(signingMock{c}, signer, WithChecksum())
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	err = buffer.PutElements(ctx, map[string]string{"1000": "Astralis"})
+	var partial *client.ErrPartiallySigned
+	assert.True(t, errors.As(err, &partial))
+	signed, err := client.CosignTransaction(ctx, client.NewAccountSigner(b), partial.Transactions[0])
+	assert.Nil(t, err)
+
+	// the mock doesn't apply submitted transactions, so the checksum is checked twice
+	assert.Nil(t, buffer.SubmitSigned(ctx, signed))
+	kv := toTealKeyValues(map[string][]byte{"1001": []byte("OG")})
+	assert.Nil(t, c.StoreGlobals(signer, buffer.AppId, kv))
+	assert.Equal(t, ErrStaleChecksum, buffer.SubmitSigned(ctx, signed))
+}
+
+// signingMock signs writes before it applies them, like AlgorandClientWrapper.
+type signingMock struct {
+	*client.AlgorandMock
+}
+
+func (m signingMock) UpdateGlobals(s client.Signer, appId uint64, keys []string, kv []models.TealKeyValue) error {
+	params := types.SuggestedParams{Fee: 1000, FlatFee: true, FirstRoundValid: 1, LastRoundValid: 1000,
+		GenesisHash: make([]byte, 32)}
+	txn := writeTxnOf(appId, s.Address(), params, journalUpdate, []byte{0, 0, 0, 0, 0, 0, 0, byte(len(keys))})
+	for _, k := range keys {
+		txn.ApplicationArgs = append(txn.ApplicationArgs, []byte(k))
+	}
+	for _, pair := range kv {
+		txn.ApplicationArgs = append(txn.ApplicationArgs, []byte(pair.Key), []byte(pair.Value.Bytes))
+	}
+	if _, err := s.SignTransactions(context.Background(), []types.Transaction{txn}); err != nil {
+		return err
+	}
+	return m.AlgorandMock.UpdateGlobals(s, appId, keys, kv)
+}
+
+func (m signingMock) StoreGlobals(s client.Signer, appId uint64, kv []models.TealKeyValue) error {
+	return m.UpdateGlobals(s, appId, nil, kv)
+}
+
+func (m signingMock) DeleteGlobals(s client.Signer, appId uint64, keys ...string) error {
+	return m.UpdateGlobals(s, appId, keys, nil)
+}
+
+// writeTxnOf returns a NoOp call of the application with the given note and arguments.
+func writeTxnOf(appId uint64, sender types.Address, params types.SuggestedParams, note string, args ...[]byte) types.Transaction {
+	txn, _ := future.MakeApplicationNoOpTx(appId, args, nil, nil, nil, params, sender, []byte(note),
+		types.Digest{}, [32]byte{}, types.Address{})
+	return txn
+}
+
+// Partially signed writes must not need several transactions, because only the
+// transactions returned by the signer can be completed offline
+func TestAlgorandBuffer_PartialWrite(t *testing.T) {
+	a, b := crypto.GenerateAccount(), crypto.GenerateAccount()
+	ma, _ := crypto.MultisigAccountWithParams(1, 2, []types.Address{a.Address, b.Address})
+	signer, _ := client.NewMultisigSigner(ma, client.NewAccountSigner(a))
+	buffer, err := NewAlgorandBufferWithSigner(signingMock{client.CreateAlgorandClientMock("", "")}, signer,
+		WithMetadata(), WithJournal(filepath.Join(t.TempDir(), "siam.journal")))
+	assert.Nil(t, err)
+
+	err = buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"})
+	var partial *client.ErrPartiallySigned
+	assert.True(t, errors.As(err, &partial))
+	assert.Len(t, partial.Transactions, 1)
+
+	data := make(map[string]string)
+	for i := 0; i < client.MaxKVArgs; i++ {
+		data[strconv.Itoa(i)] = "OG"
+	}
+	err = buffer.PutElements(context.Background(), data)
+	assert.False(t, errors.As(err, &partial))
+	assert.Equal(t, &ErrPartialWrite{Transactions: 2}, err)
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Empty(t, d)
+	// only the intent of the first write can still be submitted
+	assert.Len(t, buffer.journal.Pending(), 1)
+}
+
+func TestConfigMultisig(t *testing.T) {
+	clearEnv(t)
+	a, b := crypto.GenerateAccount(), crypto.GenerateAccount()
+	key, _ := FormatBase64.Encode(a.PrivateKey)
+	cfg := &Config{Key: KeyConfig{Multisig: &MultisigConfig{
+		Threshold: 1,
+		Addresses: []string{a.Address.String(), b.Address.String()},
+		Signers:   []KeyConfig{{PrivateKey: key}},
+	}}}
+	s, err := cfg.Signer()
+	assert.Nil(t, err)
+	assert.IsType(t, &client.MultisigSigner{}, s)
+	ma, _ := crypto.MultisigAccountWithParams(1, 1, []types.Address{a.Address, b.Address})
+	addr, _ := ma.Address()
+	assert.Equal(t, addr, s.Address())
+
+	cfg.Key.Multisig.Threshold = 3
+	assert.Contains(t, cfg.Validate().Error(), "key: multisig: ")
+	cfg.Key.Multisig.Threshold = 1
+	cfg.Key.Multisig.Signers = []KeyConfig{{Mnemonic: "abandon"}}
+	assert.Contains(t, cfg.Validate().Error(), "key: multisig.signers[0]: invalid mnemonic")
+}
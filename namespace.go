@@ -0,0 +1,244 @@
+package siam
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Namespace is a view on an AlgorandBuffer that transparently prefixes all keys.
+// Reads only return keys of the namespace, and AchieveDesiredState and DeleteElements
+// never touch keys outside of it. This allows several feeds to share one application
+// without key collisions.
+//
+// Every namespace can have a slot quota, so that a single feed can't occupy all slots
+// of the application. Create a Namespace with AlgorandBuffer.Namespace.
+type Namespace struct {
+	buffer *AlgorandBuffer
+	prefix string
+}
+
+// Namespace returns a view on the buffer whose keys are prefixed with the given prefix.
+// The prefix counts towards the 128 byte limit of every kv pair. Views with the same
+// prefix share their quota.
+//
+// The prefix must not be empty or reserved, and must not be a prefix of the prefix of
+// another namespace of the buffer, or vice versa. Otherwise one namespace would see
+// and delete keys of the other, and *ErrNamespaceOverlap is returned.
+func (ab *AlgorandBuffer) Namespace(prefix string) (*Namespace, error) {
+	if prefix == "" {
+		return nil, errors.New("namespace prefix is empty")
+	}
+	if isReserved(prefix) {
+		return nil, &ErrReservedKey{Key: prefix}
+	}
+
+	ab.metaMu.Lock()
+	defer ab.metaMu.Unlock()
+	if !ab.namespaces[prefix] {
+		for p := range ab.namespaces {
+			if strings.HasPrefix(prefix, p) || strings.HasPrefix(p, prefix) {
+				return nil, &ErrNamespaceOverlap{Prefix: prefix, Existing: p}
+			}
+		}
+		ab.namespaces[prefix] = true
+	}
+	return &Namespace{buffer: ab, prefix: prefix}, nil
+}
+
+// Prefix returns the prefix of the namespace.
+func (n *Namespace) Prefix() string {
+	return n.prefix
+}
+
+// SetQuota limits the number of keys the namespace can hold. A quota of 0 means that
+// the namespace is only limited by the capacity of the application. The quota is
+// shared by all views with the same prefix.
+func (n *Namespace) SetQuota(slots int) {
+	n.buffer.metaMu.Lock()
+	defer n.buffer.metaMu.Unlock()
+	if slots <= 0 {
+		delete(n.buffer.quotas, n.prefix)
+		return
+	}
+	n.buffer.quotas[n.prefix] = slots
+}
+
+// Quota returns the quota of the namespace, or 0 if it has none.
+func (n *Namespace) Quota() int {
+	n.buffer.metaMu.Lock()
+	defer n.buffer.metaMu.Unlock()
+	return n.buffer.quotas[n.prefix]
+}
+
+// GetBuffer returns the pairs of the namespace, without prefix.
+func (n *Namespace) GetBuffer(ctx context.Context) (map[string]string, error) {
+	b, err := n.GetBufferRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(b))
+	for k, v := range b {
+		m[k] = string(v)
+	}
+	return m, nil
+}
+
+// GetBufferRaw returns the pairs of the namespace with []byte values, without prefix.
+func (n *Namespace) GetBufferRaw(ctx context.Context) (map[string][]byte, error) {
+	state, err := n.buffer.GetBufferRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]byte)
+	for k, v := range state {
+		if strings.HasPrefix(k, n.prefix) {
+			m[strings.TrimPrefix(k, n.prefix)] = v
+		}
+	}
+	return m, nil
+}
+
+// PutElements stores the given pairs in the namespace. Returns an *ErrQuotaExceeded
+// if the namespace would exceed its quota.
+func (n *Namespace) PutElements(ctx context.Context, data map[string]string) error {
+	m := make(map[string][]byte, len(data))
+	for k, v := range data {
+		m[k] = []byte(v)
+	}
+	return n.PutElementsRaw(ctx, m)
+}
+
+// PutElementsRaw stores the given pairs with []byte values in the namespace.
+func (n *Namespace) PutElementsRaw(ctx context.Context, data map[string][]byte) error {
+	if err := n.checkQuota(ctx, data, nil); err != nil {
+		return n.buffer.writeFailed(nil, n.prefixed(data), err)
+	}
+	return n.buffer.PutElementsRaw(ctx, n.prefixed(data))
+}
+
+// PutWithTTL stores the given pairs in the namespace, expiring after the given duration.
+// See AlgorandBuffer.PutWithTTL.
+func (n *Namespace) PutWithTTL(ctx context.Context, data map[string]string, ttl time.Duration) error {
+	raw := make(map[string][]byte, len(data))
+	prefixed := make(map[string]string, len(data))
+	for k, v := range data {
+		raw[k] = []byte(v)
+		prefixed[n.prefix+k] = v
+	}
+	if err := n.checkQuota(ctx, raw, nil); err != nil {
+		return n.buffer.writeFailed(nil, toBytes(prefixed), err)
+	}
+	return n.buffer.PutWithTTL(ctx, prefixed, ttl)
+}
+
+// DeleteElements removes the given keys from the namespace.
+func (n *Namespace) DeleteElements(ctx context.Context, keys ...string) error {
+	prefixed := make([]string, len(keys))
+	for i, k := range keys {
+		prefixed[i] = n.prefix + k
+	}
+	return n.buffer.DeleteElements(ctx, prefixed...)
+}
+
+// Contains returns true if the namespace contains the given data.
+func (n *Namespace) Contains(ctx context.Context, m map[string]string) (bool, error) {
+	data, err := n.GetBuffer(ctx)
+	if err != nil {
+		return false, err
+	}
+	return mapContainsMap(data, m), nil
+}
+
+// AchieveDesiredState turns the state of the namespace into the given desired state.
+// Keys outside of the namespace are not touched.
+func (n *Namespace) AchieveDesiredState(ctx context.Context, desired map[string]string) error {
+	ab := n.buffer
+	want := make(map[string]string, len(desired))
+	for k, v := range desired {
+		want[n.prefix+k] = v
+	}
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return ab.writeFailed(nil, toBytes(want), err)
+	}
+	if err = verifyChecksum(state); err != nil {
+		return ab.writeFailed(nil, toBytes(want), err)
+	}
+	decoded, err := ab.decodeState(state)
+	if err != nil {
+		return ab.writeFailed(nil, toBytes(want), err)
+	}
+	data := make(map[string]string)
+	for k, v := range decoded {
+		if strings.HasPrefix(k, n.prefix) {
+			data[k] = string(v)
+		}
+	}
+	put, del := ab.computeChanges(want, data, state)
+	if len(put)+len(del) == 0 {
+		return nil
+	}
+
+	raw := make(map[string][]byte, len(put))
+	for k, v := range put {
+		raw[strings.TrimPrefix(k, n.prefix)] = []byte(v)
+	}
+	keys := make([]string, 0, len(del))
+	for k := range del {
+		keys = append(keys, strings.TrimPrefix(k, n.prefix))
+	}
+	if err = n.checkQuota(ctx, raw, keys); err != nil {
+		return ab.writeFailed(getKeys(del), toBytes(put), err)
+	}
+	if err = n.DeleteElements(ctx, keys...); err != nil {
+		return err
+	}
+	return n.PutElementsRaw(ctx, raw)
+}
+
+// checkQuota returns an *ErrQuotaExceeded if the namespace can't hold the keys of
+// data after the keys in del have been removed.
+func (n *Namespace) checkQuota(ctx context.Context, data map[string][]byte, del []string) error {
+	quota := n.Quota()
+	if quota == 0 {
+		return nil
+	}
+	state, err := n.GetBufferRaw(ctx)
+	if err != nil {
+		return err
+	}
+	used := len(state)
+	for _, k := range del {
+		if _, ok := state[k]; ok {
+			used--
+		}
+	}
+
+	newKeys := make([]string, 0)
+	for k := range data {
+		if _, ok := state[k]; !ok {
+			newKeys = append(newKeys, k)
+		}
+	}
+	if used+len(newKeys) <= quota {
+		return nil
+	}
+	sort.Strings(newKeys)
+	free := quota - used
+	if free < 0 {
+		free = 0
+	}
+	return &ErrQuotaExceeded{Namespace: n.prefix, Quota: quota, Keys: newKeys[free:]}
+}
+
+// prefixed returns a copy of data with prefixed keys.
+func (n *Namespace) prefixed(data map[string][]byte) map[string][]byte {
+	m := make(map[string][]byte, len(data))
+	for k, v := range data {
+		m[n.prefix+k] = v
+	}
+	return m
+}
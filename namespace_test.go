@@ -0,0 +1,96 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"testing"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// Keys of different namespaces must not collide, and reads are scoped
+func TestNamespace_Isolation(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	a, err := buffer.Namespace("a/")
+	assert.Nil(t, err)
+	b, err := buffer.Namespace("b/")
+	assert.Nil(t, err)
+
+	assert.Nil(t, a.PutElements(context.Background(), map[string]string{"match_256846": "Astralis"}))
+	assert.Nil(t, b.PutElements(context.Background(), map[string]string{"match_256846": "Vitality"}))
+
+	d, err := a.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"match_256846": "Astralis"}, d)
+
+	d, _ = buffer.GetBuffer(context.Background())
+	assert.Equal(t, "Vitality", d["b/match_256846"])
+
+	// desired state of one namespace doesn't delete keys of another
+	assert.Nil(t, a.AchieveDesiredState(context.Background(), map[string]string{"x": "y"}))
+	d, _ = buffer.GetBuffer(context.Background())
+	assert.Equal(t, map[string]string{"a/x": "y", "b/match_256846": "Vitality"}, d)
+
+	assert.Nil(t, b.DeleteElements(context.Background(), "match_256846"))
+	d, _ = buffer.GetBuffer(context.Background())
+	assert.Equal(t, map[string]string{"a/x": "y"}, d)
+}
+
+func TestNamespace_Quota(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	ns, _ := buffer.Namespace("a/")
+	ns.SetQuota(2)
+	same, err := buffer.Namespace("a/")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, same.Quota())
+
+	assert.Nil(t, ns.PutElements(context.Background(), map[string]string{"1": "x", "2": "x"}))
+	// updating existing keys is fine
+	assert.Nil(t, ns.PutElements(context.Background(), map[string]string{"1": "y"}))
+
+	err = ns.PutElements(context.Background(), map[string]string{"3": "x"})
+	assert.IsType(t, &ErrQuotaExceeded{}, err)
+	assert.Equal(t, []string{"3"}, err.(*ErrQuotaExceeded).Keys)
+
+	// replacing keys within the quota works through AchieveDesiredState
+	assert.Nil(t, ns.AchieveDesiredState(context.Background(), map[string]string{"3": "x", "4": "x"}))
+	d, _ := ns.GetBuffer(context.Background())
+	assert.Equal(t, map[string]string{"3": "x", "4": "x"}, d)
+}
+
+// Namespaces must not see each other's keys
+func TestNamespace_Prefixes(t *testing.T) {
+	buffer, _ := NewAlgorandBuffer(client.CreateAlgorandClientMock("", ""), client.GeneratePrivateKey64())
+	_, err := buffer.Namespace("cs/")
+	assert.Nil(t, err)
+
+	_, err = buffer.Namespace("")
+	assert.NotNil(t, err)
+	_, err = buffer.Namespace(ReservedPrefix + "x")
+	assert.IsType(t, &ErrReservedKey{}, err)
+	_, err = buffer.Namespace("cs/eu/")
+	assert.Equal(t, &ErrNamespaceOverlap{Prefix: "cs/eu/", Existing: "cs/"}, err)
+	_, err = buffer.Namespace("c")
+	assert.Equal(t, &ErrNamespaceOverlap{Prefix: "c", Existing: "cs/"}, err)
+	_, err = buffer.Namespace("dota/")
+	assert.Nil(t, err)
+}
+
+// Values encrypted with an old key are rewritten, like in AlgorandBuffer
+func TestNamespace_DesiredStateRewritesStale(t *testing.T) {
+	e, _ := NewEncryptor(1, testKey1)
+	buffer, _ := NewAlgorandBuffer(client.CreateAlgorandClientMock("", ""), client.GeneratePrivateKey64(), WithEncryption(e))
+	ns, _ := buffer.Namespace("a/")
+	data := map[string]string{"1000": "Astralis"}
+	assert.Nil(t, ns.PutElements(context.Background(), data))
+
+	assert.Nil(t, e.AddKey(2, testKey2))
+	assert.Nil(t, e.SetActiveKey(2))
+	assert.Nil(t, ns.AchieveDesiredState(context.Background(), data))
+	state, _ := buffer.getGlobalState(context.Background())
+	assert.Equal(t, byte(2), state["a/1000"][1])
+}
@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a delivery that failed finally. The dead-letter file contains one
+// JSON encoded DeadLetter per line.
+type DeadLetter struct {
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url"`
+	Event    Event     `json:"event"`
+	Attempts int       `json:"attempts"`
+	Reason   string    `json:"reason"`
+}
+
+// deadLetterFile appends dead letters to a file.
+type deadLetterFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+// append writes a dead letter to the file. Without a path, the letter is dropped.
+func (f *deadLetterFile) append(url string, ev Event, attempts int, reason string) error {
+	if f.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(DeadLetter{Time: time.Now(), URL: url, Event: ev, Attempts: attempts, Reason: reason})
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(b, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// deadLetter appends a dead letter, and logs it to ErrorLog if it can't be written.
+func (n *Notifier) deadLetter(url string, ev Event, attempts int, reason string) {
+	err := n.deadLetters.append(url, ev, attempts, reason)
+	if err == nil {
+		return
+	}
+	msg := fmt.Sprintf("notify: dead letter for %s (event %s: %s) lost: %v", url, ev.Id, reason, err)
+	if n.ErrorLog != nil {
+		n.ErrorLog.Print(msg)
+	} else {
+		log.Print(msg)
+	}
+}
+
+// ReadDeadLetters returns the dead letters of the given file. A missing file
+// contains no dead letters.
+func ReadDeadLetters(path string) ([]DeadLetter, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	letters := make([]DeadLetter, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var l DeadLetter
+		if err = json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return nil, err
+		}
+		letters = append(letters, l)
+	}
+	return letters, scanner.Err()
+}
+
+// Redeliver removes all dead letters from the file and queues them again, for the
+// endpoints they failed at. Letters for endpoints that are no longer configured are
+// kept in the file. It returns the number of queued deliveries, and an error if a
+// letter couldn't be written back to the file.
+func (n *Notifier) Redeliver() (int, error) {
+	n.deadLetters.mu.Lock()
+	letters, err := ReadDeadLetters(n.deadLetters.path)
+	if err == nil {
+		err = os.Remove(n.deadLetters.path)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+	n.deadLetters.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	var appendErr error
+	for _, l := range letters {
+		e := n.endpoint(l.URL)
+		if e == nil {
+			if err = n.deadLetters.append(l.URL, l.Event, l.Attempts, l.Reason); err != nil && appendErr == nil {
+				appendErr = err
+			}
+			continue
+		}
+		select {
+		case n.queue <- delivery{endpoint: e, event: l.Event}:
+			queued++
+		default:
+			if err = n.deadLetters.append(l.URL, l.Event, l.Attempts, "delivery queue is full"); err != nil && appendErr == nil {
+				appendErr = err
+			}
+		}
+	}
+	return queued, appendErr
+}
+
+// endpoint returns the endpoint with the given URL, or nil.
+func (n *Notifier) endpoint(url string) *Endpoint {
+	for i := range n.Endpoints {
+		if n.Endpoints[i].URL == url {
+			return &n.Endpoints[i]
+		}
+	}
+	return nil
+}
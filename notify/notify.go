@@ -0,0 +1,292 @@
+// Package notify sends webhooks about an oracle: changed keys, failed writes, low
+// balances and unhealthy nodes. Payloads are JSON and signed with HMAC-SHA256, failed
+// deliveries are retried with exponential backoff, and deliveries that finally fail
+// are appended to a local dead-letter file.
+//
+// A typical setup:
+//
+//	n := notify.New("/var/lib/siam/dead-letters.jsonl", notify.Endpoint{
+//		URL:    "https://hooks.example.com/siam",
+//		Secret: []byte(secret),
+//	})
+//	wg := n.SpawnDeliveryRoutine(ctx)
+//	buffer, err := siam.NewAlgorandBufferFromEnv(siam.WithWriteHook(n.OnWrite))
+//	n.SpawnMonitorRoutine(ctx, buffer, time.Minute, 1_000_000)
+//	n.SpawnChangeRoutine(ctx, siam.NewReader(buffer.Client, buffer.AppId), 10*time.Second)
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	siam "github.com/m2q/algo-siam"
+)
+
+// Kind is the type of an Event.
+type Kind string
+
+const (
+	KindKeysChanged   Kind = "keys_changed"
+	KindWriteFailed   Kind = "write_failed"
+	KindLowBalance    Kind = "low_balance"
+	KindNodeUnhealthy Kind = "node_unhealthy"
+)
+
+// Headers of every delivery.
+const (
+	HeaderEvent     = "X-Siam-Event"
+	HeaderDelivery  = "X-Siam-Delivery"
+	HeaderTimestamp = "X-Siam-Timestamp"
+	HeaderSignature = "X-Siam-Signature"
+)
+
+// Event is the JSON payload of a webhook.
+type Event struct {
+	// Id is unique per event, so receivers can drop duplicates of retried deliveries.
+	Id    string    `json:"id"`
+	Kind  Kind      `json:"kind"`
+	Time  time.Time `json:"time"`
+	AppId uint64    `json:"app,omitempty"`
+	Round uint64    `json:"round,omitempty"`
+
+	// Changes are set for KindKeysChanged.
+	Changes []siam.EncodedChange `json:"changes,omitempty"`
+
+	// Keys are the keys of a failed write.
+	Keys []string `json:"keys,omitempty"`
+
+	// Balance and MinBalance are set for KindLowBalance, in microAlgos.
+	Balance    uint64 `json:"balance,omitempty"`
+	MinBalance uint64 `json:"min_balance,omitempty"`
+
+	// Error describes failed writes and unhealthy nodes.
+	Error string `json:"error,omitempty"`
+}
+
+// Endpoint is a receiver of webhooks.
+type Endpoint struct {
+	URL string
+	// Secret is the key of the HMAC signature.
+	Secret []byte
+	// Kinds filters the events sent to the endpoint. Empty means all kinds.
+	Kinds []Kind
+}
+
+// accepts returns true if the endpoint wants events of the given kind.
+func (e *Endpoint) accepts(k Kind) bool {
+	if len(e.Kinds) == 0 {
+		return true
+	}
+	for _, kind := range e.Kinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifier delivers events to endpoints. Create it with New, and start the delivery
+// with SpawnDeliveryRoutine.
+type Notifier struct {
+	Endpoints []Endpoint
+
+	// Client sends the requests. Its timeout applies to every attempt.
+	Client *http.Client
+
+	// MaxAttempts is the number of attempts per delivery.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. It doubles with every retry, up
+	// to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Workers is the number of deliveries sent concurrently.
+	Workers int
+
+	// ErrorLog logs dead letters that can't be written to the dead-letter file. If
+	// nil, the log package's standard logger is used.
+	ErrorLog *log.Logger
+
+	deadLetters *deadLetterFile
+	queue       chan delivery
+}
+
+// delivery is an event for a single endpoint.
+type delivery struct {
+	endpoint *Endpoint
+	event    Event
+}
+
+// queueSize is the number of deliveries that can wait for a worker. If the queue is
+// full, new deliveries are dead-lettered immediately.
+const queueSize = 1024
+
+// New creates a Notifier with sensible defaults. Deliveries that fail are appended
+// to the file at deadLetterPath. If deadLetterPath is empty, they are dropped.
+func New(deadLetterPath string, endpoints ...Endpoint) *Notifier {
+	return &Notifier{
+		Endpoints:   endpoints,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		Workers:     4,
+		deadLetters: &deadLetterFile{path: deadLetterPath},
+		queue:       make(chan delivery, queueSize),
+	}
+}
+
+// Notify queues an event for all endpoints that accept its kind. Id and Time are set
+// if they are empty. Notify never blocks.
+func (n *Notifier) Notify(ev Event) {
+	if ev.Id == "" {
+		ev.Id = newId()
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for i := range n.Endpoints {
+		e := &n.Endpoints[i]
+		if !e.accepts(ev.Kind) {
+			continue
+		}
+		select {
+		case n.queue <- delivery{endpoint: e, event: ev}:
+		default:
+			n.deadLetter(e.URL, ev, 0, "delivery queue is full")
+		}
+	}
+}
+
+// SpawnDeliveryRoutine starts the workers that deliver queued events. When ctx is
+// cancelled, the workers exit and deliveries that haven't succeeded yet are
+// dead-lettered. Use the returned WaitGroup to wait for the exit.
+func (n *Notifier) SpawnDeliveryRoutine(ctx context.Context) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					n.drain()
+					return
+				case d := <-n.queue:
+					n.deliver(ctx, d)
+				}
+			}
+		}()
+	}
+	return wg
+}
+
+// drain dead-letters all queued deliveries.
+func (n *Notifier) drain() {
+	for {
+		select {
+		case d := <-n.queue:
+			n.deadLetter(d.endpoint.URL, d.event, 0, "notifier stopped")
+		default:
+			return
+		}
+	}
+}
+
+// deliver sends a delivery, retrying with backoff. If all attempts fail, it's
+// dead-lettered.
+func (n *Notifier) deliver(ctx context.Context, d delivery) {
+	body, err := json.Marshal(d.event)
+	if err != nil {
+		n.deadLetter(d.endpoint.URL, d.event, 0, err.Error())
+		return
+	}
+	backoff := n.Backoff
+	attempt := 0
+	for {
+		attempt++
+		retry, err := n.send(d.endpoint, d.event, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= n.MaxAttempts {
+			n.deadLetter(d.endpoint.URL, d.event, attempt, err.Error())
+			return
+		}
+		select {
+		case <-ctx.Done():
+			n.deadLetter(d.endpoint.URL, d.event, attempt, err.Error())
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > n.MaxBackoff {
+			backoff = n.MaxBackoff
+		}
+	}
+}
+
+// send makes a single attempt. It returns whether a failed attempt should be
+// retried: network errors, 429 and 5xx responses are retried, other responses
+// aren't. The attempt isn't cancelled on shutdown, it's only bounded by the timeout
+// of the Client, so that a receiver doesn't get an event that is dead-lettered.
+func (n *Notifier) send(e *Endpoint, ev Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(ev.Kind))
+	req.Header.Set(HeaderDelivery, ev.Id)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, Sign(e.Secret, ts, body))
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("endpoint responded with %s", resp.Status)
+}
+
+// Sign returns the signature header of a payload: "sha256=" followed by the hex
+// HMAC-SHA256 of timestamp + "." + body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a received webhook. Receivers should additionally
+// reject timestamps that are too old, to prevent replays.
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// newId returns a random event ID.
+func newId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
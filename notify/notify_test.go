@@ -0,0 +1,233 @@
+//go:build unit
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	siam "github.com/m2q/algo-siam"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+var secret = []byte("hook secret")
+
+// receiver is a local HTTP stand-in for a webhook endpoint. It fails the first
+// failures requests with given status.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failures int
+	status   int
+	requests int
+	events   []Event
+}
+
+func newReceiver(t *testing.T, failures, status int) *receiver {
+	r := &receiver{failures: failures, status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests++
+		if r.requests <= r.failures {
+			w.WriteHeader(r.status)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		if !Verify(secret, req.Header.Get(HeaderTimestamp), body, req.Header.Get(HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var ev Event
+		_ = json.Unmarshal(body, &ev)
+		assert.Equal(t, string(ev.Kind), req.Header.Get(HeaderEvent))
+		assert.Equal(t, ev.Id, req.Header.Get(HeaderDelivery))
+		r.events = append(r.events, ev)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) requestCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+func (r *receiver) received() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+func newNotifier(t *testing.T, endpoints ...Endpoint) (*Notifier, string) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	n := New(path, endpoints...)
+	n.Backoff = time.Millisecond
+	n.MaxAttempts = 3
+	return n, path
+}
+
+func TestNotifier_Retry(t *testing.T) {
+	r := newReceiver(t, 2, http.StatusServiceUnavailable)
+	n, path := newNotifier(t, Endpoint{URL: r.URL, Secret: secret})
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := n.SpawnDeliveryRoutine(ctx)
+
+	n.Notify(Event{Kind: KindLowBalance, Balance: 10})
+	assert.Eventually(t, func() bool { return len(r.received()) == 1 }, time.Second, time.Millisecond)
+	ev := r.received()[0]
+	assert.Equal(t, uint64(10), ev.Balance)
+	assert.NotEmpty(t, ev.Id)
+	assert.False(t, ev.Time.IsZero())
+
+	cancel()
+	wg.Wait()
+	letters, err := ReadDeadLetters(path)
+	assert.Nil(t, err)
+	assert.Empty(t, letters)
+}
+
+func TestNotifier_DeadLetter(t *testing.T) {
+	failing := newReceiver(t, 100, http.StatusInternalServerError)
+	rejecting := newReceiver(t, 100, http.StatusBadRequest)
+	n, path := newNotifier(t, Endpoint{URL: failing.URL, Secret: secret}, Endpoint{URL: rejecting.URL, Secret: secret})
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := n.SpawnDeliveryRoutine(ctx)
+
+	n.Notify(Event{Kind: KindNodeUnhealthy, Error: "down"})
+	assert.Eventually(t, func() bool {
+		letters, _ := ReadDeadLetters(path)
+		return len(letters) == 2
+	}, time.Second, time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	letters, _ := ReadDeadLetters(path)
+	attempts := map[string]int{}
+	for _, l := range letters {
+		attempts[l.URL] = l.Attempts
+		assert.Equal(t, "down", l.Event.Error)
+	}
+	// client errors aren't retried
+	assert.Equal(t, map[string]int{failing.URL: 3, rejecting.URL: 1}, attempts)
+
+	// once the endpoints recovered, the dead letters can be delivered again
+	failing.mu.Lock()
+	failing.failures = 0
+	failing.mu.Unlock()
+	rejecting.mu.Lock()
+	rejecting.failures = 0
+	rejecting.mu.Unlock()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	n.SpawnDeliveryRoutine(ctx)
+	queued, err := n.Redeliver()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, queued)
+	assert.Eventually(t, func() bool {
+		return len(failing.received()) == 1 && len(rejecting.received()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, letters[0].Event.Id, append(failing.received(), rejecting.received()...)[0].Id)
+	letters, _ = ReadDeadLetters(path)
+	assert.Empty(t, letters)
+}
+
+func TestNotifier_DeadLetterWriteError(t *testing.T) {
+	failing := newReceiver(t, 100, http.StatusBadRequest)
+	n := New(filepath.Join(t.TempDir(), "missing", "dead.jsonl"), Endpoint{URL: failing.URL, Secret: secret})
+	logs := &bytes.Buffer{}
+	n.ErrorLog = log.New(logs, "", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := n.SpawnDeliveryRoutine(ctx)
+
+	n.Notify(Event{Id: "lost", Kind: KindNodeUnhealthy})
+	assert.Eventually(t, func() bool { return failing.requestCount() == 1 }, time.Second, time.Millisecond)
+	cancel()
+	wg.Wait()
+	assert.Contains(t, logs.String(), "event lost")
+	assert.Contains(t, logs.String(), "no such file or directory")
+}
+
+func TestNotifier_Kinds(t *testing.T) {
+	r := newReceiver(t, 0, 0)
+	n, _ := newNotifier(t, Endpoint{URL: r.URL, Secret: secret, Kinds: []Kind{KindWriteFailed}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n.SpawnDeliveryRoutine(ctx)
+
+	n.Notify(Event{Kind: KindKeysChanged})
+	n.Notify(Event{Kind: KindWriteFailed})
+	assert.Eventually(t, func() bool { return len(r.received()) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, r.received(), 1)
+	assert.Equal(t, KindWriteFailed, r.received()[0].Kind)
+}
+
+func TestNotifier_Sources(t *testing.T) {
+	r := newReceiver(t, 0, 0)
+	n, _ := newNotifier(t, Endpoint{URL: r.URL, Secret: secret})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n.SpawnDeliveryRoutine(ctx)
+
+	c := client.CreateAlgorandClientMock("", "")
+	c.Account.Amount = 500
+	buffer, err := siam.NewAlgorandBuffer(c, client.GeneratePrivateKey64(), siam.WithWriteHook(n.OnWrite))
+	assert.Nil(t, err)
+
+	// two checks notify a low balance only once
+	m := &monitor{notifier: n, buffer: buffer, minBalance: 1000}
+	m.check(ctx)
+	m.check(ctx)
+	c.SetError(true, (*client.AlgorandMock).HealthCheck)
+	m.check(ctx)
+
+	// a write to an unknown application fails
+	appId := buffer.AppId
+	buffer.AppId = appId + 1
+	assert.NotNil(t, buffer.PutElements(ctx, map[string]string{"1000": "Astralis"}))
+	buffer.AppId = appId
+
+	assert.Eventually(t, func() bool { return len(r.received()) == 3 }, time.Second, time.Millisecond)
+	kinds := map[Kind]Event{}
+	for _, ev := range r.received() {
+		kinds[ev.Kind] = ev
+	}
+	assert.Equal(t, uint64(500), kinds[KindLowBalance].Balance)
+	assert.NotEmpty(t, kinds[KindNodeUnhealthy].Error)
+	assert.Equal(t, []string{"1000"}, kinds[KindWriteFailed].Keys)
+	assert.Equal(t, appId+1, kinds[KindWriteFailed].AppId)
+
+	// changes after the initial state
+	assert.Nil(t, buffer.PutElements(ctx, map[string]string{"1000": "Astralis"}))
+	n.SpawnChangeRoutine(ctx, siam.NewReader(c, appId), 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, buffer.PutElements(ctx, map[string]string{"1001": "OG"}))
+	assert.Eventually(t, func() bool { return len(r.received()) == 4 }, time.Second, time.Millisecond)
+	ev := r.received()[3]
+	assert.Equal(t, KindKeysChanged, ev.Kind)
+	assert.Equal(t, []siam.EncodedChange{{Key: "1001", Value: "OG"}}, ev.Changes)
+
+	// binary values are sent base64 encoded
+	assert.Nil(t, buffer.PutElementsRaw(ctx, map[string][]byte{"1002": {0xff, 0x00}}))
+	assert.Eventually(t, func() bool { return len(r.received()) == 5 }, time.Second, time.Millisecond)
+	assert.Equal(t, []siam.EncodedChange{{Key: "1002", ValueB64: "/wA="}}, r.received()[4].Changes)
+}
+
+func TestSign(t *testing.T) {
+	sig := Sign(secret, "1700000000", []byte(`{"kind":"low_balance"}`))
+	assert.True(t, Verify(secret, "1700000000", []byte(`{"kind":"low_balance"}`), sig))
+	assert.False(t, Verify(secret, "1700000001", []byte(`{"kind":"low_balance"}`), sig))
+	assert.False(t, Verify([]byte("other"), "1700000000", []byte(`{"kind":"low_balance"}`), sig))
+}
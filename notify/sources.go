@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	siam "github.com/m2q/algo-siam"
+)
+
+// OnWrite notifies about failed writes. Register it with siam.WithWriteHook.
+func (n *Notifier) OnWrite(res siam.WriteResult) {
+	if res.Err == nil {
+		return
+	}
+	keys := make([]string, 0, len(res.Put)+len(res.Deleted))
+	keys = append(keys, res.Put...)
+	keys = append(keys, res.Deleted...)
+	n.Notify(Event{Kind: KindWriteFailed, Time: res.Time, AppId: res.AppId, Keys: keys, Error: res.Err.Error()})
+}
+
+// SpawnChangeRoutine watches the application of the Reader, and notifies about every
+// change after the initial state. The routine exits when ctx is cancelled. Use the
+// returned WaitGroup to wait for the exit.
+func (n *Notifier) SpawnChangeRoutine(ctx context.Context, r *siam.Reader, interval time.Duration) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		initial := true
+		for cs := range r.Watch(ctx, interval) {
+			if cs.Err != nil {
+				continue
+			}
+			if initial {
+				// the first ChangeSet is the existing state
+				initial = false
+				continue
+			}
+			ev := Event{Kind: KindKeysChanged, Time: cs.Time, AppId: r.AppId, Round: cs.Round}
+			for _, c := range cs.Changes {
+				ev.Changes = append(ev.Changes, c.Encode())
+			}
+			n.Notify(ev)
+		}
+	}()
+	return wg
+}
+
+// SpawnMonitorRoutine checks the node and the balance of the buffer's account every
+// interval. It notifies when the node becomes unhealthy, and when the balance drops
+// below minBalance microAlgos. Each condition is notified once, and again only after
+// it recovered in between. The routine exits when ctx is cancelled. Use the returned
+// WaitGroup to wait for the exit.
+func (n *Notifier) SpawnMonitorRoutine(ctx context.Context, ab *siam.AlgorandBuffer, interval time.Duration, minBalance uint64) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		m := &monitor{notifier: n, buffer: ab, minBalance: minBalance}
+		for {
+			m.check(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return wg
+}
+
+// monitor remembers which conditions were already notified.
+type monitor struct {
+	notifier   *Notifier
+	buffer     *siam.AlgorandBuffer
+	minBalance uint64
+
+	unhealthy  bool
+	lowBalance bool
+}
+
+func (m *monitor) check(ctx context.Context) {
+	err := m.buffer.Health()
+	if err != nil && !m.unhealthy {
+		m.notifier.Notify(Event{Kind: KindNodeUnhealthy, AppId: m.buffer.AppId, Error: err.Error()})
+	}
+	m.unhealthy = err != nil
+	if m.unhealthy {
+		// the balance can't be checked reliably either
+		return
+	}
+
+	info, err := m.buffer.Client.AccountInformation(m.buffer.Signer.Address().String(), ctx)
+	if err != nil {
+		return
+	}
+	low := info.Amount < m.minBalance
+	if low && !m.lowBalance {
+		m.notifier.Notify(Event{Kind: KindLowBalance, AppId: m.buffer.AppId, Balance: info.Amount, MinBalance: m.minBalance})
+	}
+	m.lowBalance = low
+}
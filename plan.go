@@ -0,0 +1,216 @@
+package siam
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/future"
+	"github.com/algorand/go-algorand-sdk/types"
+)
+
+// PlanViolation describes a key of a Plan that cannot be written.
+type PlanViolation struct {
+	Key    string
+	Reason string
+}
+
+func (v PlanViolation) String() string {
+	return fmt.Sprintf("%s: %s", v.Key, v.Reason)
+}
+
+// Plan describes the changes that are necessary to turn the on-chain state into a
+// desired state. Create a Plan with AlgorandBuffer.Plan, inspect it, and execute it
+// with AlgorandBuffer.Apply.
+type Plan struct {
+	// Puts contains the pairs that will be created or updated.
+	Puts map[string]string
+
+	// Deletes contains the keys that will be deleted.
+	Deletes []string
+
+	// Evictions contains the keys the eviction policy deletes to make room for new
+	// keys, see WithEvictionPolicy.
+	Evictions []string
+
+	// Transactions is the number of transactions needed to apply the plan.
+	Transactions int
+
+	// EstimatedFee is the estimated total fee of all transactions, in microAlgos.
+	// Unless the suggested fee is flat, it is a fee per byte of each transaction,
+	// but at least transaction.MinTxnFee.
+	EstimatedFee uint64
+
+	// Violations lists keys that cannot be written, e.g. because a pair exceeds
+	// 128 bytes or the application storage would overflow. A plan with violations
+	// cannot be applied.
+	Violations []PlanViolation
+
+	// observed is a digest of the on-chain state the plan was computed from.
+	observed [sha256.Size]byte
+}
+
+// Empty returns true if the plan doesn't change anything.
+func (p *Plan) Empty() bool {
+	return len(p.Puts)+len(p.Deletes) == 0
+}
+
+// Plan computes the changes that AchieveDesiredState would make for the given desired
+// state, without executing them.
+func (ab *AlgorandBuffer) Plan(ctx context.Context, desired map[string]string) (*Plan, error) {
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ab.decodeState(state)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]string, len(raw))
+	for k, v := range raw {
+		data[k] = string(v)
+	}
+	put, del := ab.computeChanges(desired, data, state)
+
+	p := &Plan{
+		Puts:     put,
+		Deletes:  getKeys(del),
+		observed: digestState(raw),
+	}
+	sort.Strings(p.Deletes)
+	encoded, err := ab.encodeValues(toBytes(put), time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply deletes first and puts on top of the remaining state, each as a write of
+	// its own, like DeleteElements and PutElements
+	remaining := make(map[string][]byte, len(state))
+	for k, v := range state {
+		if _, ok := del[k]; !ok {
+			remaining[k] = v
+		}
+	}
+	puts, evictions, violations := ab.planPuts(remaining, encoded)
+	p.Evictions = evictions
+	p.Violations = violations
+	txns := append(ab.plannedTxns(deleteTxns(p.Deletes)), ab.plannedTxns(puts)...)
+	p.Transactions = len(txns)
+
+	if p.Transactions > 0 {
+		ctx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+		params, err := ab.Client.SuggestedParams(ctx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if p.EstimatedFee, err = ab.estimateFee(txns, params); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// planPuts returns the transactions that store the given encoded pairs on top of the
+// given state, the keys that are evicted for them, and the violations. If there are
+// violations, the transactions are the ones of a write without eviction.
+func (ab *AlgorandBuffer) planPuts(state map[string][]byte, put map[string][]byte) ([]txnOp, []string, []PlanViolation) {
+	violations := make([]PlanViolation, 0)
+	keys := getKeysByte(put)
+	sort.Strings(keys)
+	fitting := make(map[string][]byte, len(put))
+	for _, k := range keys {
+		if len(k)+len(put[k]) > MaxPairSize {
+			violations = append(violations, PlanViolation{Key: k, Reason: "kv pair exceeds 128 bytes"})
+			continue
+		}
+		fitting[k] = put[k]
+	}
+
+	txns, err := ab.putTxns(state, fitting)
+	if err != nil {
+		// the keys that don't fit, either because there is no eviction policy, or
+		// because it can't make room for them
+		reason := "application storage is full"
+		if _, ok := err.(*ErrCapacityExceeded); !ok {
+			reason += ": " + err.Error()
+		}
+		capErr := checkCapacity(ab.withReserved(state), getKeysByte(fitting), nil)
+		if capErr, ok := capErr.(*ErrCapacityExceeded); ok {
+			for _, k := range capErr.Keys {
+				violations = append(violations, PlanViolation{Key: k, Reason: reason})
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return partitionTxns(put), nil, violations
+	}
+
+	evictions := make([]string, 0)
+	for _, op := range txns {
+		evictions = append(evictions, op.del...)
+	}
+	sort.Strings(evictions)
+	return txns, evictions, violations
+}
+
+// estimateFee returns the total fee of the given transactions. Unless the fee of the
+// parameters is flat, it is a fee per byte of each transaction, but at least
+// transaction.MinTxnFee.
+func (ab *AlgorandBuffer) estimateFee(txns []txnOp, params types.SuggestedParams) (uint64, error) {
+	var total uint64
+	for _, op := range txns {
+		note, args := op.appArgs()
+		// the fee is computed while the transaction is made
+		txn, err := future.MakeApplicationNoOpTx(ab.AppId, args, nil, nil, nil, params,
+			ab.Signer.Address(), []byte(note), types.Digest{}, [32]byte{}, types.Address{})
+		if err != nil {
+			return 0, err
+		}
+		total += uint64(txn.Fee)
+	}
+	return total, nil
+}
+
+// Apply executes a plan created by Plan. It refuses to run if the on-chain state
+// changed since the plan was made, or if the plan has violations.
+func (ab *AlgorandBuffer) Apply(ctx context.Context, p *Plan) error {
+	if len(p.Violations) > 0 {
+		err := fmt.Errorf("plan has %d violations, first: %s", len(p.Violations), p.Violations[0])
+		return ab.writeFailed(p.Deletes, toBytes(p.Puts), err)
+	}
+	if p.Empty() {
+		return nil
+	}
+	raw, err := ab.GetBufferRaw(ctx)
+	if err != nil {
+		return ab.writeFailed(p.Deletes, toBytes(p.Puts), err)
+	}
+	if digestState(raw) != p.observed {
+		return ab.writeFailed(p.Deletes, toBytes(p.Puts), ErrStateChanged)
+	}
+
+	err = ab.DeleteElements(ctx, p.Deletes...)
+	if err != nil {
+		return err
+	}
+	return ab.PutElements(ctx, p.Puts)
+}
+
+// digestState computes a digest over the given state, independent of map order.
+func digestState(state map[string][]byte) [sha256.Size]byte {
+	keys := make([]string, 0, len(state))
+	for k := range state {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(state[k]), state[k])
+	}
+	var d [sha256.Size]byte
+	copy(d[:], h.Sum(nil))
+	return d
+}
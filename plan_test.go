@@ -0,0 +1,124 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/transaction"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlgorandBuffer_Plan(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.Params.Fee = 1000
+	c.Params.FlatFee = true
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis", "1001": "OG"}))
+
+	p, err := buffer.Plan(context.Background(), map[string]string{"1000": "G2", "1002": "Furia"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1000": "G2", "1002": "Furia"}, p.Puts)
+	assert.Equal(t, []string{"1001"}, p.Deletes)
+	assert.Equal(t, 2, p.Transactions)
+	assert.EqualValues(t, 2000, p.EstimatedFee)
+	assert.Len(t, p.Violations, 0)
+
+	// planning must not change anything
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Equal(t, "Astralis", d["1000"])
+
+	assert.Nil(t, buffer.Apply(context.Background(), p))
+	d, _ = buffer.GetBuffer(context.Background())
+	assert.Equal(t, map[string]string{"1000": "G2", "1002": "Furia"}, d)
+}
+
+// Apply must refuse to run if the state changed after planning
+func TestAlgorandBuffer_ApplyStaleState(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+
+	p, err := buffer.Plan(context.Background(), map[string]string{"1000": "Astralis"})
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1001": "OG"}))
+	assert.Equal(t, ErrStateChanged, buffer.Apply(context.Background(), p))
+}
+
+func TestAlgorandBuffer_PlanViolations(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+
+	desired := make(map[string]string, client.GlobalBytes+1)
+	for i := 0; i <= client.GlobalBytes; i++ {
+		desired[strconv.Itoa(i)] = ""
+	}
+	p, err := buffer.Plan(context.Background(), desired)
+	assert.Nil(t, err)
+	assert.Len(t, p.Violations, 1)
+	assert.NotNil(t, buffer.Apply(context.Background(), p))
+}
+
+// The plan must count every transaction Apply sends, including the transactions of
+// the trailer of reserved keys
+func TestAlgorandBuffer_PlanCountsTrailer(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	sent := 0
+	hook := func(WriteResult) { sent++ }
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithMetadata(), WithChecksum(), WithWriteHook(hook))
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"}))
+
+	// the eight pairs fill a transaction, so the trailer needs another one
+	desired := make(map[string]string)
+	for i := 0; i < client.MaxKVArgs; i++ {
+		desired[strconv.Itoa(i)] = "OG"
+	}
+	p, err := buffer.Plan(context.Background(), desired)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, p.Transactions)
+	sent = 0
+	assert.Nil(t, buffer.Apply(context.Background(), p))
+	assert.Equal(t, p.Transactions, sent)
+}
+
+// Unless the fee is flat, it is a fee per byte, but at least transaction.MinTxnFee
+func TestAlgorandBuffer_PlanFeePerByte(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	desired := map[string]string{"1000": "Astralis", "1001": "OG"}
+
+	c.Params.Fee = 1
+	c.Params.FlatFee = false
+	p, err := buffer.Plan(context.Background(), desired)
+	assert.Nil(t, err)
+	assert.EqualValues(t, transaction.MinTxnFee, p.EstimatedFee)
+
+	c.Params.Fee = 100
+	p, err = buffer.Plan(context.Background(), desired)
+	assert.Nil(t, err)
+	assert.Greater(t, p.EstimatedFee, uint64(transaction.MinTxnFee))
+	assert.Zero(t, p.EstimatedFee%100)
+}
+
+// Keys that don't fit are not violations if the eviction policy makes room for them
+func TestAlgorandBuffer_PlanEviction(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithEvictionPolicy(EvictFIFO))
+	desired := make(map[string]string, client.GlobalBytes+1)
+	for i := 0; i < client.GlobalBytes; i++ {
+		desired[strconv.Itoa(i)] = ""
+	}
+	assert.Nil(t, buffer.PutElements(context.Background(), desired))
+
+	desired["1000"] = "Astralis"
+	p, err := buffer.Plan(context.Background(), desired)
+	assert.Nil(t, err)
+	assert.Len(t, p.Violations, 0)
+	assert.Len(t, p.Evictions, 1)
+	assert.Nil(t, buffer.Apply(context.Background(), p))
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Equal(t, "Astralis", d["1000"])
+	assert.NotContains(t, d, p.Evictions[0])
+}
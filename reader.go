@@ -0,0 +1,92 @@
+package siam
+
+import (
+	"context"
+	"time"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// Reader provides read-only access to a Siam application. In contrast to an
+// AlgorandBuffer, it doesn't need the private key of the application's creator, and
+// can be used by consumers of the oracle data.
+type Reader struct {
+	// AppId is the ID of the Algorand application that is read.
+	AppId uint64
+
+	// Client is the wrapping interface for communicating with the node
+	Client client.AlgorandClient
+
+	// ValueCodecs decode the values, and must match the codecs of the writing
+	// buffer. See WithValueCodec.
+	ValueCodecs []ValueCodec
+
+	// OnChainExpiry strips the expiry of values written with WithOnChainExpiry.
+	OnChainExpiry bool
+
+	// timeoutLength is the default duration for Client requests to timeout.
+	timeoutLength time.Duration
+}
+
+// NewReader creates a Reader for the application with the given ID.
+func NewReader(c client.AlgorandClient, appId uint64) *Reader {
+	return &Reader{AppId: appId, Client: c, timeoutLength: client.AlgorandDefaultTimeout}
+}
+
+// getGlobalState returns the global state of the application, with values exactly as
+// they are stored on-chain.
+func (r *Reader) getGlobalState(ctx context.Context) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeoutLength)
+	app, err := r.Client.GetApplicationByID(r.AppId, ctx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	return parseGlobalState(app), nil
+}
+
+// GetBufferRaw returns the stored global state of the application, decoded with the
+// ValueCodecs of the Reader. Reserved keys are not included. If the state doesn't match
+// its checksum, ErrTornRead is returned.
+func (r *Reader) GetBufferRaw(ctx context.Context) (map[string][]byte, error) {
+	state, err := r.getGlobalState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyChecksum(state); err != nil {
+		return nil, err
+	}
+	return decodeValues(state, r.ValueCodecs, r.OnChainExpiry)
+}
+
+// GetBuffer returns the stored global state of the application, with string values.
+func (r *Reader) GetBuffer(ctx context.Context) (map[string]string, error) {
+	b, err := r.GetBufferRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(b))
+	for k, v := range b {
+		m[k] = string(v)
+	}
+	return m, nil
+}
+
+// Metadata returns the metadata of the application. See WithMetadata.
+func (r *Reader) Metadata(ctx context.Context) (Metadata, error) {
+	state, err := r.getGlobalState(ctx)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return decodeMetadata(state), nil
+}
+
+// Fresh returns true if the oracle wrote data or a heartbeat within the given
+// duration. Returns false for applications that don't maintain metadata.
+func (r *Reader) Fresh(ctx context.Context, maxAge time.Duration) (bool, error) {
+	m, err := r.Metadata(ctx)
+	if err != nil {
+		return false, err
+	}
+	return m.Fresh(maxAge), nil
+}
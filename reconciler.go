@@ -0,0 +1,200 @@
+package siam
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultReconcileInterval is the default duration between two reconciliation passes
+// of a Reconciler.
+const DefaultReconcileInterval = time.Minute
+
+// ReconcileEvent describes a reconciliation pass that found drift or failed.
+type ReconcileEvent struct {
+	Time time.Time
+
+	// Put contains the pairs that were missing or had a different value on-chain.
+	Put map[string]string
+
+	// Deleted contains the keys that existed on-chain, but not in the desired state.
+	Deleted []string
+
+	// Err is set if the pass could not read or repair the on-chain state.
+	Err error
+}
+
+// Reconciler holds a desired state and continuously enforces it on an AlgorandBuffer.
+// Every Interval, the on-chain state is compared with the desired state, and drift
+// caused by external changes or partially failed writes is re-applied. The desired
+// state can be persisted to a local file, so that a restarted process converges
+// without re-sending keys that are already correct.
+//
+// In contrast to AchieveDesiredState, which is a one-shot operation, the Reconciler
+// is meant to run for the lifetime of the process:
+//
+//	r, err := NewReconciler(buffer, "/var/lib/siam/desired.json")
+//	err = r.SetDesiredState(data)
+//	wg := r.SpawnReconcilingRoutine(ctx)
+type Reconciler struct {
+	// Buffer is the AlgorandBuffer whose state is enforced.
+	Buffer *AlgorandBuffer
+
+	// Interval is the duration between two reconciliation passes.
+	Interval time.Duration
+
+	// Events receives an event for every pass that found drift or failed. If
+	// nobody consumes the channel and it is full, events are dropped.
+	Events chan ReconcileEvent
+
+	mu        sync.Mutex
+	desired   map[string]string
+	statePath string
+
+	// kick triggers an immediate pass, e.g. after the desired state changed.
+	kick chan struct{}
+}
+
+// NewReconciler creates a Reconciler for the given buffer. If statePath is not empty,
+// the desired state is persisted to this file, and a previously persisted state is
+// loaded. Until a desired state is set, the Reconciler does nothing.
+func NewReconciler(ab *AlgorandBuffer, statePath string) (*Reconciler, error) {
+	r := &Reconciler{
+		Buffer:    ab,
+		Interval:  DefaultReconcileInterval,
+		Events:    make(chan ReconcileEvent, 16),
+		statePath: statePath,
+		kick:      make(chan struct{}, 1),
+	}
+	if statePath == "" {
+		return r, nil
+	}
+	b, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &r.desired); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetDesiredState replaces the desired state, persists it and triggers a pass of a
+// running reconciling routine.
+func (r *Reconciler) SetDesiredState(desired map[string]string) error {
+	c := make(map[string]string, len(desired))
+	for k, v := range desired {
+		c[k] = v
+	}
+	if err := r.persist(c); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.desired = c
+	r.mu.Unlock()
+
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// DesiredState returns a copy of the current desired state. Returns nil if no desired
+// state has been set.
+func (r *Reconciler) DesiredState() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.desired == nil {
+		return nil
+	}
+	c := make(map[string]string, len(r.desired))
+	for k, v := range r.desired {
+		c[k] = v
+	}
+	return c
+}
+
+// persist atomically writes the desired state to the state file.
+func (r *Reconciler) persist(desired map[string]string) error {
+	if r.statePath == "" {
+		return nil
+	}
+	b, err := json.Marshal(desired)
+	if err != nil {
+		return err
+	}
+	tmp := r.statePath + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.statePath)
+}
+
+// Reconcile runs a single pass. It returns the event describing the pass, and true
+// if drift was found or the pass failed.
+func (r *Reconciler) Reconcile(ctx context.Context) (ReconcileEvent, bool) {
+	desired := r.DesiredState()
+	ev := ReconcileEvent{Time: time.Now()}
+	if desired == nil {
+		return ev, false
+	}
+
+	data, err := r.Buffer.readBuffer(ctx)
+	if err != nil {
+		ev.Err = err
+		return ev, true
+	}
+	put, del := computeOverlap(desired, data)
+	if len(put)+len(del) == 0 {
+		return ev, false
+	}
+	ev.Put = put
+	ev.Deleted = getKeys(del)
+
+	if err = r.Buffer.DeleteElements(ctx, ev.Deleted...); err != nil {
+		ev.Err = err
+		return ev, true
+	}
+	if err = r.Buffer.PutElements(ctx, put); err != nil {
+		ev.Err = err
+	}
+	return ev, true
+}
+
+// SpawnReconcilingRoutine starts a goroutine that reconciles the buffer every
+// Interval, and whenever the desired state changes. The routine exits when ctx is
+// cancelled. Use the returned WaitGroup to wait for the exit.
+func (r *Reconciler) SpawnReconcilingRoutine(ctx context.Context) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+		for {
+			passCtx, cancel := context.WithTimeout(ctx, r.Buffer.timeoutLength)
+			ev, report := r.Reconcile(passCtx)
+			cancel()
+			if report {
+				select {
+				case r.Events <- ev:
+				default:
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-r.kick:
+			}
+		}
+	}()
+	return wg
+}
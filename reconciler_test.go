@@ -0,0 +1,81 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// Drift caused by external writes should be repaired and reported
+func TestReconciler_RepairsDrift(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	r, err := NewReconciler(buffer, "")
+	assert.Nil(t, err)
+
+	desired := map[string]string{"1000": "Astralis", "1001": "Vitality"}
+	assert.Nil(t, r.SetDesiredState(desired))
+	_, drift := r.Reconcile(context.Background())
+	assert.True(t, drift)
+
+	// external change
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "G2", "1002": "OG"}))
+
+	ev, drift := r.Reconcile(context.Background())
+	assert.True(t, drift)
+	assert.Nil(t, ev.Err)
+	assert.Equal(t, map[string]string{"1000": "Astralis"}, ev.Put)
+	assert.Equal(t, []string{"1002"}, ev.Deleted)
+
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Equal(t, desired, d)
+
+	_, drift = r.Reconcile(context.Background())
+	assert.False(t, drift)
+}
+
+// A restarted reconciler should pick up the persisted desired state
+func TestReconciler_PersistsDesiredState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "desired.json")
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+
+	r, err := NewReconciler(buffer, path)
+	assert.Nil(t, err)
+	assert.Nil(t, r.SetDesiredState(map[string]string{"1000": "Astralis"}))
+
+	r, err = NewReconciler(buffer, path)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1000": "Astralis"}, r.DesiredState())
+}
+
+// The reconciling routine should converge and exit when cancelled
+func TestReconciler_Routine(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	r, _ := NewReconciler(buffer, "")
+	r.Interval = time.Millisecond * 10
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := r.SpawnReconcilingRoutine(ctx)
+	assert.Nil(t, r.SetDesiredState(map[string]string{"1000": "Astralis"}))
+
+	select {
+	case ev := <-r.Events:
+		assert.Nil(t, ev.Err)
+	case <-time.After(time.Second):
+		t.Fatal("no reconciliation event")
+	}
+	cancel()
+	wg.Wait()
+
+	contains, err := buffer.Contains(context.Background(), map[string]string{"1000": "Astralis"})
+	assert.Nil(t, err)
+	assert.True(t, contains)
+}
@@ -0,0 +1,91 @@
+package siam
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/algorand/go-algorand-sdk/future"
+	"github.com/algorand/go-algorand-sdk/types"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// RotateKey rekeys the buffer's account to the key of the given signer, and signs with
+// it from then on. The address of the account, and with it the creator of the
+// application and the AppId, don't change. The rekey transaction is signed by the
+// current signer. Pass a signer of the account's own key to undo the rekeying.
+//
+// Before the rekey is sent, newSigner signs a probe transaction, which is verified
+// against its address, so that the account isn't rekeyed to a key the signer doesn't
+// hold. RotateKey must not run concurrently with writes. The old key can't sign for
+// the account anymore once the transaction is confirmed.
+//
+// If sending fails with an unknown outcome, the auth address of the account is read
+// again, and the buffer switches to newSigner if the rekey was applied. Otherwise the
+// error is returned, and the transaction may still be confirmed later; NewAlgorandBuffer
+// then fails with *ErrAuthAddrMismatch for the old signer.
+func (ab *AlgorandBuffer) RotateKey(ctx context.Context, newSigner client.Signer) error {
+	addr := ab.Signer.Address()
+	params, err := ab.Client.SuggestedParams(ctx)
+	if err != nil {
+		return err
+	}
+	probe, err := client.GenerateApplicationCallTx(ab.AppId, newSigner, params, types.NoOpOC)
+	if err != nil {
+		return err
+	}
+	probe.Note = []byte("rekey probe")
+	if err = client.VerifySigner(ctx, newSigner, probe); err != nil {
+		return fmt.Errorf("new signer can't sign for %s: %s", newSigner.Address(), err)
+	}
+	txn, err := future.MakePaymentTxn(addr.String(), addr.String(), 0, []byte("rekey"), "", params)
+	if err != nil {
+		return err
+	}
+	txn.RekeyTo = newSigner.Address()
+	if _, err = ab.Client.ExecuteTransaction(ab.Signer, txn, ctx); err != nil {
+		if client.IsRejected(err) || !ab.rekeyedTo(newSigner.Address()) {
+			return err
+		}
+	}
+	if newSigner.Address() == addr {
+		ab.Signer = newSigner
+	} else {
+		ab.Signer = client.NewRekeyedSigner(addr, newSigner)
+	}
+	return nil
+}
+
+// rekeyedTo returns true if the account of the buffer is known to be rekeyed to auth,
+// or to be no longer rekeyed if auth is its own address.
+func (ab *AlgorandBuffer) rekeyedTo(auth types.Address) bool {
+	// ctx of the rekey may be done already
+	ctx, cancel := context.WithTimeout(context.Background(), ab.timeoutLength)
+	defer cancel()
+	addr := ab.Signer.Address()
+	info, err := ab.Client.AccountInformation(addr.String(), ctx)
+	if err != nil {
+		return false
+	}
+	if auth == addr {
+		return info.AuthAddr == ""
+	}
+	return info.AuthAddr == auth.String()
+}
+
+// checkAuthAddr verifies that the signer signs with the key that is authorized for
+// the account on-chain.
+func (ab *AlgorandBuffer) checkAuthAddr(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+	defer cancel()
+	addr := ab.Signer.Address()
+	info, err := ab.Client.AccountInformation(addr.String(), ctx)
+	if err != nil {
+		return err
+	}
+	auth := client.AuthAddress(ab.Signer)
+	if info.AuthAddr == "" && auth == addr || info.AuthAddr == auth.String() {
+		return nil
+	}
+	return &ErrAuthAddrMismatch{Address: addr, AuthAddr: info.AuthAddr, Signer: auth}
+}
@@ -0,0 +1,93 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateKey(t *testing.T) {
+	a, b, c := crypto.GenerateAccount(), crypto.GenerateAccount(), crypto.GenerateAccount()
+	algod := client.CreateAlgorandClientMock("", "")
+	algod.Params.GenesisHash = make([]byte, 32)
+	buffer, err := NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(a))
+	assert.Nil(t, err)
+	appId := buffer.AppId
+
+	assert.Nil(t, buffer.RotateKey(context.Background(), client.NewAccountSigner(b)))
+	assert.Equal(t, b.Address.String(), algod.Account.AuthAddr)
+	assert.Equal(t, a.Address, buffer.Signer.Address())
+	assert.Equal(t, b.Address, client.AuthAddress(buffer.Signer))
+	assert.Equal(t, appId, buffer.AppId)
+
+	// the old key is rejected on startup
+	_, err = NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(a))
+	assert.EqualError(t, err, "account "+a.Address.String()+" is rekeyed to "+b.Address.String()+
+		", but the signer signs with "+a.Address.String())
+	restarted, err := NewAlgorandBufferWithSigner(algod, client.NewRekeyedSigner(a.Address, client.NewAccountSigner(b)))
+	assert.Nil(t, err)
+	assert.Equal(t, appId, restarted.AppId)
+
+	// signers that don't hold the key of their address are refused
+	wrong := client.NewRekeyedSigner(crypto.GenerateAccount().Address, client.NewAccountSigner(c))
+	err = buffer.RotateKey(context.Background(), wrong)
+	assert.Contains(t, err.Error(), "new signer can't sign for "+wrong.Address().String())
+	assert.Equal(t, b.Address.String(), algod.Account.AuthAddr)
+
+	// rotating again is signed by the current key
+	assert.Nil(t, buffer.RotateKey(context.Background(), client.NewAccountSigner(c)))
+	assert.Equal(t, c.Address.String(), algod.Account.AuthAddr)
+
+	algod.SetError(true, (*client.AlgorandMock).ExecuteTransaction)
+	assert.NotNil(t, buffer.RotateKey(context.Background(), client.NewAccountSigner(a)))
+	assert.Equal(t, c.Address, client.AuthAddress(buffer.Signer))
+	algod.ClearFunctionErrors()
+
+	// the outcome is unknown, but the rekey was confirmed
+	buffer.Client = lostResponseMock{algod}
+	assert.Nil(t, buffer.RotateKey(context.Background(), client.NewAccountSigner(b)))
+	assert.Equal(t, b.Address.String(), algod.Account.AuthAddr)
+	assert.Equal(t, b.Address, client.AuthAddress(buffer.Signer))
+	buffer.Client = algod
+
+	// rekeying back to the account's own key
+	assert.Nil(t, buffer.RotateKey(context.Background(), client.NewAccountSigner(a)))
+	assert.Empty(t, algod.Account.AuthAddr)
+	assert.IsType(t, &client.AccountSigner{}, buffer.Signer)
+	_, err = NewAlgorandBufferWithSigner(algod, client.NewRekeyedSigner(a.Address, client.NewAccountSigner(c)))
+	assert.IsType(t, &ErrAuthAddrMismatch{}, err)
+}
+
+// lostResponseMock confirms transactions, but fails to report it.
+type lostResponseMock struct {
+	*client.AlgorandMock
+}
+
+func (m lostResponseMock) ExecuteTransaction(s client.Signer, txn types.Transaction, ctx context.Context) (models.PendingTransactionInfoResponse, error) {
+	if _, err := m.AlgorandMock.ExecuteTransaction(s, txn, ctx); err != nil {
+		return models.PendingTransactionInfoResponse{}, err
+	}
+	return models.PendingTransactionInfoResponse{}, errors.New("connection reset by peer")
+}
+
+func TestConfigRekeyedAccount(t *testing.T) {
+	clearEnv(t)
+	a := crypto.GenerateAccount()
+	key, _ := FormatBase64.Encode(crypto.GenerateAccount().PrivateKey)
+	cfg := &Config{Key: KeyConfig{PrivateKey: key, Account: a.Address.String()}}
+	s, err := cfg.Signer()
+	assert.Nil(t, err)
+	assert.Equal(t, a.Address, s.Address())
+	assert.IsType(t, &client.RekeyedSigner{}, s)
+
+	cfg.Key.Account = "nope"
+	assert.Contains(t, cfg.Validate().Error(), `key: invalid account "nope"`)
+}
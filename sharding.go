@@ -0,0 +1,526 @@
+package siam
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/m2q/algo-siam/client"
+)
+
+// DirectoryShardPrefix is the prefix of the reserved keys in a directory application.
+// The key DirectoryShardPrefix + "<n>" holds the application ID of the n-th shard as
+// 8-byte big endian integer.
+const DirectoryShardPrefix = ReservedPrefix + "shard:"
+
+// DirectoryMarkerKey marks the directory application of a ShardedBuffer. Only an
+// application carrying it is used as directory.
+const DirectoryMarkerKey = ReservedPrefix + "shard"
+
+// DefaultMaxShards is the limit of shards if ShardedBuffer.MaxShards is 0.
+const DefaultMaxShards = 16
+
+// shardReplicas is the number of points every shard has on the hash ring.
+const shardReplicas = 64
+
+// ShardedBuffer stores key-value pairs in several applications (shards) of the same
+// account, so it isn't limited to the 64 keys of a single application. Every key is
+// routed to a shard by consistent hashing. When a write doesn't fit into its shards,
+// a new shard is created, and the keys that are routed to it are migrated.
+//
+// The shards are listed in a directory application, so consumers can find them with
+// a ShardedReader knowing only the directory ID. The account should not be used for
+// anything else. A plain AlgorandBuffer refuses to start for it with *ErrShardedAccount,
+// since it would delete the additional applications.
+type ShardedBuffer struct {
+	// DirectoryId is the ID of the directory application, which lists the shards.
+	DirectoryId uint64
+
+	// MaxShards limits the number of shards. When the limit is reached, writes that
+	// don't fit fail with an *ErrCapacityExceeded. 0 means DefaultMaxShards.
+	MaxShards int
+
+	// Client is the wrapping interface for communicating with the node
+	Client client.AlgorandClient
+
+	// Signer signs for the owner of the directory and all shards.
+	Signer client.Signer
+
+	opts      []BufferOption
+	directory *AlgorandBuffer
+
+	mu     sync.Mutex
+	shards []*AlgorandBuffer
+	ring   *hashRing
+}
+
+// NewShardedBuffer creates a ShardedBuffer for the account with the given base64
+// encoded private key. If the account has no directory application yet, the directory
+// and the first shard are created. The options are applied to every shard; WithJournal
+// is not supported.
+func NewShardedBuffer(c client.AlgorandClient, b64key string, opts ...BufferOption) (*ShardedBuffer, error) {
+	pk, err := base64.StdEncoding.DecodeString(b64key)
+	if err != nil {
+		return nil, err
+	}
+	account, err := crypto.AccountFromPrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	return NewShardedBufferWithSigner(c, client.NewAccountSigner(account), opts...)
+}
+
+// NewShardedBufferWithSigner creates a ShardedBuffer like NewShardedBuffer, for the
+// account of the given client.Signer.
+func NewShardedBufferWithSigner(c client.AlgorandClient, signer client.Signer, opts ...BufferOption) (*ShardedBuffer, error) {
+	sb := &ShardedBuffer{Client: c, Signer: signer, opts: opts}
+	var err error
+	if sb.directory, err = newBuffer(c, signer); err != nil {
+		return nil, err
+	}
+	if err = sb.directory.checkConnection(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.AlgorandDefaultTimeout)
+	defer cancel()
+	if err = sb.load(ctx); err != nil {
+		return nil, err
+	}
+	if len(sb.shards) == 0 {
+		err = sb.addShard(ctx)
+	}
+	return sb, err
+}
+
+// load finds the directory application of the account, which carries the
+// DirectoryMarkerKey, and attaches its shards. If there is none, a new directory is
+// created.
+func (sb *ShardedBuffer) load(ctx context.Context) error {
+	info, err := sb.Client.AccountInformation(sb.Signer.Address().String(), ctx)
+	if err != nil {
+		return err
+	}
+	for _, app := range info.CreatedApps {
+		state := parseGlobalState(app)
+		if !client.FulfillsSchema(app) || !isDirectory(state) {
+			continue
+		}
+		sb.DirectoryId = app.Id
+		sb.directory.AppId = app.Id
+		ids := directoryShards(state)
+		for _, id := range ids {
+			shard, err := sb.attach(id)
+			if err != nil {
+				return err
+			}
+			sb.shards = append(sb.shards, shard)
+		}
+		if len(ids) > 0 {
+			sb.ring = newHashRing(ids)
+		}
+		return nil
+	}
+
+	id, err := sb.Client.CreateApplication(sb.Signer, client.ApproveTeal, client.ClearTeal)
+	if err != nil {
+		return err
+	}
+	sb.DirectoryId = id
+	sb.directory.AppId = id
+	return sb.directory.commit(ctx, []txnOp{{put: map[string][]byte{DirectoryMarkerKey: itob(1)}}})
+}
+
+// attach creates the AlgorandBuffer of the shard with the given application ID.
+func (sb *ShardedBuffer) attach(appId uint64) (*AlgorandBuffer, error) {
+	shard, err := newBuffer(sb.Client, sb.Signer, sb.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if shard.journal != nil {
+		_ = shard.Close()
+		return nil, errors.New("journals are not supported for shards")
+	}
+	shard.AppId = appId
+	return shard, nil
+}
+
+// Shards returns the application IDs of all shards.
+func (sb *ShardedBuffer) Shards() []uint64 {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.idsLocked()
+}
+
+// GetBuffer returns the pairs of all shards.
+func (sb *ShardedBuffer) GetBuffer(ctx context.Context) (map[string]string, error) {
+	b, err := sb.GetBufferRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(b))
+	for k, v := range b {
+		m[k] = string(v)
+	}
+	return m, nil
+}
+
+// GetBufferRaw returns the pairs of all shards, with []byte values.
+func (sb *ShardedBuffer) GetBufferRaw(ctx context.Context) (map[string][]byte, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	m := make(map[string][]byte)
+	for i, shard := range sb.shards {
+		data, err := shard.GetBufferRaw(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mergeShard(m, data, sb.ring, i)
+	}
+	return m, nil
+}
+
+// PutElements stores the given pairs in their shards.
+func (sb *ShardedBuffer) PutElements(ctx context.Context, data map[string]string) error {
+	return sb.PutElementsRaw(ctx, toBytes(data))
+}
+
+// PutElementsRaw stores the given pairs with []byte values in their shards. Pairs are
+// grouped by shard, and each group is written like AlgorandBuffer.PutElementsRaw. If
+// a group doesn't fit into its shard, new shards are created first.
+func (sb *ShardedBuffer) PutElementsRaw(ctx context.Context, data map[string][]byte) error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for {
+		err := sb.checkShards(ctx, data)
+		if _, ok := err.(*ErrCapacityExceeded); !ok {
+			if err != nil {
+				return err
+			}
+			break
+		}
+		if len(sb.shards) >= sb.maxShards() {
+			return err
+		}
+		if err = sb.addShard(ctx); err != nil {
+			return err
+		}
+	}
+	for i, group := range sb.group(data) {
+		if err := sb.shards[i].PutElementsRaw(ctx, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteElements removes the given keys from their shards.
+func (sb *ShardedBuffer) DeleteElements(ctx context.Context, keys ...string) error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	groups := make(map[int][]string)
+	for _, k := range keys {
+		i := sb.ring.shard(k)
+		groups[i] = append(groups[i], k)
+	}
+	for i, group := range groups {
+		if err := sb.shards[i].DeleteElements(ctx, group...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkShards returns an *ErrCapacityExceeded if the pairs don't fit into their shards.
+func (sb *ShardedBuffer) checkShards(ctx context.Context, data map[string][]byte) error {
+	for i, group := range sb.group(data) {
+		shard := sb.shards[i]
+		state, err := shard.getGlobalState(ctx)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(group))
+		for k := range group {
+			keys = append(keys, k)
+		}
+		if err = checkCapacity(shard.withReserved(state), keys, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// group splits the given pairs by the index of their shard.
+func (sb *ShardedBuffer) group(data map[string][]byte) map[int]map[string][]byte {
+	groups := make(map[int]map[string][]byte)
+	for k, v := range data {
+		i := sb.ring.shard(k)
+		if groups[i] == nil {
+			groups[i] = make(map[string][]byte)
+		}
+		groups[i][k] = v
+	}
+	return groups
+}
+
+// addShard creates a new shard and migrates the keys that are routed to it. The keys
+// are copied to the new shard before it is listed in the directory, and deleted from
+// their old shards afterwards, so readers find them at any time. The expiry of keys
+// written with PutWithTTL is not migrated.
+func (sb *ShardedBuffer) addShard(ctx context.Context) error {
+	id, err := sb.Client.CreateApplication(sb.Signer, client.ApproveTeal, client.ClearTeal)
+	if err != nil {
+		return err
+	}
+	shard, err := sb.attach(id)
+	if err != nil {
+		return err
+	}
+	ids := append(sb.idsLocked(), id)
+	ring := newHashRing(ids)
+	index := len(ids) - 1
+
+	moved := make(map[int][]string)
+	for i, old := range sb.shards {
+		data, err := old.readBufferRaw(ctx)
+		if err != nil {
+			return err
+		}
+		migrate := make(map[string][]byte)
+		for k, v := range data {
+			if ring.shard(k) == index {
+				migrate[k] = v
+				moved[i] = append(moved[i], k)
+			}
+		}
+		if err = shard.PutElementsRaw(ctx, migrate); err != nil {
+			return err
+		}
+	}
+
+	err = sb.directory.commit(ctx, []txnOp{{put: map[string][]byte{
+		DirectoryShardPrefix + strconv.Itoa(index): itob(id),
+	}}})
+	if err != nil {
+		return err
+	}
+	sb.shards = append(sb.shards, shard)
+	sb.ring = ring
+
+	for i, keys := range moved {
+		if err = sb.shards[i].DeleteElements(ctx, keys...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxShards returns the limit of shards.
+func (sb *ShardedBuffer) maxShards() int {
+	if sb.MaxShards <= 0 {
+		return DefaultMaxShards
+	}
+	return sb.MaxShards
+}
+
+// idsLocked returns the application IDs of all shards. sb.mu must be held, or the
+// buffer not be shared yet.
+func (sb *ShardedBuffer) idsLocked() []uint64 {
+	ids := make([]uint64, len(sb.shards))
+	for i, s := range sb.shards {
+		ids[i] = s.AppId
+	}
+	return ids
+}
+
+// ShardedReader provides read-only access to the shards of a ShardedBuffer, given the
+// ID of its directory application.
+type ShardedReader struct {
+	// DirectoryId is the ID of the directory application, which lists the shards.
+	DirectoryId uint64
+
+	// Client is the wrapping interface for communicating with the node
+	Client client.AlgorandClient
+
+	// Configure is called for the Reader of every shard, e.g. to set its ValueCodecs.
+	// Call Refresh after changing it.
+	Configure func(r *Reader)
+
+	mu      sync.Mutex
+	readers []*Reader
+	ring    *hashRing
+}
+
+// NewShardedReader creates a ShardedReader and loads the directory.
+func NewShardedReader(c client.AlgorandClient, directoryId uint64) (*ShardedReader, error) {
+	sr := &ShardedReader{DirectoryId: directoryId, Client: c}
+	return sr, sr.Refresh(context.Background())
+}
+
+// Refresh reloads the list of shards from the directory. Call it when keys are
+// missing, since the ShardedBuffer may have added shards.
+func (sr *ShardedReader) Refresh(ctx context.Context) error {
+	state, err := NewReader(sr.Client, sr.DirectoryId).getGlobalState(ctx)
+	if err != nil {
+		return err
+	}
+	ids := directoryShards(state)
+	if !isDirectory(state) || len(ids) == 0 {
+		return fmt.Errorf("application %d is not a shard directory", sr.DirectoryId)
+	}
+	readers := make([]*Reader, len(ids))
+	for i, id := range ids {
+		readers[i] = NewReader(sr.Client, id)
+		if sr.Configure != nil {
+			sr.Configure(readers[i])
+		}
+	}
+	sr.mu.Lock()
+	sr.readers, sr.ring = readers, newHashRing(ids)
+	sr.mu.Unlock()
+	return nil
+}
+
+// Shards returns the application IDs of all shards.
+func (sr *ShardedReader) Shards() []uint64 {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	ids := make([]uint64, len(sr.readers))
+	for i, r := range sr.readers {
+		ids[i] = r.AppId
+	}
+	return ids
+}
+
+// GetBuffer returns the pairs of all shards.
+func (sr *ShardedReader) GetBuffer(ctx context.Context) (map[string]string, error) {
+	b, err := sr.GetBufferRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(b))
+	for k, v := range b {
+		m[k] = string(v)
+	}
+	return m, nil
+}
+
+// GetBufferRaw returns the pairs of all shards, with []byte values.
+func (sr *ShardedReader) GetBufferRaw(ctx context.Context) (map[string][]byte, error) {
+	sr.mu.Lock()
+	readers, ring := sr.readers, sr.ring
+	sr.mu.Unlock()
+	m := make(map[string][]byte)
+	for i, r := range readers {
+		data, err := r.GetBufferRaw(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mergeShard(m, data, ring, i)
+	}
+	return m, nil
+}
+
+// Get returns the value of a single key, reading only the shard it is routed to.
+func (sr *ShardedReader) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	sr.mu.Lock()
+	r := sr.readers[sr.ring.shard(key)]
+	sr.mu.Unlock()
+	data, err := r.GetBufferRaw(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	v, ok := data[key]
+	return v, ok, nil
+}
+
+// mergeShard adds the pairs of the i-th shard to m. Pairs that are not routed to the
+// shard are left over from a migration, and skipped.
+func mergeShard(m, data map[string][]byte, ring *hashRing, i int) {
+	for k, v := range data {
+		if ring.shard(k) == i {
+			m[k] = v
+		}
+	}
+}
+
+// isDirectory returns true if the state carries the DirectoryMarkerKey.
+func isDirectory(state map[string][]byte) bool {
+	_, ok := state[DirectoryMarkerKey]
+	return ok
+}
+
+// directoryShards returns the shard IDs listed in the state of a directory application,
+// ordered by their index.
+func directoryShards(state map[string][]byte) []uint64 {
+	byIndex := make(map[int]uint64)
+	for k, v := range state {
+		if !strings.HasPrefix(k, DirectoryShardPrefix) {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimPrefix(k, DirectoryShardPrefix))
+		id, ok := btoi(v)
+		if err == nil && ok {
+			byIndex[i] = id
+		}
+	}
+	ids := make([]uint64, 0, len(byIndex))
+	for i := 0; ; i++ {
+		id, ok := byIndex[i]
+		if !ok {
+			return ids
+		}
+		ids = append(ids, id)
+	}
+}
+
+// hashRing routes keys to shards by consistent hashing. Adding a shard only moves the
+// keys that are routed to the new shard.
+type hashRing struct {
+	points []uint64
+	shards []int
+}
+
+// newHashRing creates a ring for the shards with the given application IDs. Every
+// shard gets shardReplicas points, at the first 8 bytes of
+// sha256(itob(appId) || itob(replica)). A key is routed to the shard of the first point
+// at or after the first 8 bytes of sha256(key).
+func newHashRing(ids []uint64) *hashRing {
+	type point struct {
+		pos   uint64
+		shard int
+	}
+	points := make([]point, 0, len(ids)*shardReplicas)
+	for i, id := range ids {
+		for r := 0; r < shardReplicas; r++ {
+			h := sha256.Sum256(append(itob(id), itob(uint64(r))...))
+			points = append(points, point{binary.BigEndian.Uint64(h[:8]), i})
+		}
+	}
+	sort.Slice(points, func(a, b int) bool { return points[a].pos < points[b].pos })
+
+	ring := &hashRing{points: make([]uint64, len(points)), shards: make([]int, len(points))}
+	for i, p := range points {
+		ring.points[i], ring.shards[i] = p.pos, p.shard
+	}
+	return ring
+}
+
+// shard returns the index of the shard the key is routed to.
+func (r *hashRing) shard(key string) int {
+	h := sha256.Sum256([]byte(key))
+	pos := binary.BigEndian.Uint64(h[:8])
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= pos })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.shards[i]
+}
@@ -0,0 +1,137 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedBuffer_Grows(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.MultipleApps = true
+	key := client.GeneratePrivateKey64()
+	sb, err := NewShardedBuffer(c, key)
+	assert.Nil(t, err)
+	assert.Len(t, sb.Shards(), 1)
+
+	data := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		data["m"+strconv.Itoa(i)] = strconv.Itoa(i)
+	}
+	assert.Nil(t, sb.PutElements(context.Background(), data))
+	assert.GreaterOrEqual(t, len(sb.Shards()), 4)
+
+	got, err := sb.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+
+	// every key lives in exactly one shard
+	total := 0
+	for _, id := range sb.Shards() {
+		state, _ := NewReader(c, id).GetBufferRaw(context.Background())
+		total += len(state)
+	}
+	assert.Equal(t, len(data), total)
+
+	// consumers only need the directory
+	sr, err := NewShardedReader(c, sb.DirectoryId)
+	assert.Nil(t, err)
+	assert.Equal(t, sb.Shards(), sr.Shards())
+	got, err = sr.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+	v, ok, err := sr.Get(context.Background(), "m42")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("42"), v)
+
+	assert.Nil(t, sb.DeleteElements(context.Background(), "m42", "m43"))
+	_, ok, _ = sr.Get(context.Background(), "m42")
+	assert.False(t, ok)
+
+	// a restarted buffer finds its directory and shards
+	restored, err := NewShardedBuffer(c, key)
+	assert.Nil(t, err)
+	assert.Equal(t, sb.DirectoryId, restored.DirectoryId)
+	assert.Equal(t, sb.Shards(), restored.Shards())
+}
+
+func TestShardedBuffer_MaxShards(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.MultipleApps = true
+	sb, _ := NewShardedBuffer(c, client.GeneratePrivateKey64())
+	sb.MaxShards = 2
+
+	data := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		data["m"+strconv.Itoa(i)] = strconv.Itoa(i)
+	}
+	err := sb.PutElements(context.Background(), data)
+	assert.IsType(t, &ErrCapacityExceeded{}, err)
+	assert.Len(t, sb.Shards(), 2)
+
+	// without limit, the default limit applies
+	sb.MaxShards = 0
+	for i := 200; i < 64*DefaultMaxShards+1; i++ {
+		data["m"+strconv.Itoa(i)] = strconv.Itoa(i)
+	}
+	err = sb.PutElements(context.Background(), data)
+	assert.IsType(t, &ErrCapacityExceeded{}, err)
+	assert.Len(t, sb.Shards(), DefaultMaxShards)
+}
+
+// A plain buffer must not delete the shards of the account
+func TestShardedBuffer_AccountProtected(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.MultipleApps = true
+	key := client.GeneratePrivateKey64()
+	sb, err := NewShardedBuffer(c, key)
+	assert.Nil(t, err)
+
+	_, err = NewAlgorandBuffer(c, key)
+	assert.IsType(t, &ErrShardedAccount{}, err)
+	info, _ := c.AccountInformation(sb.Signer.Address().String(), context.Background())
+	assert.Len(t, info.CreatedApps, 2)
+}
+
+// Only an application with the directory marker is used as directory
+func TestShardedBuffer_DirectoryMarker(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.MultipleApps = true
+	signer := client.NewAccountSigner(crypto.GenerateAccount())
+	other, err := c.CreateApplication(signer, client.ApproveTeal, client.ClearTeal)
+	assert.Nil(t, err)
+
+	sb, err := NewShardedBufferWithSigner(c, signer)
+	assert.Nil(t, err)
+	assert.NotEqual(t, other, sb.DirectoryId)
+	info, _ := c.AccountInformation(signer.Address().String(), context.Background())
+	assert.Len(t, info.CreatedApps, 3)
+	_, err = NewShardedReader(c, other)
+	assert.EqualError(t, err, "application "+strconv.FormatUint(other, 10)+" is not a shard directory")
+
+	// a directory left without shards by a crash is used again
+	assert.Nil(t, sb.directory.commit(context.Background(), []txnOp{{del: []string{DirectoryShardPrefix + "0"}}}))
+	restored, err := NewShardedBufferWithSigner(c, signer)
+	assert.Nil(t, err)
+	assert.Equal(t, sb.DirectoryId, restored.DirectoryId)
+	assert.Len(t, restored.Shards(), 1)
+}
+
+func TestHashRing_Consistent(t *testing.T) {
+	before := newHashRing([]uint64{1, 2, 3})
+	after := newHashRing([]uint64{1, 2, 3, 4})
+	for i := 0; i < 1000; i++ {
+		k := strconv.Itoa(i)
+		// keys either stay, or move to the new shard
+		if s := after.shard(k); s != 3 {
+			assert.Equal(t, before.shard(k), s)
+		}
+	}
+}
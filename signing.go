@@ -0,0 +1,109 @@
+package siam
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SignatureOverhead is the number of bytes that signing adds to a value: the round
+// (8 bytes) and the ed25519 signature (64 bytes). It counts towards the 128 byte pair
+// limit.
+const SignatureOverhead = 8 + ed25519.SignatureSize
+
+// WithSigning signs every value with the given oracle signing key, which should be
+// different from the key of the creator account. Values are stored as
+// payload || round || signature, where round is the last round at the time of writing.
+// The signature covers the application ID, the key, the payload and the round, see
+// SignedMessage.
+//
+// Consumers verify values off-chain with VerifyValue or a SignatureVerifier, and
+// on-chain with the TEAL subroutine client.VerifySignedTeal. Since the signature
+// doesn't depend on the sender, signed values can be relayed or cached without losing
+// authenticity. Add this option after all other value codecs.
+func WithSigning(key ed25519.PrivateKey) BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		if len(key) != ed25519.PrivateKeySize {
+			return fmt.Errorf("signing key must have %d bytes", ed25519.PrivateKeySize)
+		}
+		ab.codecs = append(ab.codecs, &signingCodec{buffer: ab, key: key})
+		return nil
+	}
+}
+
+// SignedMessage returns the message that is signed for a value:
+// itob(appId) || len(key) || key || payload || itob(round).
+func SignedMessage(appId uint64, key string, payload []byte, round uint64) []byte {
+	m := make([]byte, 0, 8+1+len(key)+len(payload)+8)
+	m = append(m, itob(appId)...)
+	m = append(m, byte(len(key)))
+	m = append(m, key...)
+	m = append(m, payload...)
+	return append(m, itob(round)...)
+}
+
+// SignValue signs the payload and returns the value as it is stored on-chain:
+// payload || itob(round) || signature.
+func SignValue(signingKey ed25519.PrivateKey, appId uint64, key string, payload []byte, round uint64) []byte {
+	sig := ed25519.Sign(signingKey, SignedMessage(appId, key, payload, round))
+	v := make([]byte, 0, len(payload)+SignatureOverhead)
+	v = append(v, payload...)
+	v = append(v, itob(round)...)
+	return append(v, sig...)
+}
+
+// VerifyValue verifies a signed value of the given application and key, and returns
+// the payload and the round it was signed at. Returns ErrInvalidSignature if the
+// signature doesn't verify.
+func VerifyValue(pub ed25519.PublicKey, appId uint64, key string, value []byte) (payload []byte, round uint64, err error) {
+	if len(value) < SignatureOverhead {
+		return nil, 0, errors.New("signed value too short")
+	}
+	n := len(value) - SignatureOverhead
+	payload, sig := value[:n], value[n+8:]
+	round = binary.BigEndian.Uint64(value[n : n+8])
+	if !ed25519.Verify(pub, SignedMessage(appId, key, payload, round), sig) {
+		return nil, 0, ErrInvalidSignature
+	}
+	return payload, round, nil
+}
+
+// SignatureVerifier is a ValueCodec for Reader.ValueCodecs that verifies signed values
+// and strips the round and signature. It can't encode values.
+type SignatureVerifier struct {
+	AppId     uint64
+	PublicKey ed25519.PublicKey
+}
+
+func (v *SignatureVerifier) Encode(string, []byte) ([]byte, error) {
+	return nil, errors.New("a SignatureVerifier can't sign values")
+}
+
+func (v *SignatureVerifier) Decode(key string, value []byte) ([]byte, error) {
+	payload, _, err := VerifyValue(v.PublicKey, v.AppId, key, value)
+	return payload, err
+}
+
+// signingCodec signs values for the application of its buffer.
+type signingCodec struct {
+	buffer *AlgorandBuffer
+	key    ed25519.PrivateKey
+}
+
+func (s *signingCodec) Encode(key string, value []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.buffer.timeoutLength)
+	status, err := s.buffer.Client.Status(ctx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	return SignValue(s.key, s.buffer.AppId, key, value, status.LastRound), nil
+}
+
+func (s *signingCodec) Decode(key string, value []byte) ([]byte, error) {
+	pub := s.key.Public().(ed25519.PublicKey)
+	payload, _, err := VerifyValue(pub, s.buffer.AppId, key, value)
+	return payload, err
+}
@@ -0,0 +1,68 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignValue(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	v := SignValue(priv, 42, "1000", []byte("Astralis"), 7)
+	assert.Len(t, v, len("Astralis")+SignatureOverhead)
+
+	payload, round, err := VerifyValue(pub, 42, "1000", v)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Astralis"), payload)
+	assert.EqualValues(t, 7, round)
+
+	// signatures are bound to app, key, payload and round
+	_, _, err = VerifyValue(pub, 43, "1000", v)
+	assert.Equal(t, ErrInvalidSignature, err)
+	_, _, err = VerifyValue(pub, 42, "1001", v)
+	assert.Equal(t, ErrInvalidSignature, err)
+	v[len("Astralis")+7]++
+	_, _, err = VerifyValue(pub, 42, "1000", v)
+	assert.Equal(t, ErrInvalidSignature, err)
+	_, _, err = VerifyValue(pub, 42, "1000", []byte("short"))
+	assert.NotNil(t, err)
+}
+
+func TestAlgorandBuffer_Signing(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	c.NodeStatus.LastRound = 1337
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	buffer, err := NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithSigning(priv))
+	assert.Nil(t, err)
+	data := map[string]string{"1000": "Astralis"}
+	assert.Nil(t, buffer.PutElements(context.Background(), data))
+
+	got, err := buffer.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+
+	state, _ := buffer.getGlobalState(context.Background())
+	_, round, err := VerifyValue(pub, buffer.AppId, "1000", state["1000"])
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1337, round)
+
+	r := NewReader(c, buffer.AppId)
+	r.ValueCodecs = []ValueCodec{&SignatureVerifier{AppId: buffer.AppId, PublicKey: pub}}
+	got, err = r.GetBuffer(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+
+	// values signed by someone else are rejected
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	r.ValueCodecs = []ValueCodec{&SignatureVerifier{AppId: buffer.AppId, PublicKey: otherPub}}
+	_, err = r.GetBuffer(context.Background())
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = NewAlgorandBuffer(c, client.GeneratePrivateKey64(), WithSigning([]byte("short")))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,211 @@
+package siam
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// ExpiryHeader is the first byte of values that carry an on-chain expiry. It is
+// followed by the bytes "ttl", the expiry as 8-byte big endian unix timestamp, and
+// the actual value. See WithOnChainExpiry.
+const ExpiryHeader byte = 0xfe
+
+// expiryMarker is the start of values that carry an on-chain expiry. The contract
+// only sweeps values that start with it.
+const expiryMarker = "\xfettl"
+
+// expiryOverhead is the number of bytes an expiry adds to a value.
+const expiryOverhead = len(expiryMarker) + 8
+
+// WithOnChainExpiry stores the expiry of keys written with PutWithTTL inside their
+// values. Consumers can use DecodeExpiry to tell a stale value from a current one.
+// If the creator of the application calls EnableSweeping, the contract also allows
+// anyone to delete expired keys with a "sweep" call. Without this option, expiry
+// times are only tracked locally and lost on restart.
+func WithOnChainExpiry() BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		ab.onChainExpiry = true
+		return nil
+	}
+}
+
+// EncodeExpiry prefixes a value with the ExpiryHeader and the given expiry.
+func EncodeExpiry(value []byte, expires time.Time) []byte {
+	b := make([]byte, expiryOverhead, expiryOverhead+len(value))
+	copy(b, expiryMarker)
+	binary.BigEndian.PutUint64(b[len(expiryMarker):], uint64(expires.Unix()))
+	return append(b, value...)
+}
+
+// DecodeExpiry splits a value written with on-chain expiry into the actual value and
+// its expiry time. ok is false if the value carries no expiry.
+func DecodeExpiry(b []byte) (value []byte, expires time.Time, ok bool) {
+	if len(b) < expiryOverhead || string(b[:len(expiryMarker)]) != expiryMarker {
+		return b, time.Time{}, false
+	}
+	ts := int64(binary.BigEndian.Uint64(b[len(expiryMarker):expiryOverhead]))
+	return b[expiryOverhead:], time.Unix(ts, 0), true
+}
+
+// EnableSweeping allows or forbids anyone to delete expired keys with a "sweep" call
+// to the contract. Sweeping is disabled by default. Only keys written by PutWithTTL
+// with WithOnChainExpiry can be swept, and only after they expired.
+//
+// Sweeps can't update the checksum, so the contract refuses them while the application
+// has a ChecksumKey, and sweeping can't be enabled by a buffer using WithChecksum. Only
+// the creator of the application can enable sweeping, otherwise ErrNotCreator is
+// returned. The flag occupies one slot of the application.
+func (ab *AlgorandBuffer) EnableSweeping(ctx context.Context, enable bool) error {
+	if ab.writer {
+		return ErrNotCreator
+	}
+	if !enable {
+		return ab.commit(ctx, []txnOp{{del: []string{client.SweepKey}}})
+	}
+	if ab.checksum {
+		return errors.New("sweeping can't be enabled with a checksum")
+	}
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return err
+	}
+	if err = checkCapacity(ab.withReserved(state), []string{client.SweepKey}, nil); err != nil {
+		return ab.writeFailed(nil, map[string][]byte{client.SweepKey: itob(1)}, err)
+	}
+	return ab.commit(ctx, []txnOp{{put: map[string][]byte{client.SweepKey: itob(1)}}})
+}
+
+// PutWithTTL stores given key-value pairs like PutElements. The keys expire after the
+// given duration, and are deleted by Sweep or a sweeping routine.
+func (ab *AlgorandBuffer) PutWithTTL(ctx context.Context, data map[string]string, ttl time.Duration) error {
+	expires := time.Now().Add(ttl)
+	if err := ab.putElements(ctx, toBytes(data), expires); err != nil {
+		return err
+	}
+
+	ab.metaMu.Lock()
+	defer ab.metaMu.Unlock()
+	for k := range data {
+		ab.keyInfo(k).Expires = expires
+	}
+	return nil
+}
+
+// Sweep deletes all keys that expired, and returns them. Expired keys are found in
+// the local metadata and, with on-chain expiry, in the stored values. A writer
+// restricted to a prefix only sweeps keys within its prefix.
+func (ab *AlgorandBuffer) Sweep(ctx context.Context) ([]string, error) {
+	now := time.Now()
+	expired := make(map[string]bool)
+
+	ab.metaMu.Lock()
+	for k, info := range ab.keyMeta {
+		if !info.Expires.IsZero() && !info.Expires.After(now) {
+			expired[k] = true
+		}
+	}
+	ab.metaMu.Unlock()
+
+	if ab.onChainExpiry {
+		state, err := ab.getGlobalState(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for k := range expiredKeys(state, now) {
+			expired[k] = true
+		}
+	}
+
+	keys := make([]string, 0, len(expired))
+	for k := range expired {
+		if strings.HasPrefix(k, ab.writerPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sort.Strings(keys)
+	return keys, ab.DeleteElements(ctx, keys...)
+}
+
+// sweepDelay is how long after their expiry SweepExpired deletes keys. The contract
+// compares expiries with the timestamp of the latest block, which lags behind.
+const sweepDelay = time.Minute
+
+// SweepExpired deletes the expired keys of an application with "sweep" calls, and
+// returns them. Unlike Sweep, it needs no buffer: any account can pay for it, as long
+// as the creator enabled sweeping with EnableSweeping. Only keys written with
+// WithOnChainExpiry can be swept, a minute after they expired.
+func SweepExpired(ctx context.Context, c client.AlgorandClient, s client.Signer, appId uint64) ([]string, error) {
+	app, err := c.GetApplicationByID(appId, ctx)
+	if err != nil {
+		return nil, err
+	}
+	state := parseGlobalState(app)
+	if _, ok := state[client.SweepKey]; !ok {
+		return nil, errors.New("sweeping isn't enabled for the application")
+	}
+
+	keys := make([]string, 0)
+	for k := range expiredKeys(state, time.Now().Add(-sweepDelay)) {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// every argument of a sweep call is a key
+	for i := 0; i < len(keys); i += 2 * client.MaxKVArgs {
+		end := i + 2*client.MaxKVArgs
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err = c.SweepGlobals(s, appId, keys[i:end]...); err != nil {
+			return keys[:i], err
+		}
+	}
+	return keys, nil
+}
+
+// expiredKeys returns the keys of the state whose on-chain expiry isn't after t.
+func expiredKeys(state map[string][]byte, t time.Time) map[string]bool {
+	expired := make(map[string]bool)
+	for k, v := range state {
+		if isReserved(k) {
+			continue
+		}
+		if _, expires, ok := DecodeExpiry(v); ok && !expires.After(t) {
+			expired[k] = true
+		}
+	}
+	return expired
+}
+
+// SpawnSweepingRoutine starts a goroutine that calls Sweep in the given interval.
+// Errors are ignored and retried in the next interval. The routine exits when ctx
+// is cancelled. Use the returned WaitGroup to wait for the exit.
+func (ab *AlgorandBuffer) SpawnSweepingRoutine(ctx context.Context, interval time.Duration) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sweepCtx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+				_, _ = ab.Sweep(sweepCtx)
+				cancel()
+			}
+		}
+	}()
+	return wg
+}
@@ -0,0 +1,172 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlgorandBuffer_SweepLocalExpiry(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis"}))
+	assert.Nil(t, buffer.PutWithTTL(context.Background(), map[string]string{"1001": "OG"}, -time.Second))
+	assert.Nil(t, buffer.PutWithTTL(context.Background(), map[string]string{"1002": "G2"}, time.Hour))
+
+	keys, err := buffer.Sweep(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1001"}, keys)
+
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Equal(t, map[string]string{"1000": "Astralis", "1002": "G2"}, d)
+}
+
+// Rewriting a key without TTL removes its expiry
+func TestAlgorandBuffer_PutClearsExpiry(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+
+	assert.Nil(t, buffer.PutWithTTL(context.Background(), map[string]string{"1001": "OG"}, -time.Second))
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1001": "OG"}))
+
+	keys, err := buffer.Sweep(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, keys, 0)
+}
+
+// With on-chain expiry, a restarted buffer still finds expired keys
+func TestAlgorandBuffer_SweepOnChainExpiry(t *testing.T) {
+	key := client.GeneratePrivateKey64()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, key, WithOnChainExpiry())
+
+	assert.Nil(t, buffer.PutWithTTL(context.Background(), map[string]string{"1001": "OG"}, -time.Second))
+	assert.Nil(t, buffer.PutWithTTL(context.Background(), map[string]string{"1002": "G2"}, time.Hour))
+
+	// values are returned without expiry header
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Equal(t, map[string]string{"1001": "OG", "1002": "G2"}, d)
+
+	// consumers can read the expiry
+	state, _ := buffer.getGlobalState(context.Background())
+	value, expires, ok := DecodeExpiry(state["1002"])
+	assert.True(t, ok)
+	assert.Equal(t, "G2", string(value))
+	assert.True(t, expires.After(time.Now()))
+
+	buffer, _ = NewAlgorandBuffer(c, key, WithOnChainExpiry())
+	keys, err := buffer.Sweep(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1001"}, keys)
+}
+
+func TestAlgorandBuffer_SweepingRoutine(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	assert.Nil(t, buffer.PutWithTTL(context.Background(), map[string]string{"1001": "OG"}, time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := buffer.SpawnSweepingRoutine(ctx, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 100)
+	cancel()
+	wg.Wait()
+
+	d, _ := buffer.GetBuffer(context.Background())
+	assert.Len(t, d, 0)
+}
+
+func TestAlgorandBuffer_EnableSweeping(t *testing.T) {
+	admin, feeder := crypto.GenerateAccount(), crypto.GenerateAccount()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBufferWithSigner(c, client.NewAccountSigner(admin), WithOnChainExpiry())
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	assert.Nil(t, buffer.EnableSweeping(ctx, true))
+	state, _ := buffer.getGlobalState(ctx)
+	assert.Contains(t, state, client.SweepKey)
+	d, _ := buffer.GetBuffer(ctx)
+	assert.Empty(t, d)
+
+	// values carry the expiry marker the contract checks
+	assert.Nil(t, buffer.PutWithTTL(ctx, map[string]string{"1001": "OG"}, time.Hour))
+	state, _ = buffer.getGlobalState(ctx)
+	assert.Equal(t, "\xfettl", string(state["1001"][:4]))
+
+	// writers can't change the flag
+	assert.Nil(t, buffer.AddWriter(ctx, feeder.Address, ""))
+	w, err := NewAlgorandBufferWithSigner(c, client.NewAccountSigner(feeder), WithApplication(buffer.AppId))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrNotCreator, w.EnableSweeping(ctx, false))
+	err = c.UpdateGlobals(client.NewAccountSigner(feeder), buffer.AppId, []string{client.SweepKey}, nil)
+	assert.True(t, client.IsRejected(err))
+
+	assert.Nil(t, buffer.EnableSweeping(ctx, false))
+	state, _ = buffer.getGlobalState(ctx)
+	assert.NotContains(t, state, client.SweepKey)
+
+	sum, err := NewAlgorandBufferWithSigner(c, client.NewAccountSigner(admin), WithChecksum())
+	assert.Nil(t, err)
+	assert.NotNil(t, sum.EnableSweeping(ctx, true))
+}
+
+func TestSweepExpired(t *testing.T) {
+	admin, anyone := crypto.GenerateAccount(), crypto.GenerateAccount()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBufferWithSigner(c, client.NewAccountSigner(admin), WithOnChainExpiry())
+	assert.Nil(t, err)
+	ctx := context.Background()
+	sweeper := client.NewAccountSigner(anyone)
+
+	assert.Nil(t, buffer.PutWithTTL(ctx, map[string]string{"1001": "OG"}, -time.Hour))
+	assert.Nil(t, buffer.PutWithTTL(ctx, map[string]string{"1002": "G2"}, time.Hour))
+	assert.Nil(t, buffer.PutWithTTL(ctx, map[string]string{"1003": "NiP"}, -time.Second))
+	assert.Nil(t, buffer.PutElements(ctx, map[string]string{"1004": "FaZe"}))
+
+	_, err = SweepExpired(ctx, c, sweeper, buffer.AppId)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, buffer.EnableSweeping(ctx, true))
+	keys, err := SweepExpired(ctx, c, sweeper, buffer.AppId)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1001"}, keys)
+	d, _ := buffer.GetBuffer(ctx)
+	assert.Equal(t, map[string]string{"1002": "G2", "1003": "NiP", "1004": "FaZe"}, d)
+
+	// the contract refuses keys that didn't expire, and skips missing ones
+	assert.True(t, client.IsRejected(c.SweepGlobals(sweeper, buffer.AppId, "1002")))
+	assert.True(t, client.IsRejected(c.SweepGlobals(sweeper, buffer.AppId, "1004")))
+	assert.True(t, client.IsRejected(c.SweepGlobals(sweeper, buffer.AppId, client.SweepKey)))
+	assert.Nil(t, c.SweepGlobals(sweeper, buffer.AppId, "1001", "1003"))
+	d, _ = buffer.GetBuffer(ctx)
+	assert.Equal(t, map[string]string{"1002": "G2", "1004": "FaZe"}, d)
+}
+
+// A writer restricted to a prefix sweeps only its own keys
+func TestAlgorandBuffer_SweepWriterPrefix(t *testing.T) {
+	admin, feeder := crypto.GenerateAccount(), crypto.GenerateAccount()
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBufferWithSigner(c, client.NewAccountSigner(admin), WithOnChainExpiry())
+	assert.Nil(t, err)
+	ctx := context.Background()
+	assert.Nil(t, buffer.AddWriter(ctx, feeder.Address, "feed:"))
+	w, err := NewAlgorandBufferWithSigner(c, client.NewAccountSigner(feeder), WithApplication(buffer.AppId), WithOnChainExpiry())
+	assert.Nil(t, err)
+
+	assert.Nil(t, buffer.PutWithTTL(ctx, map[string]string{"1001": "OG"}, -time.Second))
+	assert.Nil(t, w.PutWithTTL(ctx, map[string]string{"feed:btc": "64000"}, -time.Second))
+
+	keys, err := w.Sweep(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"feed:btc"}, keys)
+	keys, err = buffer.Sweep(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1001"}, keys)
+}
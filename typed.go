@@ -0,0 +1,97 @@
+package siam
+
+import (
+	"context"
+	"sort"
+)
+
+// RawBuffer is a key-value store with []byte values. It is implemented by
+// AlgorandBuffer and Namespace.
+type RawBuffer interface {
+	GetBufferRaw(ctx context.Context) (map[string][]byte, error)
+	PutElementsRaw(ctx context.Context, data map[string][]byte) error
+	DeleteElements(ctx context.Context, keys ...string) error
+}
+
+// TypedBuffer stores values of type T in a RawBuffer, using a Codec to convert values
+// to and from bytes:
+//
+//	prices := siam.NewTypedBuffer[float64](buffer, siam.FixedPoint{Decimals: 4})
+//	err = prices.Put(ctx, map[string]float64{"ALGO/USD": 0.1834})
+type TypedBuffer[T any] struct {
+	raw   RawBuffer
+	codec Codec[T]
+}
+
+// NewTypedBuffer creates a TypedBuffer that stores values of type T in the given buffer.
+func NewTypedBuffer[T any](raw RawBuffer, codec Codec[T]) *TypedBuffer[T] {
+	return &TypedBuffer[T]{raw: raw, codec: codec}
+}
+
+// Get returns the decoded value of the given key. ok is false if the key doesn't exist.
+func (tb *TypedBuffer[T]) Get(ctx context.Context, key string) (value T, ok bool, err error) {
+	data, err := tb.raw.GetBufferRaw(ctx)
+	if err != nil {
+		return value, false, err
+	}
+	b, ok := data[key]
+	if !ok {
+		return value, false, nil
+	}
+	value, err = tb.codec.Decode(b)
+	return value, err == nil, err
+}
+
+// GetAll returns all decoded pairs of the buffer. Returns an error if any value can't
+// be decoded.
+func (tb *TypedBuffer[T]) GetAll(ctx context.Context) (map[string]T, error) {
+	data, err := tb.raw.GetBufferRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]T, len(data))
+	for k, b := range data {
+		if m[k], err = tb.codec.Decode(b); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Put encodes and stores the given pairs. If an encoded pair exceeds MaxPairSize, an
+// *ErrPairTooLarge is returned and nothing is written.
+func (tb *TypedBuffer[T]) Put(ctx context.Context, data map[string]T) error {
+	raw, err := tb.encode(data)
+	if err != nil {
+		return err
+	}
+	return tb.raw.PutElementsRaw(ctx, raw)
+}
+
+// Delete removes the given keys from the buffer.
+func (tb *TypedBuffer[T]) Delete(ctx context.Context, keys ...string) error {
+	return tb.raw.DeleteElements(ctx, keys...)
+}
+
+// encode encodes all values of data. Keys are encoded in sorted order, so that the
+// same error is returned for the same input.
+func (tb *TypedBuffer[T]) encode(data map[string]T) (map[string][]byte, error) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	raw := make(map[string][]byte, len(data))
+	for _, k := range keys {
+		b, err := tb.codec.Encode(data[k])
+		if err != nil {
+			return nil, err
+		}
+		if len(k)+len(b) > MaxPairSize {
+			return nil, &ErrPairTooLarge{Key: k, Size: len(k) + len(b)}
+		}
+		raw[k] = b
+	}
+	return raw, nil
+}
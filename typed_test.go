@@ -0,0 +1,115 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type match struct {
+	Id     uint64 `proto:"1" json:"id" codec:"i"`
+	Winner string `proto:"2" json:"winner" codec:"w"`
+	Diff   int32  `proto:"3,zigzag" json:"diff" codec:"d"`
+	Final  bool   `proto:"5" json:"final" codec:"f"`
+	Note   string `json:"-" codec:"-"`
+}
+
+func TestTypedBuffer_Codecs(t *testing.T) {
+	m := match{Id: 1000, Winner: "Astralis", Diff: -3, Final: true}
+	codecs := map[string]Codec[match]{
+		"json":    JSONCodec[match]{},
+		"msgpack": MsgpackCodec[match]{},
+		"proto":   ProtoCodec[match]{},
+	}
+	for name, c := range codecs {
+		t.Run(name, func(t *testing.T) {
+			buffer, _ := NewAlgorandBuffer(client.CreateAlgorandClientMock("", ""), client.GeneratePrivateKey64())
+			tb := NewTypedBuffer[match](buffer, c)
+			assert.Nil(t, tb.Put(context.Background(), map[string]match{"m": m}))
+
+			v, ok, err := tb.Get(context.Background(), "m")
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, m, v)
+
+			_, ok, err = tb.Get(context.Background(), "missing")
+			assert.Nil(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+// Encoding must be compatible with protobuf, e.g. the example from the protobuf docs
+func TestProtoCodec_WireFormat(t *testing.T) {
+	type test1 struct {
+		A int32 `proto:"1"`
+	}
+	b, err := ProtoCodec[test1]{}.Encode(test1{A: 150})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x08, 0x96, 0x01}, b)
+
+	// unknown fields (here field 2 as string, field 3 as fixed32) are skipped
+	v, err := ProtoCodec[test1]{}.Decode([]byte{0x12, 0x01, 'x', 0x1d, 0, 0, 0, 0, 0x08, 0x96, 0x01})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 150, v.A)
+
+	_, err = ProtoCodec[test1]{}.Decode([]byte{0x08})
+	assert.NotNil(t, err)
+	_, err = ProtoCodec[int]{}.Encode(5)
+	assert.NotNil(t, err)
+}
+
+func TestFixedPoint(t *testing.T) {
+	f := FixedPoint{Decimals: 4}
+	b, err := f.Encode(0.1834)
+	assert.Nil(t, err)
+	assert.Equal(t, itob(1834), b)
+
+	v, err := f.Decode(b)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.1834, v)
+
+	b, _ = f.Encode(-2.5)
+	v, _ = f.Decode(b)
+	assert.Equal(t, -2.5, v)
+
+	_, err = f.Encode(1e20)
+	assert.NotNil(t, err)
+	_, err = f.Decode([]byte{1})
+	assert.NotNil(t, err)
+}
+
+func TestTypedBuffer_PairTooLarge(t *testing.T) {
+	buffer, _ := NewAlgorandBuffer(client.CreateAlgorandClientMock("", ""), client.GeneratePrivateKey64())
+	tb := NewTypedBuffer[string](buffer, JSONCodec[string]{})
+
+	err := tb.Put(context.Background(), map[string]string{
+		"a": "ok",
+		"b": strings.Repeat("x", MaxPairSize),
+	})
+	assert.IsType(t, &ErrPairTooLarge{}, err)
+	assert.Equal(t, "b", err.(*ErrPairTooLarge).Key)
+
+	data, _ := buffer.GetBuffer(context.Background())
+	assert.Empty(t, data)
+}
+
+func TestTypedBuffer_Namespace(t *testing.T) {
+	buffer, _ := NewAlgorandBuffer(client.CreateAlgorandClientMock("", ""), client.GeneratePrivateKey64())
+	ns, _ := buffer.Namespace("px/")
+	prices := NewTypedBuffer[float64](ns, FixedPoint{Decimals: 2})
+	assert.Nil(t, prices.Put(context.Background(), map[string]float64{"ALGO": 0.18, "BTC": 20000}))
+
+	all, err := prices.GetAll(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"ALGO": 0.18, "BTC": 20000}, all)
+
+	assert.Nil(t, prices.Delete(context.Background(), "BTC"))
+	data, _ := buffer.GetBufferRaw(context.Background())
+	assert.Equal(t, map[string][]byte{"px/ALGO": itob(18)}, data)
+}
@@ -2,10 +2,12 @@ package siam
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -51,6 +53,29 @@ func partitionMapByte(data map[string][]byte, size int) []map[string][]byte {
 	return partitions
 }
 
+// parseGlobalState returns the global state of the given application, with decoded
+// keys and values.
+func parseGlobalState(app models.Application) map[string][]byte {
+	m := make(map[string][]byte, len(app.Params.GlobalState))
+	for _, kv := range app.Params.GlobalState {
+		decodedKey, _ := base64.StdEncoding.DecodeString(kv.Key)
+		decodedVal, _ := base64.StdEncoding.DecodeString(kv.Value.Bytes)
+		m[string(decodedKey)] = decodedVal
+	}
+	return m
+}
+
+// toTealKeyValues converts a map into an array of TEAL key-value pairs, as expected
+// by client.AlgorandClient.StoreGlobals.
+func toTealKeyValues(m map[string][]byte) []models.TealKeyValue {
+	kvArray := make([]models.TealKeyValue, 0, len(m))
+	for k, v := range m {
+		tkv := models.TealKeyValue{Key: k, Value: models.TealValue{Bytes: string(v)}}
+		kvArray = append(kvArray, tkv)
+	}
+	return kvArray
+}
+
 func getKeys(m map[string]string) []string {
 	s := make([]string, len(m))
 	i := 0
@@ -61,6 +86,24 @@ func getKeys(m map[string]string) []string {
 	return s
 }
 
+// getKeysByte returns the keys of m.
+func getKeysByte(m map[string][]byte) []string {
+	s := make([]string, 0, len(m))
+	for k := range m {
+		s = append(s, k)
+	}
+	return s
+}
+
+// toBytes converts the values of m to []byte.
+func toBytes(m map[string]string) map[string][]byte {
+	b := make(map[string][]byte, len(m))
+	for k, v := range m {
+		b[k] = []byte(v)
+	}
+	return b
+}
+
 // computeOverlap returns two maps, m1 and m2. m1 contains the map entries of x, for
 // which the keys either don't exist in y, or do exist but with different values than
 // in x. m2 contains map entries of y that don't exist in x.
@@ -92,13 +135,13 @@ func createBufferAndRemoveApps(t *testing.T) *AlgorandBuffer {
 		t.Fatal(err)
 	}
 
-	err = buffer.Client.DeleteApplication(buffer.AccountCrypt, buffer.AppId)
+	err = buffer.Client.DeleteApplication(buffer.Signer, buffer.AppId)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Verify that app has 0 apps
-	info, err := buffer.Client.AccountInformation(buffer.AccountCrypt.Address.String(), context.Background())
+	info, err := buffer.Client.AccountInformation(buffer.Signer.Address().String(), context.Background())
 	assert.Nil(t, err)
 	assert.Equal(t, 0, len(info.CreatedApps))
 
@@ -0,0 +1,128 @@
+package siam
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// Change describes a key that was created, updated or deleted.
+type Change struct {
+	Key string `json:"key"`
+	// Value is the new value. nil if the key was deleted.
+	Value   []byte `json:"value,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// EncodedChange is a Change with its value encoded for JSON messages, like those of the
+// gateway stream and webhooks. Values that aren't valid UTF-8 are base64 encoded in
+// ValueB64 instead of Value, since JSON strings can't hold them.
+type EncodedChange struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	ValueB64 string `json:"value_b64,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// Encode returns the EncodedChange of c.
+func (c Change) Encode() EncodedChange {
+	e := EncodedChange{Key: c.Key, Deleted: c.Deleted}
+	if utf8.Valid(c.Value) {
+		e.Value = string(c.Value)
+	} else {
+		e.ValueB64 = base64.StdEncoding.EncodeToString(c.Value)
+	}
+	return e
+}
+
+// ChangeSet contains the changes observed by a single poll of Watch.
+type ChangeSet struct {
+	// Round is the last round of the node before the state was read. The state
+	// includes all changes up to this round.
+	Round uint64    `json:"round"`
+	Time  time.Time `json:"time"`
+	// Changes are sorted by key.
+	Changes []Change `json:"changes"`
+	// Err is set if the poll failed. The next poll retries.
+	Err error `json:"-"`
+}
+
+// Watch polls the application every interval, and sends the observed changes to the
+// returned channel. The first successful poll is always sent and contains all existing
+// keys. Later polls without changes are not sent. The channel is closed when ctx is cancelled.
+//
+// Changes between two polls are coalesced: if a key is updated twice within one
+// interval, only the last value is reported.
+func (r *Reader) Watch(ctx context.Context, interval time.Duration) <-chan ChangeSet {
+	ch := make(chan ChangeSet, 16)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last map[string][]byte
+		for {
+			cs, state := r.poll(ctx, last)
+			// the first successful poll is sent even if the application is empty
+			send := cs.Err != nil || len(cs.Changes) > 0 || last == nil
+			if cs.Err == nil {
+				last = state
+			}
+			if send {
+				select {
+				case ch <- cs:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch
+}
+
+// poll reads the state and returns the changes since the given state.
+func (r *Reader) poll(ctx context.Context, last map[string][]byte) (ChangeSet, map[string][]byte) {
+	cs := ChangeSet{Time: time.Now()}
+	sctx, cancel := context.WithTimeout(ctx, r.timeoutLength)
+	status, err := r.Client.Status(sctx)
+	cancel()
+	if err != nil {
+		cs.Err = err
+		return cs, nil
+	}
+	cs.Round = status.LastRound
+
+	state, err := r.GetBufferRaw(ctx)
+	if err != nil {
+		cs.Err = err
+		return cs, nil
+	}
+	cs.Changes = diffStates(last, state)
+	return cs, state
+}
+
+// diffStates returns the changes that turn state a into state b, sorted by key.
+func diffStates(a, b map[string][]byte) []Change {
+	changes := make([]Change, 0)
+	for k, v := range b {
+		if old, ok := a[k]; !ok || !bytes.Equal(old, v) {
+			changes = append(changes, Change{Key: k, Value: v})
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			changes = append(changes, Change{Key: k, Deleted: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
@@ -0,0 +1,52 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader_Watch(t *testing.T) {
+	c := client.CreateAlgorandClientMock("", "")
+	buffer, _ := NewAlgorandBuffer(c, client.GeneratePrivateKey64())
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1000": "Astralis", "1001": "OG"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := NewReader(c, buffer.AppId).Watch(ctx, 10*time.Millisecond)
+
+	// the first set is a snapshot of all keys
+	cs := <-changes
+	assert.Nil(t, cs.Err)
+	assert.Equal(t, []Change{{Key: "1000", Value: []byte("Astralis")}, {Key: "1001", Value: []byte("OG")}}, cs.Changes)
+
+	assert.Nil(t, buffer.PutElements(context.Background(), map[string]string{"1001": "G2"}))
+	assert.Nil(t, buffer.DeleteElements(context.Background(), "1000"))
+	var got []Change
+	for len(got) < 2 {
+		cs = <-changes
+		assert.Nil(t, cs.Err)
+		got = append(got, cs.Changes...)
+	}
+	assert.ElementsMatch(t, []Change{{Key: "1000", Deleted: true}, {Key: "1001", Value: []byte("G2")}}, got)
+
+	cancel()
+	for range changes {
+	}
+}
+
+func TestDiffStates(t *testing.T) {
+	a := map[string][]byte{"a": []byte("1"), "b": []byte("2")}
+	b := map[string][]byte{"b": []byte("3"), "c": []byte("4")}
+	assert.Equal(t, []Change{
+		{Key: "a", Deleted: true},
+		{Key: "b", Value: []byte("3")},
+		{Key: "c", Value: []byte("4")},
+	}, diffStates(a, b))
+	assert.Empty(t, diffStates(b, b))
+}
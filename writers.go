@@ -0,0 +1,138 @@
+package siam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/algorand/go-algorand-sdk/types"
+
+	"github.com/m2q/algo-siam/client"
+)
+
+// WithApplication makes the buffer write to the application with the given ID instead
+// of an application of its own account. No application is created or deleted. The
+// account of the signer must be the creator of the application, or one of its writers
+// (see AddWriter); otherwise *ErrNotWriter is returned.
+//
+// A writer whose keys are restricted to a prefix can't maintain reserved keys, so
+// WithMetadata and WithChecksum can't be used with it.
+func WithApplication(appId uint64) BufferOption {
+	return func(ab *AlgorandBuffer) error {
+		if appId == 0 {
+			return errors.New("invalid application ID 0")
+		}
+		ab.AppId = appId
+		return nil
+	}
+}
+
+// useApplication checks that the signer may write to the application of AppId, and
+// loads its writer prefix.
+func (ab *AlgorandBuffer) useApplication(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, ab.timeoutLength)
+	app, err := ab.Client.GetApplicationByID(ab.AppId, ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if !client.FulfillsSchema(app) {
+		return fmt.Errorf("application %d doesn't fulfil the schema of the contract", ab.AppId)
+	}
+	if err = ab.checkContract(ctx, app); err != nil {
+		return err
+	}
+	addr := ab.Signer.Address()
+	if app.Params.Creator == addr.String() {
+		return nil
+	}
+	state := parseGlobalState(app)
+	prefix, ok := state[client.WriterKey(addr)]
+	if !ok {
+		return &ErrNotWriter{Address: addr, AppId: ab.AppId}
+	}
+	if len(prefix) > 0 && len(ab.reservedKeys()) > 0 {
+		return fmt.Errorf("writer is restricted to the prefix %q and can't maintain reserved keys", prefix)
+	}
+	if _, ok = state[ChecksumKey]; ok && len(prefix) > 0 {
+		return ErrWriterChecksum
+	}
+	if _, ok = state[ChecksumKey]; ok && !ab.checksum {
+		return fmt.Errorf("application %d has a checksum, the writer must use WithChecksum", ab.AppId)
+	}
+	ab.writer, ab.writerPrefix = true, string(prefix)
+	return nil
+}
+
+// AddWriter allows the account of addr to write to the buffer's application. If prefix
+// isn't empty, the writer can only write and delete keys that start with it. The
+// contract enforces both. Adding an existing writer replaces its prefix.
+//
+// Every writer occupies one slot of the application. Only the creator of the
+// application can add writers, otherwise ErrNotCreator is returned. Writers with a
+// prefix can't update the checksum, so they can't be added to an application with a
+// ChecksumKey, or by a buffer using WithChecksum; ErrWriterChecksum is returned.
+func (ab *AlgorandBuffer) AddWriter(ctx context.Context, addr types.Address, prefix string) error {
+	if ab.writer {
+		return ErrNotCreator
+	}
+	if isReserved(prefix) {
+		return &ErrReservedKey{Key: prefix}
+	}
+	key := client.WriterKey(addr)
+	if len(key)+len(prefix) > MaxPairSize {
+		return &ErrPairTooLarge{Key: key, Size: len(key) + len(prefix)}
+	}
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := state[ChecksumKey]; prefix != "" && (ok || ab.checksum) {
+		return ab.writeFailed(nil, map[string][]byte{key: []byte(prefix)}, ErrWriterChecksum)
+	}
+	if err = checkCapacity(ab.withReserved(state), []string{key}, nil); err != nil {
+		return ab.writeFailed(nil, map[string][]byte{key: []byte(prefix)}, err)
+	}
+	return ab.commit(ctx, []txnOp{{put: map[string][]byte{key: []byte(prefix)}}})
+}
+
+// RemoveWriter revokes the write access of the account of addr. Removing an address
+// that isn't a writer does nothing. Only the creator of the application can remove
+// writers, otherwise ErrNotCreator is returned.
+func (ab *AlgorandBuffer) RemoveWriter(ctx context.Context, addr types.Address) error {
+	if ab.writer {
+		return ErrNotCreator
+	}
+	return ab.commit(ctx, []txnOp{{del: []string{client.WriterKey(addr)}}})
+}
+
+// Writers returns the writers of the buffer's application, mapped to the prefix they
+// are restricted to. The prefix is empty for writers without restriction.
+func (ab *AlgorandBuffer) Writers(ctx context.Context) (map[types.Address]string, error) {
+	state, err := ab.getGlobalState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseWriters(state), nil
+}
+
+// WriterPrefix returns the prefix the buffer's writes are restricted to. It is empty
+// for the creator of the application and for unrestricted writers.
+func (ab *AlgorandBuffer) WriterPrefix() string {
+	return ab.writerPrefix
+}
+
+// parseWriters returns the writers of the given global state.
+func parseWriters(state map[string][]byte) map[types.Address]string {
+	writers := make(map[types.Address]string)
+	for k, v := range state {
+		if !strings.HasPrefix(k, client.WriterPrefix) || len(k) != len(client.WriterPrefix)+len(types.Address{}) {
+			continue
+		}
+		var addr types.Address
+		copy(addr[:], k[len(client.WriterPrefix):])
+		writers[addr] = string(v)
+	}
+	return writers
+}
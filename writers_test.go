@@ -0,0 +1,125 @@
+//go:build unit
+
+package siam
+
+import (
+	"context"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/m2q/algo-siam/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriters(t *testing.T) {
+	admin, feeder, other := crypto.GenerateAccount(), crypto.GenerateAccount(), crypto.GenerateAccount()
+	algod := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(admin))
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	_, err = NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(feeder), WithApplication(buffer.AppId))
+	assert.EqualError(t, err, "account "+feeder.Address.String()+" is not a writer of application 4512")
+
+	assert.Nil(t, buffer.AddWriter(ctx, feeder.Address, "feed:"))
+	assert.Nil(t, buffer.AddWriter(ctx, other.Address, ""))
+	writers, err := buffer.Writers(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[types.Address]string{feeder.Address: "feed:", other.Address: ""}, writers)
+	data, _ := buffer.GetBuffer(ctx)
+	assert.Empty(t, data)
+
+	w, err := NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(feeder), WithApplication(buffer.AppId))
+	assert.Nil(t, err)
+	assert.Equal(t, "feed:", w.WriterPrefix())
+	assert.Nil(t, w.PutElements(ctx, map[string]string{"feed:btc": "64000"}))
+	assert.IsType(t, &ErrWriterPrefix{}, w.PutElements(ctx, map[string]string{"btc": "64000"}))
+	assert.IsType(t, &ErrWriterPrefix{}, w.DeleteElements(ctx, "btc"))
+	assert.Equal(t, ErrNotCreator, w.AddWriter(ctx, feeder.Address, ""))
+	assert.Equal(t, ErrNotCreator, w.RemoveWriter(ctx, other.Address))
+
+	// the contract rejects keys outside the prefix, even if the client doesn't check
+	w.writerPrefix = ""
+	assert.NotNil(t, w.PutElements(ctx, map[string]string{"btc": "64000"}))
+
+	// writers can't write checksums, metadata or the keys of the creator
+	feed := client.NewAccountSigner(feeder)
+	for _, key := range []string{ChecksumKey, MetaVersionKey, MetaHeartbeatKey, client.SweepKey, client.WriterKey(feeder.Address)} {
+		kv := toTealKeyValues(map[string][]byte{key: []byte("1")})
+		assert.True(t, client.IsRejected(algod.UpdateGlobals(feed, buffer.AppId, nil, kv)), key)
+		assert.True(t, client.IsRejected(algod.DeleteGlobals(feed, buffer.AppId, key)), key)
+	}
+	for _, key := range []string{client.SweepKey, client.WriterKey(other.Address)} {
+		kv := toTealKeyValues(map[string][]byte{key: []byte("")})
+		assert.True(t, client.IsRejected(algod.UpdateGlobals(client.NewAccountSigner(other), buffer.AppId, nil, kv)), key)
+	}
+
+	// writers with a prefix can't maintain reserved keys
+	_, err = NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(feeder), WithApplication(buffer.AppId), WithMetadata())
+	assert.NotNil(t, err)
+	u, err := NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(other), WithApplication(buffer.AppId), WithMetadata())
+	assert.Nil(t, err)
+	assert.Nil(t, u.PutElements(ctx, map[string]string{"eth": "3000"}))
+
+	assert.Nil(t, buffer.RemoveWriter(ctx, feeder.Address))
+	assert.True(t, client.IsRejected(w.PutElements(ctx, map[string]string{"feed:btc": "65000"})))
+	_, err = NewAlgorandBufferWithSigner(algod, feed, WithApplication(buffer.AppId))
+	assert.IsType(t, &ErrNotWriter{}, err)
+	data, _ = buffer.GetBuffer(ctx)
+	assert.Equal(t, map[string]string{"feed:btc": "64000", "eth": "3000"}, data)
+
+	assert.IsType(t, &ErrReservedKey{}, buffer.AddWriter(ctx, feeder.Address, ReservedPrefix))
+}
+
+// Writers with a prefix can't update the checksum, so they must not be combined with it
+func TestWriters_Checksum(t *testing.T) {
+	admin, feeder, other := crypto.GenerateAccount(), crypto.GenerateAccount(), crypto.GenerateAccount()
+	algod := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(admin), WithChecksum())
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	assert.Equal(t, ErrWriterChecksum, buffer.AddWriter(ctx, feeder.Address, "feed:"))
+	assert.Nil(t, buffer.AddWriter(ctx, other.Address, ""))
+	assert.Nil(t, buffer.PutElements(ctx, map[string]string{"btc": "64000"}))
+
+	// unrestricted writers must maintain the checksum too
+	_, err = NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(other), WithApplication(buffer.AppId))
+	assert.NotNil(t, err)
+	w, err := NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(other), WithApplication(buffer.AppId), WithChecksum())
+	assert.Nil(t, err)
+	assert.Nil(t, w.PutElements(ctx, map[string]string{"eth": "3000"}))
+	data, err := buffer.GetBuffer(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"btc": "64000", "eth": "3000"}, data)
+
+	// a buffer without checksum can't add them either, once the application has one
+	plain, err := NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(admin), WithApplication(buffer.AppId))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrWriterChecksum, plain.AddWriter(ctx, feeder.Address, "feed:"))
+
+	// writers with a prefix that were added before block checksum writes
+	algod = client.CreateAlgorandClientMock("", "")
+	plain, _ = NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(admin))
+	assert.Nil(t, plain.AddWriter(ctx, feeder.Address, "feed:"))
+	buffer, err = NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(admin), WithChecksum())
+	assert.Nil(t, err)
+	assert.Equal(t, ErrWriterChecksum, buffer.PutElements(ctx, map[string]string{"btc": "65000"}))
+}
+
+func TestConfigApplication(t *testing.T) {
+	clearEnv(t)
+	admin, feeder := crypto.GenerateAccount(), crypto.GenerateAccount()
+	algod := client.CreateAlgorandClientMock("", "")
+	buffer, err := NewAlgorandBufferWithSigner(algod, client.NewAccountSigner(admin))
+	assert.Nil(t, err)
+	assert.Nil(t, buffer.AddWriter(context.Background(), feeder.Address, "feed:"))
+
+	key, _ := FormatBase64.Encode(feeder.PrivateKey)
+	cfg := &Config{Key: KeyConfig{PrivateKey: key}, Application: buffer.AppId}
+	w, err := NewAlgorandBufferWithConfig(algod, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, buffer.AppId, w.AppId)
+	assert.Equal(t, "feed:", w.WriterPrefix())
+}